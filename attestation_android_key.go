@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+)
+
+// idAndroidKeyDescription is the Android key attestation certificate extension
+var idAndroidKeyDescription = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 17}
+
+// Keymaster tag values checked during android-key verification
+const (
+	kmOriginGenerated = 0
+	kmPurposeSign     = 2
+)
+
+// ParseAndroidKeyDescription parses the key description extension of an Android
+// attestation certificate. It returns nil when the certificate doesn't have one.
+func ParseAndroidKeyDescription(cert *x509.Certificate) (*req.AndroidKeyDescription, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idAndroidKeyDescription) {
+			continue
+		}
+		kd := &req.AndroidKeyDescription{}
+		_, err := asn1.Unmarshal(ext.Value, kd)
+		if err != nil {
+			fmt.Println("Error unmarshalling Android key description:", err)
+			return nil, errors.New("Error unmarshalling Android key description")
+		}
+		return kd, nil
+	}
+	return nil, nil
+}
+
+// VerifyAndroidKeyAttestation - Verify an attestation statement in the "android-key" format.
+// The Android key attestation statement looks like:
+//
+//	{
+//		alg: COSEAlgorithmIdentifier,
+//		sig: bytes,
+//		x5c: [ credCert: bytes, * (caCert: bytes) ]
+//	}
+func VerifyAndroidKeyAttestation(authData *req.DecodedAuthData, clientDataHash []byte) (bool, error) {
+	attStmt := authData.AttStatement
+
+	// Step 1. Verify that attStmt is valid CBOR conforming to the syntax
+	// defined above and perform CBOR decoding on it to extract the
+	// contained fields.
+	if attStmt.Certificate == nil {
+		return false, errors.New("Android key attestation statement is missing x5c")
+	}
+	credCert := attStmt.Certificate
+
+	// Step 2. Verify that sig is a valid signature over the concatenation of
+	// authenticatorData and clientDataHash using the public key in the first
+	// certificate in x5c with the algorithm specified in alg.
+	signedData := append(append([]byte{}, authData.RawAuthData...), clientDataHash...)
+	err := credCert.CheckSignature(models.COSESignatureAlgorithm(attStmt.Algorithm), signedData, attStmt.Signature)
+	if err != nil {
+		fmt.Println("Android key attestation signature error:", err)
+		return false, errors.New("Android key attestation signature is invalid")
+	}
+
+	// Step 3. Verify that the public key in the first certificate in x5c
+	// matches the credentialPublicKey in the attestedCredentialData in
+	// authenticatorData.
+	if !CredentialPublicKeyMatches(authData, credCert.PublicKey) {
+		return false, errors.New("Android key attestation certificate does not match the credential public key")
+	}
+
+	// Step 4. Verify that the attestationChallenge field in the attestation
+	// certificate extension data is identical to clientDataHash.
+	kd := attStmt.AndroidKeyDescription
+	if kd == nil {
+		return false, errors.New("Android key attestation certificate is missing the key description")
+	}
+	if !bytes.Equal(kd.AttestationChallenge, clientDataHash) {
+		return false, errors.New("Android key attestation challenge does not match the client data hash")
+	}
+
+	// Step 5. Verify the following using the appropriate authorization list
+	// from the attestation certificate extension data:
+	err = checkAndroidAuthorizationLists(kd)
+	if err != nil {
+		return false, err
+	}
+
+	// Step 6. If successful, return implementation-specific values
+	// representing attestation type Basic and attestation trust path x5c.
+	return true, nil
+}
+
+// checkAndroidAuthorizationLists - the authorization list rules for android-key.
+// We accept keys from software as well as from a TEE, so the union of
+// softwareEnforced and teeEnforced is checked. Policies that want a TEE or
+// StrongBox can look at the security level of the key description.
+func checkAndroidAuthorizationLists(kd *req.AndroidKeyDescription) error {
+	// The AuthorizationList.allApplications field is not present on either
+	// authorization list (softwareEnforced nor teeEnforced), since
+	// PublicKeyCredential MUST be scoped to the RP ID.
+	if kd.SoftwareEnforced.HasAllApplications() || kd.TeeEnforced.HasAllApplications() {
+		return errors.New("Android key is not scoped to the RP, allApplications is set")
+	}
+
+	// The value in the AuthorizationList.origin field is equal to KM_ORIGIN_GENERATED.
+	origins := []int{kd.SoftwareEnforced.Origin, kd.TeeEnforced.Origin}
+	generated := false
+	for _, origin := range origins {
+		switch origin {
+		case -1:
+			// Not present in this list
+		case kmOriginGenerated:
+			generated = true
+		default:
+			return fmt.Errorf("Android key origin %d is not KM_ORIGIN_GENERATED", origin)
+		}
+	}
+	if !generated {
+		return errors.New("Android key origin is not KM_ORIGIN_GENERATED")
+	}
+
+	// The value in the AuthorizationList.purpose field is equal to KM_PURPOSE_SIGN.
+	purposes := append(append([]int{}, kd.SoftwareEnforced.Purpose...), kd.TeeEnforced.Purpose...)
+	if len(purposes) == 0 {
+		return errors.New("Android key purpose is not KM_PURPOSE_SIGN")
+	}
+	for _, purpose := range purposes {
+		if purpose != kmPurposeSign {
+			return fmt.Errorf("Android key purpose %d is not KM_PURPOSE_SIGN", purpose)
+		}
+	}
+
+	return nil
+}
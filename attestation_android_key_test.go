@@ -0,0 +1,86 @@
+package main
+
+import (
+	req "git.jba.io/go/webauthn/request"
+)
+
+var androidKeyFixtures = []string{"android_key_1", "android_key_2", "android_key_3"}
+
+func (as *AttestationSuite) TestAndroidKeyAttestation() {
+	for _, name := range androidKeyFixtures {
+		authData, clientDataHash := as.loadAttestationFixture(name)
+		if authData.Format != "android-key" {
+			as.T().Fatalf("Unexpected fixture format for %s: %s", name, authData.Format)
+		}
+		valid, err := VerifyAndroidKeyAttestation(&authData, clientDataHash)
+		if err != nil || !valid {
+			as.T().Fatalf("Expected valid android-key attestation for %s. Got: %v, %s", name, valid, err)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestAndroidKeyDescriptionParsed() {
+	authData, clientDataHash := as.loadAttestationFixture("android_key_1")
+	kd := authData.AttStatement.AndroidKeyDescription
+	if kd == nil {
+		as.T().Fatalf("Expected the key description to be parsed from the attestation certificate")
+	}
+	if string(kd.AttestationChallenge) != string(clientDataHash) {
+		as.T().Fatalf("Unexpected attestation challenge %x", kd.AttestationChallenge)
+	}
+	if kd.SecurityLevel().String() == "Unknown" {
+		as.T().Fatalf("Unexpected security level %d", kd.AttestationSecurityLevel)
+	}
+}
+
+func (as *AttestationSuite) TestAndroidKeyAttestationWrongChallenge() {
+	authData, clientDataHash := as.loadAttestationFixture("android_key_1")
+	kd := *authData.AttStatement.AndroidKeyDescription
+	kd.AttestationChallenge = []byte("some other challenge")
+	authData.AttStatement.AndroidKeyDescription = &kd
+	_, err := VerifyAndroidKeyAttestation(&authData, clientDataHash)
+	if err == nil {
+		as.T().Fatalf("Expected an error for a mismatched attestation challenge")
+	}
+}
+
+func (as *AttestationSuite) TestAndroidAuthorizationLists() {
+	valid := func() *req.AndroidKeyDescription {
+		return &req.AndroidKeyDescription{
+			SoftwareEnforced: req.AndroidAuthorizationList{Origin: -1},
+			TeeEnforced:      req.AndroidAuthorizationList{Origin: kmOriginGenerated, Purpose: []int{kmPurposeSign}},
+		}
+	}
+	err := checkAndroidAuthorizationLists(valid())
+	if err != nil {
+		as.T().Fatalf("Unexpected error for valid authorization lists: %s", err)
+	}
+
+	imported := valid()
+	imported.TeeEnforced.Origin = 2
+	err = checkAndroidAuthorizationLists(imported)
+	if err == nil {
+		as.T().Fatalf("Expected an error for an imported key")
+	}
+
+	noOrigin := valid()
+	noOrigin.TeeEnforced.Origin = -1
+	err = checkAndroidAuthorizationLists(noOrigin)
+	if err == nil {
+		as.T().Fatalf("Expected an error for a key without an origin")
+	}
+
+	decrypt := valid()
+	decrypt.TeeEnforced.Purpose = []int{kmPurposeSign, 1}
+	err = checkAndroidAuthorizationLists(decrypt)
+	if err == nil {
+		as.T().Fatalf("Expected an error for a key that isn't only for signing")
+	}
+
+	allApps := valid()
+	allApps.SoftwareEnforced.AllApplications.FullBytes = []byte{0xbf, 0x84, 0x58, 0x02, 0x05, 0x00}
+	err = checkAndroidAuthorizationLists(allApps)
+	if err == nil {
+		as.T().Fatalf("Expected an error for a key usable by all applications")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	req "git.jba.io/go/webauthn/request"
+)
+
+// idAppleNonce is the certificate extension Apple puts the attestation nonce in
+var idAppleNonce = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 8, 2}
+
+// appleRoots are the roots an Apple attestation certificate must chain up to,
+// loaded from the Apple WebAuthn root CA file configured with apple_root_ca
+var appleRoots *x509.CertPool
+
+// appleNonceExtension is the value of the Apple nonce extension
+type appleNonceExtension struct {
+	Nonce []byte `asn1:"tag:1,explicit"`
+}
+
+// VerifyAppleAttestation - Verify an attestation statement in the "apple" format.
+// The Apple anonymous attestation statement looks like:
+//
+//	{
+//		x5c: [ credCert: bytes, * (caCert: bytes) ]
+//	}
+func VerifyAppleAttestation(authData *req.DecodedAuthData, clientDataHash []byte) (bool, error) {
+	attStmt := authData.AttStatement
+
+	// Step 1. Verify that attStmt is valid CBOR conforming to the syntax
+	// defined above and perform CBOR decoding on it to extract the
+	// contained fields.
+	if attStmt.Certificate == nil {
+		return false, errors.New("Apple attestation statement is missing x5c")
+	}
+	credCert := attStmt.Certificate
+
+	// Step 2. Concatenate authenticatorData and clientDataHash to form nonceToHash.
+	nonceToHash := append(append([]byte{}, authData.RawAuthData...), clientDataHash...)
+
+	// Step 3. Perform SHA-256 hash of nonceToHash to produce nonce.
+	nonce := sha256.Sum256(nonceToHash)
+
+	// Step 4. Verify that nonce equals the value of the extension with
+	// OID 1.2.840.113635.100.8.2 in credCert.
+	certNonce, err := parseAppleNonce(credCert)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(certNonce, nonce[:]) {
+		fmt.Printf("Certificate nonce is %x, expected %x\n", certNonce, nonce)
+		return false, errors.New("Apple attestation nonce does not match the auth data and client data hash")
+	}
+
+	// Step 5. Verify that the credential public key equals the Subject Public
+	// Key of credCert.
+	if !CredentialPublicKeyMatches(authData, credCert.PublicKey) {
+		return false, errors.New("Apple attestation certificate does not match the credential public key")
+	}
+
+	// The certificate has to come from Apple, so the chain is validated
+	// against the configured Apple WebAuthn root CA
+	if appleRoots == nil {
+		return false, errors.New("No Apple root CA is configured")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range attStmt.CertificateChain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = credCert.Verify(x509.VerifyOptions{
+		Roots:         appleRoots,
+		Intermediates: intermediates,
+		CurrentTime:   timeNow(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		fmt.Println("Apple certificate chain error:", err)
+		return false, errors.New("Apple attestation certificate does not chain to the Apple root CA")
+	}
+
+	// Step 6. If successful, return implementation-specific values representing
+	// attestation type Anonymization CA and attestation trust path x5c.
+	return true, nil
+}
+
+// parseAppleNonce returns the nonce from the Apple extension of credCert
+func parseAppleNonce(credCert *x509.Certificate) ([]byte, error) {
+	for _, ext := range credCert.Extensions {
+		if !ext.Id.Equal(idAppleNonce) {
+			continue
+		}
+		var nonceExt appleNonceExtension
+		_, err := asn1.Unmarshal(ext.Value, &nonceExt)
+		if err != nil {
+			fmt.Println("Error unmarshalling Apple nonce extension:", err)
+			return nil, errors.New("Error unmarshalling Apple nonce extension")
+		}
+		return nonceExt.Nonce, nil
+	}
+	return nil, errors.New("Apple attestation certificate is missing the nonce extension")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+)
+
+// appleFixtureTime is when the recorded apple attestation certificate was valid
+var appleFixtureTime = time.Date(2020, time.October, 7, 12, 0, 0, 0, time.UTC)
+
+// withAppleRoots runs f with the bundled Apple root CA and the clock set to
+// when the recorded fixture was made
+func (as *AttestationSuite) withAppleRoots(f func()) {
+	roots, err := LoadCertPool("certs/apple_webauthn_root_ca.pem")
+	if err != nil {
+		as.T().Fatalf("Unexpected error loading the Apple root CA: %s", err)
+	}
+	appleRoots = roots
+	timeNow = func() time.Time { return appleFixtureTime }
+	defer func() {
+		appleRoots = nil
+		timeNow = time.Now
+	}()
+	f()
+}
+
+func (as *AttestationSuite) TestAppleAttestation() {
+	authData, clientDataHash := as.loadAttestationFixture("apple")
+	if authData.Format != "apple" {
+		as.T().Fatalf("Unexpected fixture format: %s", authData.Format)
+	}
+	as.withAppleRoots(func() {
+		valid, err := VerifyAppleAttestation(&authData, clientDataHash)
+		if err != nil || !valid {
+			as.T().Fatalf("Expected valid apple attestation. Got: %v, %s", valid, err)
+		}
+	})
+}
+
+func (as *AttestationSuite) TestAppleAttestationWrongNonce() {
+	authData, _ := as.loadAttestationFixture("apple")
+	as.withAppleRoots(func() {
+		_, err := VerifyAppleAttestation(&authData, testClientDataHash[:])
+		if err == nil {
+			as.T().Fatalf("Expected an error for a mismatched nonce")
+		}
+	})
+}
+
+func (as *AttestationSuite) TestAppleAttestationExpiredCertificate() {
+	authData, clientDataHash := as.loadAttestationFixture("apple")
+	as.withAppleRoots(func() {
+		timeNow = time.Now
+		_, err := VerifyAppleAttestation(&authData, clientDataHash)
+		if err == nil {
+			as.T().Fatalf("Expected an error for an expired attestation certificate")
+		}
+	})
+}
+
+func (as *AttestationSuite) TestAppleAttestationWithoutRoots() {
+	authData, clientDataHash := as.loadAttestationFixture("apple")
+	_, err := VerifyAppleAttestation(&authData, clientDataHash)
+	if err == nil {
+		as.T().Fatalf("Expected an error when no Apple root CA is configured")
+	}
+}
+
+func (as *AttestationSuite) TestAppleNonceMissing() {
+	caCert, _ := makeTestCA("Not Apple")
+	_, err := parseAppleNonce(caCert)
+	if err == nil {
+		as.T().Fatalf("Expected an error for a certificate without the nonce extension")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+)
+
+// idFidoGenCeAAGUID is the id-fido-gen-ce-aaguid certificate extension which
+// holds the AAGUID of the authenticator model the certificate was issued to.
+var idFidoGenCeAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// VerifyPackedAttestation - Verify an attestation statement in the "packed" format.
+// The packed attestation statement looks like:
+//
+//	{
+//		alg: COSEAlgorithmIdentifier,
+//		sig: bytes,
+//		x5c: [ attestnCert: bytes, * (caCert: bytes) ]
+//	}
+//
+// When x5c is missing the authenticator is using self attestation and the
+// statement is signed with the credential private key itself.
+func VerifyPackedAttestation(authData *req.DecodedAuthData, clientDataHash []byte) (bool, error) {
+	attStmt := authData.AttStatement
+
+	// Step 1. Verify that attStmt is valid CBOR conforming to the syntax
+	// defined above and perform CBOR decoding on it to extract the
+	// contained fields.
+
+	// Done when parsing the attestation statement, we just make sure the
+	// required fields are there
+	if attStmt.Algorithm == 0 {
+		err := errors.New("Packed attestation statement is missing alg")
+		return false, err
+	}
+	if len(attStmt.Signature) == 0 {
+		err := errors.New("Packed attestation statement is missing sig")
+		return false, err
+	}
+
+	signedData := append(append([]byte{}, authData.RawAuthData...), clientDataHash...)
+
+	// Step 2. If x5c is present, this indicates that the attestation type
+	// is not ECDAA.
+	if attStmt.Certificate != nil {
+		return verifyPackedFullAttestation(authData, signedData)
+	}
+
+	// Step 3. If ecdaaKeyId is present, then the attestation type is ECDAA.
+
+	// ECDAA was removed from the spec and is not supported
+
+	// Step 4. If neither x5c nor ecdaaKeyId is present, self attestation is in use.
+	return verifyPackedSelfAttestation(authData, signedData)
+}
+
+// verifyPackedFullAttestation - Basic or AttCA attestation, where x5c carries the
+// attestation certificate and the chain leading up to it.
+func verifyPackedFullAttestation(authData *req.DecodedAuthData, signedData []byte) (bool, error) {
+	attStmt := authData.AttStatement
+	attCert := attStmt.Certificate
+
+	// Step 2.1. Verify that sig is a valid signature over the concatenation of
+	// authenticatorData and clientDataHash using the attestation public key in
+	// attestnCert with the algorithm specified in alg.
+	sigAlg := models.COSESignatureAlgorithm(attStmt.Algorithm)
+	if sigAlg == x509.UnknownSignatureAlgorithm {
+		err := fmt.Errorf("Unsupported packed attestation algorithm %d", attStmt.Algorithm)
+		return false, err
+	}
+	err := attCert.CheckSignature(sigAlg, signedData, attStmt.Signature)
+	if err != nil {
+		fmt.Println("Packed attestation signature error:", err)
+		err := errors.New("Packed attestation signature is invalid")
+		return false, err
+	}
+
+	// Step 2.2. Verify that attestnCert meets the requirements in
+	// §8.2.1 Packed Attestation Statement Certificate Requirements.
+	err = checkPackedCertificateRequirements(attCert)
+	if err != nil {
+		return false, err
+	}
+
+	// Step 2.3. If attestnCert contains an extension with OID
+	// 1.3.6.1.4.1.45724.1.1.4 (id-fido-gen-ce-aaguid) verify that the value
+	// of this extension matches the aaguid in authenticatorData.
+	err = checkCertificateAAGUID(attCert, authData.AAGUID)
+	if err != nil {
+		return false, err
+	}
+
+	// Step 2.4. Optionally, inspect x5c and consult externally provided
+	// knowledge to determine whether attStmt conveys a Basic or AttCA attestation.
+
+	// Step 2.5. If successful, return implementation-specific values
+	// representing attestation type Basic, AttCA or uncertainty, and
+	// attestation trust path x5c.
+	return true, nil
+}
+
+// verifyPackedSelfAttestation - Self attestation, signed by the credential key itself
+func verifyPackedSelfAttestation(authData *req.DecodedAuthData, signedData []byte) (bool, error) {
+	attStmt := authData.AttStatement
+
+	// Step 4.1. Validate that alg matches the algorithm of the credentialPublicKey
+	// in authenticatorData.
+	if authData.PubKey.Type != attStmt.Algorithm {
+		fmt.Println("Credential alg is", authData.PubKey.Type, "attestation alg is", attStmt.Algorithm)
+		err := errors.New("Packed self attestation alg does not match the credential public key")
+		return false, err
+	}
+
+	// Step 4.2. Verify that sig is a valid signature over the concatenation of
+	// authenticatorData and clientDataHash using the credential public key with alg.
+	valid, err := authData.PubKey.Verify(attStmt.Signature, signedData)
+	if err != nil {
+		fmt.Println("Packed self attestation signature error:", err)
+		return false, err
+	}
+
+	// Step 4.3. If successful, return implementation-specific values
+	// representing attestation type Self and an empty attestation trust path.
+	return valid, nil
+}
+
+// checkPackedCertificateRequirements - §8.2.1 Packed Attestation Statement Certificate Requirements
+func checkPackedCertificateRequirements(attCert *x509.Certificate) error {
+	// Version MUST be set to 3 (which is indicated by an ASN.1 INTEGER with value 2).
+	if attCert.Version != 3 {
+		return errors.New("Packed attestation certificate is not version 3")
+	}
+
+	// Subject-C: ISO 3166 code specifying the country where the Authenticator vendor is incorporated
+	if len(attCert.Subject.Country) == 0 || attCert.Subject.Country[0] == "" {
+		return errors.New("Packed attestation certificate is missing Subject-C")
+	}
+
+	// Subject-O: Legal name of the Authenticator vendor
+	if len(attCert.Subject.Organization) == 0 || attCert.Subject.Organization[0] == "" {
+		return errors.New("Packed attestation certificate is missing Subject-O")
+	}
+
+	// Subject-OU: Literal string "Authenticator Attestation"
+	if strings.Join(attCert.Subject.OrganizationalUnit, " ") != "Authenticator Attestation" {
+		return errors.New("Packed attestation certificate Subject-OU is not \"Authenticator Attestation\"")
+	}
+
+	// Subject-CN: A UTF8String of the vendor's choosing
+	if attCert.Subject.CommonName == "" {
+		return errors.New("Packed attestation certificate is missing Subject-CN")
+	}
+
+	// The Basic Constraints extension MUST have the CA component set to false.
+	if attCert.IsCA {
+		return errors.New("Packed attestation certificate is marked as a CA")
+	}
+
+	return nil
+}
+
+// checkCertificateAAGUID - If the certificate carries the id-fido-gen-ce-aaguid
+// extension, make sure it is not critical and that it matches the AAGUID the
+// authenticator put in authData.
+func checkCertificateAAGUID(cert *x509.Certificate, aaguid []byte) error {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idFidoGenCeAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return errors.New("Attestation certificate AAGUID extension is marked critical")
+		}
+		// The extension value is the DER encoding of an OCTET STRING
+		var certAAGUID []byte
+		_, err := asn1.Unmarshal(ext.Value, &certAAGUID)
+		if err != nil {
+			return errors.New("Error unmarshalling attestation certificate AAGUID")
+		}
+		if !bytes.Equal(certAAGUID, aaguid) {
+			fmt.Printf("Certificate AAGUID is %x, Auth Data AAGUID is %x\n", certAAGUID, aaguid)
+			return errors.New("Attestation certificate AAGUID does not match Auth Data")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+)
+
+// makePackedAttestationCert issues a certificate meeting the packed
+// attestation certificate requirements for the given AAGUID
+func makePackedAttestationCert(pub crypto.PublicKey, aaguid []byte) (*x509.Certificate, *x509.Certificate) {
+	ca, caKey := makeTestCA("Test Attestation Root")
+	aaguidExt, _ := asn1.Marshal(aaguid)
+	cert := makeCertificate(&x509.Certificate{
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Test Vendor"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Test Authenticator",
+		},
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: idFidoGenCeAAGUID, Value: aaguidExt},
+		},
+	}, pub, ca, caKey)
+	return cert, ca
+}
+
+func signES256(key *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	return sig
+}
+
+func (as *AttestationSuite) TestPackedSelfAttestation() {
+	credKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authData := makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&credKey.PublicKey, models.COSEAlgES256))
+	sig := signES256(credKey, append(append([]byte{}, authData...), testClientDataHash[:]...))
+
+	decoded := as.parseTestAuthData("packed", authData, req.EncodedAttestationStatement{
+		Algorithm: models.COSEAlgES256,
+		Signature: sig,
+	})
+	valid, err := VerifyPackedAttestation(&decoded, testClientDataHash[:])
+	if err != nil || !valid {
+		as.T().Fatalf("Expected valid self attestation. Got: %v, %s", valid, err)
+	}
+
+	// A signature over a different client data hash must not verify
+	otherHash := sha256.Sum256([]byte("other"))
+	valid, _ = VerifyPackedAttestation(&decoded, otherHash[:])
+	if valid {
+		as.T().Fatalf("Self attestation verified over the wrong client data hash")
+	}
+
+	// The alg must match the credential public key
+	decoded.AttStatement.Algorithm = models.COSEAlgRS256
+	_, err = VerifyPackedAttestation(&decoded, testClientDataHash[:])
+	if err == nil {
+		as.T().Fatalf("Expected an alg mismatch error for self attestation")
+	}
+}
+
+func (as *AttestationSuite) TestPackedFullAttestation() {
+	credKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attCert, ca := makePackedAttestationCert(attKey.Public(), testAAGUID)
+
+	authData := makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&credKey.PublicKey, models.COSEAlgES256))
+	sig := signES256(attKey, append(append([]byte{}, authData...), testClientDataHash[:]...))
+
+	decoded := as.parseTestAuthData("packed", authData, req.EncodedAttestationStatement{
+		Algorithm: models.COSEAlgES256,
+		Signature: sig,
+		X509Cert:  [][]byte{attCert.Raw, ca.Raw},
+	})
+	if len(decoded.AttStatement.CertificateChain) != 2 {
+		as.T().Fatalf("Unexpected x5c chain length. Expected: %d, Got: %d", 2, len(decoded.AttStatement.CertificateChain))
+	}
+	valid, err := VerifyPackedAttestation(&decoded, testClientDataHash[:])
+	if err != nil || !valid {
+		as.T().Fatalf("Expected valid full attestation. Got: %v, %s", valid, err)
+	}
+
+	// Signing with the credential key instead of the attestation key fails
+	decoded.AttStatement.Signature = signES256(credKey, append(append([]byte{}, authData...), testClientDataHash[:]...))
+	_, err = VerifyPackedAttestation(&decoded, testClientDataHash[:])
+	if err == nil {
+		as.T().Fatalf("Expected a signature error for full attestation")
+	}
+}
+
+func (as *AttestationSuite) TestPackedAttestationAAGUIDMismatch() {
+	credKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherAAGUID := make([]byte, 16)
+	attCert, _ := makePackedAttestationCert(attKey.Public(), otherAAGUID)
+
+	authData := makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&credKey.PublicKey, models.COSEAlgES256))
+	sig := signES256(attKey, append(append([]byte{}, authData...), testClientDataHash[:]...))
+
+	decoded := as.parseTestAuthData("packed", authData, req.EncodedAttestationStatement{
+		Algorithm: models.COSEAlgES256,
+		Signature: sig,
+		X509Cert:  [][]byte{attCert.Raw},
+	})
+	_, err := VerifyPackedAttestation(&decoded, testClientDataHash[:])
+	if err == nil {
+		as.T().Fatalf("Expected an AAGUID mismatch error")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	req "git.jba.io/go/webauthn/request"
+)
+
+// safetyNetHostname is the hostname the SafetyNet signing certificate is issued to
+const safetyNetHostname = "attest.android.com"
+
+// safetyNetMaxSkew is how far the SafetyNet timestamp may be from our clock
+const safetyNetMaxSkew = 60 * time.Second
+
+// safetyNetRoots are the roots a SafetyNet response must chain up to. The
+// response is verified offline, so these come from a local PEM bundle
+// configured with safetynet_roots.
+var safetyNetRoots *x509.CertPool
+
+// timeNow is swapped out by tests that use recorded responses
+var timeNow = time.Now
+
+// ParseSafetyNetJWS splits the compact JWS found in the response field of an
+// "android-safetynet" attestation statement and decodes its parts
+func ParseSafetyNetJWS(response []byte) (*req.SafetyNetResponse, error) {
+	parts := bytes.Split(response, []byte("."))
+	if len(parts) != 3 {
+		return nil, errors.New("SafetyNet response is not a compact JWS")
+	}
+
+	sn := &req.SafetyNetResponse{
+		SignedData: response[:len(parts[0])+1+len(parts[1])],
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(string(parts[0]))
+	if err != nil {
+		return nil, errors.New("Error decoding SafetyNet JWS header")
+	}
+	err = json.Unmarshal(header, &sn.Header)
+	if err != nil {
+		fmt.Println("Error unmarshalling SafetyNet JWS header:", err)
+		return nil, errors.New("Error unmarshalling SafetyNet JWS header")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		return nil, errors.New("Error decoding SafetyNet JWS payload")
+	}
+	err = json.Unmarshal(payload, &sn.Payload)
+	if err != nil {
+		fmt.Println("Error unmarshalling SafetyNet JWS payload:", err)
+		return nil, errors.New("Error unmarshalling SafetyNet JWS payload")
+	}
+
+	sn.Signature, err = base64.RawURLEncoding.DecodeString(string(parts[2]))
+	if err != nil {
+		return nil, errors.New("Error decoding SafetyNet JWS signature")
+	}
+
+	// x5c entries are standard base64 DER, not base64url
+	for _, encodedCert := range sn.Header.X5C {
+		rawCert, err := base64.StdEncoding.DecodeString(encodedCert)
+		if err != nil {
+			return nil, errors.New("Error decoding SafetyNet certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return nil, err
+		}
+		sn.Certificates = append(sn.Certificates, cert)
+	}
+
+	return sn, nil
+}
+
+// VerifySafetyNetAttestation - Verify an attestation statement in the "android-safetynet" format.
+// The SafetyNet attestation statement looks like:
+//
+//	{
+//		ver: text,
+//		response: bytes
+//	}
+//
+// where response is the UTF-8 encoded JWS returned by the SafetyNet API.
+func VerifySafetyNetAttestation(authData *req.DecodedAuthData, clientDataHash []byte) (bool, error) {
+	attStmt := authData.AttStatement
+
+	// Step 1. Verify that attStmt is valid CBOR conforming to the syntax
+	// defined above and perform CBOR decoding on it to extract the
+	// contained fields.
+	if attStmt.Version == "" {
+		return false, errors.New("SafetyNet attestation statement is missing ver")
+	}
+	sn := attStmt.SafetyNet
+	if sn == nil {
+		return false, errors.New("SafetyNet attestation statement is missing response")
+	}
+
+	// Step 2. Verify that response is a valid SafetyNet response of version ver
+	// by following the steps indicated by the SafetyNet online documentation.
+	err := verifySafetyNetResponse(sn)
+	if err != nil {
+		return false, err
+	}
+
+	// Step 3. Verify that the nonce attribute in the payload of response is
+	// identical to the Base64 encoding of the SHA-256 hash of the
+	// concatenation of authenticatorData and clientDataHash.
+	nonceData := sha256.Sum256(append(append([]byte{}, authData.RawAuthData...), clientDataHash...))
+	nonce := base64.StdEncoding.EncodeToString(nonceData[:])
+	if sn.Payload.Nonce != nonce {
+		fmt.Println("SafetyNet nonce is", sn.Payload.Nonce, "expected", nonce)
+		return false, errors.New("SafetyNet nonce does not match the auth data and client data hash")
+	}
+
+	// Step 4. Verify that the SafetyNet response actually came from the
+	// SafetyNet service by following the steps in the SafetyNet online
+	// documentation.
+
+	// Done above in verifySafetyNetResponse, the leaf has to be issued to
+	// attest.android.com and chain up to a configured root.
+
+	// Step 5. If successful, return implementation-specific values representing
+	// attestation type Basic and attestation trust path x5c.
+	return true, nil
+}
+
+// verifySafetyNetResponse checks the JWS signature, the signing certificate
+// and the device integrity verdict of a SafetyNet response
+func verifySafetyNetResponse(sn *req.SafetyNetResponse) error {
+	if len(sn.Certificates) == 0 {
+		return errors.New("SafetyNet response is missing x5c")
+	}
+	leaf := sn.Certificates[0]
+
+	// Google only signs SafetyNet responses with RS256
+	if sn.Header.Algorithm != "RS256" {
+		return fmt.Errorf("Unsupported SafetyNet JWS algorithm %s", sn.Header.Algorithm)
+	}
+	err := leaf.CheckSignature(x509.SHA256WithRSA, sn.SignedData, sn.Signature)
+	if err != nil {
+		fmt.Println("SafetyNet signature error:", err)
+		return errors.New("SafetyNet response signature is invalid")
+	}
+
+	// The signing certificate is issued to attest.android.com
+	err = leaf.VerifyHostname(safetyNetHostname)
+	if err != nil {
+		fmt.Println("SafetyNet hostname error:", err)
+		return errors.New("SafetyNet certificate is not issued to " + safetyNetHostname)
+	}
+
+	// and chains up to one of our local roots
+	if safetyNetRoots == nil {
+		return errors.New("No SafetyNet roots are configured")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range sn.Certificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         safetyNetRoots,
+		Intermediates: intermediates,
+		CurrentTime:   timeNow(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		fmt.Println("SafetyNet certificate chain error:", err)
+		return errors.New("SafetyNet certificate does not chain to a trusted root")
+	}
+
+	// The device has to pass the CTS profile check, which implies basic integrity
+	if !sn.Payload.CtsProfileMatch {
+		return errors.New("SafetyNet ctsProfileMatch is false")
+	}
+
+	// The response has to be fresh
+	timestamp := time.Unix(0, sn.Payload.TimestampMs*int64(time.Millisecond))
+	skew := timeNow().Sub(timestamp)
+	if skew > safetyNetMaxSkew || skew < -safetyNetMaxSkew {
+		fmt.Println("SafetyNet timestamp is", timestamp, "skew is", skew)
+		return errors.New("SafetyNet response timestamp is out of range")
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+)
+
+// safetyNetTest is a signed SafetyNet response for freshly generated auth data
+type safetyNetTest struct {
+	root           *x509.Certificate
+	authData       []byte
+	clientDataHash []byte
+	payload        req.SafetyNetPayload
+	hostname       string
+}
+
+// newSafetyNetTest creates auth data and a payload that passes verification
+func newSafetyNetTest() *safetyNetTest {
+	credKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authData := makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&credKey.PublicKey, models.COSEAlgES256))
+	nonce := sha256.Sum256(append(append([]byte{}, authData...), testClientDataHash[:]...))
+	return &safetyNetTest{
+		authData:       authData,
+		clientDataHash: testClientDataHash[:],
+		hostname:       safetyNetHostname,
+		payload: req.SafetyNetPayload{
+			Nonce:           base64.StdEncoding.EncodeToString(nonce[:]),
+			TimestampMs:     time.Now().UnixNano() / int64(time.Millisecond),
+			ApkPackageName:  "com.google.android.gms",
+			CtsProfileMatch: true,
+			BasicIntegrity:  true,
+		},
+	}
+}
+
+// sign builds the JWS, issuing the signing certificate from a new root
+func (snt *safetyNetTest) sign() []byte {
+	rootCert, rootKey := makeTestCA("SafetyNet Test Root")
+	snt.root = rootCert
+	signingKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	leaf := makeCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: snt.hostname},
+		DNSNames:    []string{snt.hostname},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, signingKey.Public(), rootCert, rootKey)
+
+	header, _ := json.Marshal(req.SafetyNetHeader{
+		Algorithm: "RS256",
+		X5C:       []string{base64.StdEncoding.EncodeToString(leaf.Raw)},
+	})
+	payload, _ := json.Marshal(snt.payload)
+	signedData := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signedData))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, signingKey, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return []byte(signedData + "." + base64.RawURLEncoding.EncodeToString(sig))
+}
+
+// verify signs the response, trusts its root and verifies the attestation
+func (as *AttestationSuite) verifySafetyNet(snt *safetyNetTest, trustRoot bool) (bool, error) {
+	response := snt.sign()
+	safetyNetRoots = x509.NewCertPool()
+	if trustRoot {
+		safetyNetRoots.AddCert(snt.root)
+	}
+	defer func() { safetyNetRoots = nil }()
+
+	authData := as.parseTestAuthData("android-safetynet", snt.authData, req.EncodedAttestationStatement{
+		Version:  "14366018",
+		Response: response,
+	})
+	return VerifySafetyNetAttestation(&authData, snt.clientDataHash)
+}
+
+func (as *AttestationSuite) TestSafetyNetAttestation() {
+	valid, err := as.verifySafetyNet(newSafetyNetTest(), true)
+	if err != nil || !valid {
+		as.T().Fatalf("Expected valid android-safetynet attestation. Got: %v, %s", valid, err)
+	}
+}
+
+func (as *AttestationSuite) TestSafetyNetJWSParsed() {
+	snt := newSafetyNetTest()
+	sn, err := ParseSafetyNetJWS(snt.sign())
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing SafetyNet JWS: %s", err)
+	}
+	if sn.Payload.Nonce != snt.payload.Nonce || len(sn.Certificates) != 1 {
+		as.T().Fatalf("Unexpected SafetyNet response %+v", sn)
+	}
+
+	_, err = ParseSafetyNetJWS([]byte("not-a-jws"))
+	if err == nil {
+		as.T().Fatalf("Expected an error for a malformed JWS")
+	}
+}
+
+func (as *AttestationSuite) TestSafetyNetAttestationRejected() {
+	tests := []struct {
+		name      string
+		modify    func(*safetyNetTest)
+		trustRoot bool
+		errText   string
+	}{
+		{"wrong nonce", func(snt *safetyNetTest) { snt.payload.Nonce = "AAAA" }, true, "nonce"},
+		{"cts profile mismatch", func(snt *safetyNetTest) { snt.payload.CtsProfileMatch = false }, true, "ctsProfileMatch"},
+		{"stale timestamp", func(snt *safetyNetTest) {
+			snt.payload.TimestampMs -= int64(10 * time.Minute / time.Millisecond)
+		}, true, "timestamp"},
+		{"future timestamp", func(snt *safetyNetTest) {
+			snt.payload.TimestampMs += int64(10 * time.Minute / time.Millisecond)
+		}, true, "timestamp"},
+		{"wrong hostname", func(snt *safetyNetTest) { snt.hostname = "attest.example.com" }, true, "issued to"},
+		{"untrusted root", func(snt *safetyNetTest) {}, false, "trusted root"},
+	}
+	for _, test := range tests {
+		snt := newSafetyNetTest()
+		test.modify(snt)
+		valid, err := as.verifySafetyNet(snt, test.trustRoot)
+		if err == nil || valid {
+			as.T().Fatalf("Expected an error for %s", test.name)
+		}
+		if !strings.Contains(err.Error(), test.errText) {
+			as.T().Fatalf("Unexpected error for %s: %s", test.name, err)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestSafetyNetAttestationWithoutRoots() {
+	snt := newSafetyNetTest()
+	authData := as.parseTestAuthData("android-safetynet", snt.authData, req.EncodedAttestationStatement{
+		Version:  "14366018",
+		Response: snt.sign(),
+	})
+	_, err := VerifySafetyNetAttestation(&authData, snt.clientDataHash)
+	if err == nil {
+		as.T().Fatalf("Expected an error when no SafetyNet roots are configured")
+	}
+}
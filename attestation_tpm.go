@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1" // registers SHA-1 for TPM nameAlg and RS1
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+)
+
+// TPM 2.0 constants, from the TPM 2.0 Library Part 2: Structures
+const (
+	tpmGeneratedValue  uint32 = 0xff544347
+	tpmSTAttestCertify uint16 = 0x8017
+
+	tpmAlgRSA    uint16 = 0x0001
+	tpmAlgSHA1   uint16 = 0x0004
+	tpmAlgSHA256 uint16 = 0x000B
+	tpmAlgSHA384 uint16 = 0x000C
+	tpmAlgSHA512 uint16 = 0x000D
+	tpmAlgNull   uint16 = 0x0010
+	tpmAlgECC    uint16 = 0x0023
+
+	tpmECCNistP256 uint16 = 0x0003
+	tpmECCNistP384 uint16 = 0x0004
+	tpmECCNistP521 uint16 = 0x0005
+)
+
+// TCG OIDs used in AIK certificates
+var (
+	oidTCGKpAIKCertificate  = asn1.ObjectIdentifier{2, 23, 133, 8, 3}
+	oidTCGAtTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	oidTCGAtTPMModel        = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	oidTCGAtTPMVersion      = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+	oidSubjectAltName       = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+// tpmManufacturers are the vendor IDs in the TCG TPM Vendor ID Registry
+var tpmManufacturers = map[string]string{
+	"414D4400": "AMD",
+	"41544D4C": "Atmel",
+	"4252434D": "Broadcom",
+	"4353434F": "Cisco",
+	"464C5953": "Flyslice Technologies",
+	"474F4F47": "Google",
+	"48504500": "HPE",
+	"48504900": "HPI",
+	"48495349": "Huawei",
+	"49424D00": "IBM",
+	"49465800": "Infineon",
+	"494E5443": "Intel",
+	"4C454E00": "Lenovo",
+	"4D534654": "Microsoft",
+	"4E534D20": "National Semiconductor",
+	"4E545A00": "Nationz",
+	"4E544300": "Nuvoton Technology",
+	"51434F4D": "Qualcomm",
+	"524F4343": "Fuzhou Rockchip",
+	"534D5343": "SMSC",
+	"534D534E": "Samsung",
+	"534E5300": "Sinosun",
+	"53544D20": "ST Microelectronics",
+	"54584E00": "Texas Instruments",
+	"57454300": "Winbond",
+	"5345414C": "Wisekey",
+	// Reserved for the FIDO Alliance conformance tools
+	"FFFFF1D0": "FIDO Alliance Conformance Testing",
+}
+
+// TPMCertifyInfo is the TPMS_ATTEST structure found in a "tpm" statement's certInfo,
+// with its attested field holding a TPMS_CERTIFY_INFO.
+type TPMCertifyInfo struct {
+	Magic           uint32
+	Type            uint16
+	QualifiedSigner []byte
+	ExtraData       []byte
+	Clock           uint64
+	ResetCount      uint32
+	RestartCount    uint32
+	Safe            bool
+	FirmwareVersion uint64
+	Name            []byte
+	QualifiedName   []byte
+}
+
+// TPMPublic is the TPMT_PUBLIC structure found in a "tpm" statement's pubArea.
+// Only the fields of the key type given by Type are set.
+type TPMPublic struct {
+	Type             uint16
+	NameAlg          uint16
+	ObjectAttributes uint32
+	AuthPolicy       []byte
+
+	// TPMS_RSA_PARMS and TPM2B_PUBLIC_KEY_RSA
+	RSAKeyBits  uint16
+	RSAExponent uint32
+	RSAModulus  []byte
+
+	// TPMS_ECC_PARMS and TPMS_ECC_POINT
+	ECCCurveID uint16
+	ECCX       []byte
+	ECCY       []byte
+}
+
+// tpmReader reads the big endian TPM wire format
+type tpmReader struct {
+	buf []byte
+	err error
+}
+
+func (r *tpmReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errors.New("TPM structure is truncated")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *tpmReader) uint8() uint8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *tpmReader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *tpmReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *tpmReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// sized reads a TPM2B structure, a uint16 size followed by that many bytes
+func (r *tpmReader) sized() []byte {
+	return r.next(int(r.uint16()))
+}
+
+// done reports an error if anything is left over after parsing
+func (r *tpmReader) done() error {
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = errors.New("TPM structure has trailing bytes")
+	}
+	return r.err
+}
+
+// ParseTPMCertifyInfo parses a TPMS_ATTEST structure
+func ParseTPMCertifyInfo(certInfo []byte) (TPMCertifyInfo, error) {
+	r := &tpmReader{buf: certInfo}
+	ci := TPMCertifyInfo{
+		Magic:           r.uint32(),
+		Type:            r.uint16(),
+		QualifiedSigner: r.sized(),
+		ExtraData:       r.sized(),
+		Clock:           r.uint64(),
+		ResetCount:      r.uint32(),
+		RestartCount:    r.uint32(),
+		Safe:            r.uint8() == 1,
+		FirmwareVersion: r.uint64(),
+	}
+	if ci.Type != tpmSTAttestCertify {
+		return ci, errors.New("TPM certInfo type is not TPM_ST_ATTEST_CERTIFY")
+	}
+	ci.Name = r.sized()
+	ci.QualifiedName = r.sized()
+	return ci, r.done()
+}
+
+// ParseTPMPublic parses a TPMT_PUBLIC structure for an RSA or ECC key
+func ParseTPMPublic(pubArea []byte) (TPMPublic, error) {
+	r := &tpmReader{buf: pubArea}
+	pub := TPMPublic{
+		Type:             r.uint16(),
+		NameAlg:          r.uint16(),
+		ObjectAttributes: r.uint32(),
+		AuthPolicy:       r.sized(),
+	}
+
+	// TPMT_SYM_DEF_OBJECT, keyBits and mode are only there for a real algorithm
+	if r.uint16() != tpmAlgNull {
+		r.uint16()
+		r.uint16()
+	}
+	// TPMT_RSA_SCHEME or TPMT_ECC_SCHEME, hashAlg is only there for a real scheme
+	if r.uint16() != tpmAlgNull {
+		r.uint16()
+	}
+
+	switch pub.Type {
+	case tpmAlgRSA:
+		pub.RSAKeyBits = r.uint16()
+		pub.RSAExponent = r.uint32()
+		pub.RSAModulus = r.sized()
+		// An exponent of zero means the default exponent
+		if pub.RSAExponent == 0 {
+			pub.RSAExponent = 65537
+		}
+	case tpmAlgECC:
+		pub.ECCCurveID = r.uint16()
+		// TPMT_KDF_SCHEME
+		if r.uint16() != tpmAlgNull {
+			r.uint16()
+		}
+		pub.ECCX = r.sized()
+		pub.ECCY = r.sized()
+	default:
+		return pub, fmt.Errorf("Unsupported TPM public key type 0x%04x", pub.Type)
+	}
+	return pub, r.done()
+}
+
+// ParseTPMCertificate parses a certificate of a TPM x5c chain. Windows Hello
+// AIK certificates are issued by Microsoft CAs with negative serial numbers,
+// which crypto/x509 rejects. Such a certificate is parsed with a positive
+// serial in its place, then its serial and original bytes are put back so
+// signatures are checked over what was actually signed.
+func ParseTPMCertificate(raw []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(raw)
+	if err == nil {
+		return cert, nil
+	}
+	serial, tbs, offset, ok := negativeSerial(raw)
+	if !ok {
+		return nil, err
+	}
+	patched := append([]byte{}, raw...)
+	patched[offset] = 0x01
+	cert, perr := x509.ParseCertificate(patched)
+	if perr != nil {
+		return nil, err
+	}
+	cert.Raw = raw
+	cert.RawTBSCertificate = tbs
+	cert.SerialNumber = serial
+	return cert, nil
+}
+
+// negativeSerial finds the serial number of a DER certificate. When the serial
+// is negative it returns the serial, the certificate's TBSCertificate and the
+// offset of the serial's first byte in der.
+func negativeSerial(der []byte) (*big.Int, []byte, int, bool) {
+	var certificate, tbs, field asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &certificate)
+	if err != nil || len(rest) != 0 {
+		return nil, nil, 0, false
+	}
+	_, err = asn1.Unmarshal(certificate.Bytes, &tbs)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	offset := len(der) - len(certificate.Bytes) + len(tbs.FullBytes) - len(tbs.Bytes)
+	fields := tbs.Bytes
+	fields, err = asn1.Unmarshal(fields, &field)
+	// The version is optional and comes before the serial
+	if err == nil && field.Class == asn1.ClassContextSpecific && field.Tag == 0 {
+		offset += len(field.FullBytes)
+		_, err = asn1.Unmarshal(fields, &field)
+	}
+	if err != nil || field.Class != asn1.ClassUniversal || field.Tag != asn1.TagInteger ||
+		len(field.Bytes) == 0 || field.Bytes[0]&0x80 == 0 {
+		return nil, nil, 0, false
+	}
+	offset += len(field.FullBytes) - len(field.Bytes)
+	serial := new(big.Int)
+	_, err = asn1.Unmarshal(field.FullBytes, &serial)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	return serial, tbs.FullBytes, offset, true
+}
+
+// tpmNameAlgHash maps a TPM_ALG_ID hash algorithm onto a crypto.Hash
+func tpmNameAlgHash(alg uint16) crypto.Hash {
+	switch alg {
+	case tpmAlgSHA1:
+		return crypto.SHA1
+	case tpmAlgSHA256:
+		return crypto.SHA256
+	case tpmAlgSHA384:
+		return crypto.SHA384
+	case tpmAlgSHA512:
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// VerifyTPMAttestation - Verify an attestation statement in the "tpm" format.
+// The TPM attestation statement looks like:
+//
+//	{
+//		ver: "2.0",
+//		alg: COSEAlgorithmIdentifier,
+//		x5c: [ aikCert: bytes, * (caCert: bytes) ],
+//		sig: bytes,
+//		certInfo: bytes,
+//		pubArea: bytes
+//	}
+func VerifyTPMAttestation(authData *req.DecodedAuthData, clientDataHash []byte) (bool, error) {
+	attStmt := authData.AttStatement
+
+	// Step 1. Verify that attStmt is valid CBOR conforming to the syntax
+	// defined above and perform CBOR decoding on it to extract the
+	// contained fields.
+	if attStmt.Version != "2.0" {
+		err := fmt.Errorf("Unsupported TPM attestation version %q", attStmt.Version)
+		return false, err
+	}
+	if attStmt.Certificate == nil {
+		// ECDAA was removed from the spec and is not supported
+		err := errors.New("TPM attestation statement is missing x5c")
+		return false, err
+	}
+
+	// Step 2. Verify that the public key specified by the parameters and
+	// unique fields of pubArea is identical to the credentialPublicKey in
+	// the attestedCredentialData in authenticatorData.
+	pubArea, err := ParseTPMPublic(attStmt.PubArea)
+	if err != nil {
+		fmt.Println("Error parsing TPM pubArea:", err)
+		return false, err
+	}
+	err = checkTPMPublicMatchesCredential(pubArea, authData)
+	if err != nil {
+		return false, err
+	}
+
+	// Step 3. Concatenate authenticatorData and clientDataHash to form attToBeSigned.
+	attToBeSigned := append(append([]byte{}, authData.RawAuthData...), clientDataHash...)
+
+	// Step 4. Validate that certInfo is valid
+	certInfo, err := ParseTPMCertifyInfo(attStmt.CertInfo)
+	if err != nil {
+		fmt.Println("Error parsing TPM certInfo:", err)
+		return false, err
+	}
+
+	// Verify that magic is set to TPM_GENERATED_VALUE.
+	if certInfo.Magic != tpmGeneratedValue {
+		return false, errors.New("TPM certInfo magic is not TPM_GENERATED_VALUE")
+	}
+
+	// Verify that type is set to TPM_ST_ATTEST_CERTIFY.
+
+	// Done while parsing
+
+	// Verify that extraData is set to the hash of attToBeSigned using the
+	// hash algorithm employed in "alg".
+	hash := models.COSEHash(attStmt.Algorithm)
+	if hash == 0 || !hash.Available() {
+		err := fmt.Errorf("Unsupported TPM attestation algorithm %d", attStmt.Algorithm)
+		return false, err
+	}
+	h := hash.New()
+	h.Write(attToBeSigned)
+	if !bytes.Equal(certInfo.ExtraData, h.Sum(nil)) {
+		return false, errors.New("TPM certInfo extraData is not the hash of attToBeSigned")
+	}
+
+	// Verify that attested contains a TPMS_CERTIFY_INFO structure whose name
+	// field contains a valid Name for pubArea, as computed using the algorithm
+	// in the nameAlg field of pubArea.
+	nameHash := tpmNameAlgHash(pubArea.NameAlg)
+	if nameHash == 0 || !nameHash.Available() {
+		err := fmt.Errorf("Unsupported TPM pubArea nameAlg 0x%04x", pubArea.NameAlg)
+		return false, err
+	}
+	h = nameHash.New()
+	h.Write(attStmt.PubArea)
+	name := binary.BigEndian.AppendUint16(nil, pubArea.NameAlg)
+	name = h.Sum(name)
+	if !bytes.Equal(certInfo.Name, name) {
+		return false, errors.New("TPM certInfo name does not match pubArea")
+	}
+
+	// Step 5. If x5c is present, verify the sig is a valid signature over
+	// certInfo using the attestation public key in aikCert with the
+	// algorithm specified in alg.
+	aikCert := attStmt.Certificate
+	err = aikCert.CheckSignature(models.COSESignatureAlgorithm(attStmt.Algorithm), attStmt.CertInfo, attStmt.Signature)
+	if err != nil {
+		fmt.Println("TPM attestation signature error:", err)
+		return false, errors.New("TPM attestation signature is invalid")
+	}
+
+	// Verify that aikCert meets the requirements in §8.3.1 TPM Attestation
+	// Statement Certificate Requirements.
+	err = checkTPMCertificateRequirements(aikCert)
+	if err != nil {
+		return false, err
+	}
+
+	// If aikCert contains an extension with OID 1.3.6.1.4.1.45724.1.1.4
+	// (id-fido-gen-ce-aaguid) verify that the value of this extension matches
+	// the aaguid in authenticatorData.
+	err = checkCertificateAAGUID(aikCert, authData.AAGUID)
+	if err != nil {
+		return false, err
+	}
+
+	// If successful, return implementation-specific values representing
+	// attestation type AttCA and attestation trust path x5c.
+	return true, nil
+}
+
+// checkTPMPublicMatchesCredential compares pubArea with the credential public key
+func checkTPMPublicMatchesCredential(pubArea TPMPublic, authData *req.DecodedAuthData) error {
+	pubKey := authData.PubKey
+
+	switch pubArea.Type {
+	case tpmAlgRSA:
+		if pubKey.KeyType != models.COSEKeyTypeRSA {
+			return errors.New("TPM pubArea is an RSA key but the credential public key is not")
+		}
+		exponent := new(big.Int).SetBytes(pubKey.Exponent)
+		if !bytes.Equal(pubArea.RSAModulus, pubKey.Modulus) || !exponent.IsUint64() || exponent.Uint64() != uint64(pubArea.RSAExponent) {
+			return errors.New("TPM pubArea does not match the credential public key")
+		}
+	case tpmAlgECC:
+		if pubKey.KeyType != models.COSEKeyTypeEC2 {
+			return errors.New("TPM pubArea is an ECC key but the credential public key is not")
+		}
+		curves := map[int64]uint16{
+			models.COSECurveP256: tpmECCNistP256,
+			models.COSECurveP384: tpmECCNistP384,
+			models.COSECurveP521: tpmECCNistP521,
+		}
+		if curves[pubKey.Curve] != pubArea.ECCCurveID ||
+			!bytes.Equal(pubArea.ECCX, pubKey.XCoord) ||
+			!bytes.Equal(pubArea.ECCY, pubKey.YCoord) {
+			return errors.New("TPM pubArea does not match the credential public key")
+		}
+	}
+	return nil
+}
+
+// checkTPMCertificateRequirements - §8.3.1 TPM Attestation Statement Certificate Requirements
+func checkTPMCertificateRequirements(aikCert *x509.Certificate) error {
+	// Version MUST be set to 3.
+	if aikCert.Version != 3 {
+		return errors.New("TPM AIK certificate is not version 3")
+	}
+
+	// Subject field MUST be set to empty.
+	if len(aikCert.Subject.Names) != 0 {
+		return errors.New("TPM AIK certificate subject is not empty")
+	}
+
+	// The Subject Alternative Name extension MUST be set as defined in
+	// [TPMv2-EK-Profile] section 3.2.9.
+	manufacturer, model, version, err := parseTPMSubjectAltName(aikCert)
+	if err != nil {
+		return err
+	}
+	if model == "" || version == "" {
+		return errors.New("TPM AIK certificate SAN is missing the TPM model or version")
+	}
+	if _, ok := tpmManufacturers[strings.ToUpper(manufacturer)]; !ok {
+		err := fmt.Errorf("TPM AIK certificate has unknown TPM manufacturer %q", manufacturer)
+		return err
+	}
+
+	// The Extended Key Usage extension MUST contain the OID 2.23.133.8.3
+	// ("joint-iso-itu-t(2) internationalorganizations(23) 133 tcg-kp(8)
+	// tcg-kp-AIKCertificate(3)").
+	hasAIKUsage := false
+	for _, eku := range aikCert.UnknownExtKeyUsage {
+		if eku.Equal(oidTCGKpAIKCertificate) {
+			hasAIKUsage = true
+		}
+	}
+	if !hasAIKUsage {
+		return errors.New("TPM AIK certificate is missing the tcg-kp-AIKCertificate extended key usage")
+	}
+
+	// The Basic Constraints extension MUST have the CA component set to false.
+	if aikCert.IsCA {
+		return errors.New("TPM AIK certificate is marked as a CA")
+	}
+
+	return nil
+}
+
+// parseTPMSubjectAltName reads the TPM manufacturer, model and version out of
+// the directoryName in the AIK certificate's Subject Alternative Name
+func parseTPMSubjectAltName(aikCert *x509.Certificate) (manufacturer, model, version string, err error) {
+	for _, ext := range aikCert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var generalNames []asn1.RawValue
+		_, err = asn1.Unmarshal(ext.Value, &generalNames)
+		if err != nil {
+			return "", "", "", errors.New("Error unmarshalling TPM AIK certificate SAN")
+		}
+		for _, gn := range generalNames {
+			// directoryName [4] Name
+			if gn.Class != asn1.ClassContextSpecific || gn.Tag != 4 {
+				continue
+			}
+			var rdns pkix.RDNSequence
+			_, err = asn1.Unmarshal(gn.Bytes, &rdns)
+			if err != nil {
+				return "", "", "", errors.New("Error unmarshalling TPM AIK certificate SAN directoryName")
+			}
+			for _, rdn := range rdns {
+				for _, atv := range rdn {
+					value, ok := atv.Value.(string)
+					if !ok {
+						continue
+					}
+					switch {
+					case atv.Type.Equal(oidTCGAtTPMManufacturer):
+						manufacturer = strings.TrimPrefix(value, "id:")
+					case atv.Type.Equal(oidTCGAtTPMModel):
+						model = value
+					case atv.Type.Equal(oidTCGAtTPMVersion):
+						version = strings.TrimPrefix(value, "id:")
+					}
+				}
+			}
+		}
+		return manufacturer, model, version, nil
+	}
+	return "", "", "", errors.New("TPM AIK certificate is missing the SAN extension")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	req "git.jba.io/go/webauthn/request"
+)
+
+var tpmFixtures = []string{"tpm_ecc_p256", "tpm_rsa_sha1", "tpm_rsa_sha256"}
+
+func (as *AttestationSuite) TestTPMAttestation() {
+	for _, name := range tpmFixtures {
+		authData, clientDataHash := as.loadAttestationFixture(name)
+		if authData.Format != "tpm" {
+			as.T().Fatalf("Unexpected fixture format for %s: %s", name, authData.Format)
+		}
+		valid, err := VerifyTPMAttestation(&authData, clientDataHash)
+		if err != nil || !valid {
+			as.T().Fatalf("Expected valid TPM attestation for %s. Got: %v, %s", name, valid, err)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestTPMNegativeSerialCA() {
+	// The Windows Hello intermediate in this fixture has a negative serial
+	authData, clientDataHash := as.loadAttestationFixture("tpm_rsa_sha256")
+	chain := authData.AttStatement.CertificateChain
+	if len(chain) < 2 || chain[1].SerialNumber.Sign() >= 0 {
+		as.T().Fatalf("Expected the fixture's intermediate to have a negative serial")
+	}
+	// The AIK certificate's signature is checked over the original bytes
+	err := chain[0].CheckSignatureFrom(chain[1])
+	if err != nil {
+		as.T().Fatalf("Unexpected error checking the AIK certificate's signature: %s", err)
+	}
+	valid, err := VerifyTPMAttestation(&authData, clientDataHash)
+	if err != nil || !valid {
+		as.T().Fatalf("Expected valid TPM attestation with a negative serial CA. Got: %v, %s", valid, err)
+	}
+
+	// Other formats still reject negative serials
+	_, err = ParseAttestationStatement("packed", req.EncodedAttestationStatement{X509Cert: [][]byte{chain[1].Raw}})
+	if err == nil {
+		as.T().Fatalf("Expected an error for a negative serial outside of tpm")
+	}
+}
+
+func (as *AttestationSuite) TestTPMAttestationWrongClientData() {
+	authData, clientDataHash := as.loadAttestationFixture("tpm_rsa_sha256")
+	clientDataHash[0] ^= 0xff
+	_, err := VerifyTPMAttestation(&authData, clientDataHash)
+	if err == nil {
+		as.T().Fatalf("Expected an extraData error for a different client data hash")
+	}
+}
+
+func (as *AttestationSuite) TestTPMAttestationPubAreaMismatch() {
+	authData, clientDataHash := as.loadAttestationFixture("tpm_rsa_sha256")
+	other, _ := as.loadAttestationFixture("tpm_rsa_sha1")
+	authData.AttStatement.PubArea = other.AttStatement.PubArea
+	_, err := VerifyTPMAttestation(&authData, clientDataHash)
+	if err == nil {
+		as.T().Fatalf("Expected an error for a pubArea that doesn't match the credential")
+	}
+}
+
+func (as *AttestationSuite) TestParseTPMStructures() {
+	authData, _ := as.loadAttestationFixture("tpm_ecc_p256")
+	pub, err := ParseTPMPublic(authData.AttStatement.PubArea)
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing pubArea: %s", err)
+	}
+	if pub.Type != tpmAlgECC || pub.ECCCurveID != tpmECCNistP256 {
+		as.T().Fatalf("Unexpected pubArea parsed: %#v", pub)
+	}
+	certInfo, err := ParseTPMCertifyInfo(authData.AttStatement.CertInfo)
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing certInfo: %s", err)
+	}
+	if certInfo.Magic != tpmGeneratedValue || len(certInfo.Name) == 0 {
+		as.T().Fatalf("Unexpected certInfo parsed: %#v", certInfo)
+	}
+
+	// Truncated structures are rejected
+	_, err = ParseTPMPublic(authData.AttStatement.PubArea[:20])
+	if err == nil {
+		as.T().Fatalf("Expected an error parsing a truncated pubArea")
+	}
+	_, err = ParseTPMCertifyInfo(append(authData.AttStatement.CertInfo, 0x00))
+	if err == nil {
+		as.T().Fatalf("Expected an error parsing certInfo with trailing bytes")
+	}
+}
+
+func (as *AttestationSuite) TestTPMCertificateRequirements() {
+	ca, caKey := makeTestCA("Test TPM Root")
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tpmSAN := func(manufacturer string) []byte {
+		dn, _ := asn1.Marshal(pkix.RDNSequence{
+			{{Type: oidTCGAtTPMManufacturer, Value: "id:" + manufacturer}},
+			{{Type: oidTCGAtTPMModel, Value: "NPCT6xx"}},
+			{{Type: oidTCGAtTPMVersion, Value: "id:13"}},
+		})
+		san, _ := asn1.Marshal([]asn1.RawValue{
+			{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: dn},
+		})
+		return san
+	}
+	aikTemplate := func(manufacturer string) *x509.Certificate {
+		return &x509.Certificate{
+			BasicConstraintsValid: true,
+			UnknownExtKeyUsage:    []asn1.ObjectIdentifier{oidTCGKpAIKCertificate},
+			ExtraExtensions: []pkix.Extension{
+				{Id: oidSubjectAltName, Critical: true, Value: tpmSAN(manufacturer)},
+			},
+		}
+	}
+
+	aikCert := makeCertificate(aikTemplate("4E544300"), key.Public(), ca, caKey)
+	err := checkTPMCertificateRequirements(aikCert)
+	if err != nil {
+		as.T().Fatalf("Unexpected error for a valid AIK certificate: %s", err)
+	}
+
+	unknownVendor := makeCertificate(aikTemplate("00000000"), key.Public(), ca, caKey)
+	err = checkTPMCertificateRequirements(unknownVendor)
+	if err == nil {
+		as.T().Fatalf("Expected an error for an unknown TPM manufacturer")
+	}
+
+	withSubject := aikTemplate("4E544300")
+	withSubject.Subject = pkix.Name{CommonName: "not empty"}
+	err = checkTPMCertificateRequirements(makeCertificate(withSubject, key.Public(), ca, caKey))
+	if err == nil {
+		as.T().Fatalf("Expected an error for an AIK certificate with a subject")
+	}
+
+	noEKU := aikTemplate("4E544300")
+	noEKU.UnknownExtKeyUsage = nil
+	err = checkTPMCertificateRequirements(makeCertificate(noEKU, key.Public(), ca, caKey))
+	if err == nil {
+		as.T().Fatalf("Expected an error for an AIK certificate without tcg-kp-AIKCertificate")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	req "git.jba.io/go/webauthn/request"
+	"git.jba.io/go/webauthn/trust"
+)
+
+// trustAnchors are the attestation roots loaded from trust_anchors_dir and trust_anchors
+var trustAnchors = trust.NewStore()
+
+// AssessAttestationTrust - Work out what a verified attestation statement is
+// worth. Certificate chains are validated against the trust anchors for the
+// authenticator, a valid statement whose chain can't be validated is untrusted.
+// So is the attestation of a model the metadata service reports compromised keys for.
+func AssessAttestationTrust(authData *req.DecodedAuthData) trust.Result {
+	attStmt := authData.AttStatement
+
+	if authData.Format != "none" && AuthenticatorCompromised(authData) {
+		fmt.Println("Authenticator has compromised keys according to the metadata service")
+		return trust.ResultUntrusted
+	}
+
+	switch authData.Format {
+	case "none":
+		return trust.ResultNone
+	case "android-safetynet":
+		// The JWS certificate was checked against the SafetyNet roots in step 11
+		return trust.ResultBasic
+	case "apple":
+		// The anonymization CA was checked against the Apple root CA in step 11
+		return trust.ResultAttCA
+	}
+
+	// Without x5c the statement was signed by the credential key
+	if len(attStmt.CertificateChain) == 0 {
+		return trust.ResultSelf
+	}
+
+	err := trustAnchors.Verify(authData.AAGUID, attStmt.CertificateChain, timeNow())
+	if err != nil {
+		fmt.Println("Attestation certificate chain is untrusted:", err)
+		return trust.ResultUntrusted
+	}
+
+	// TPM attestation keys are always certified by an attestation CA
+	if authData.Format == "tpm" {
+		return trust.ResultAttCA
+	}
+	return trust.ResultBasic
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+	"git.jba.io/go/webauthn/trust"
+)
+
+func (as *AttestationSuite) TestAssessAttestationTrust() {
+	defer func() { trustAnchors = trust.NewStore() }()
+
+	credKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attCert, ca := makePackedAttestationCert(attKey.Public(), testAAGUID)
+	authData := makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&credKey.PublicKey, models.COSEAlgES256))
+
+	none := as.parseTestAuthData("none", authData, req.EncodedAttestationStatement{})
+	if result := AssessAttestationTrust(&none); result != trust.ResultNone {
+		as.T().Fatalf("Unexpected trust for none attestation: %s", result)
+	}
+
+	self := as.parseTestAuthData("packed", authData, req.EncodedAttestationStatement{Algorithm: models.COSEAlgES256})
+	if result := AssessAttestationTrust(&self); result != trust.ResultSelf {
+		as.T().Fatalf("Unexpected trust for self attestation: %s", result)
+	}
+
+	full := as.parseTestAuthData("packed", authData, req.EncodedAttestationStatement{
+		Algorithm: models.COSEAlgES256,
+		X509Cert:  [][]byte{attCert.Raw},
+	})
+	if result := AssessAttestationTrust(&full); result != trust.ResultUntrusted {
+		as.T().Fatalf("Unexpected trust without trust anchors: %s", result)
+	}
+
+	// A root for some other authenticator doesn't help
+	otherCA, _ := makeTestCA("Other Root")
+	trustAnchors.AddAAGUID(testAAGUID, otherCA)
+	if result := AssessAttestationTrust(&full); result != trust.ResultUntrusted {
+		as.T().Fatalf("Unexpected trust with the wrong trust anchor: %s", result)
+	}
+
+	trustAnchors.AddAAGUID(testAAGUID, ca)
+	if result := AssessAttestationTrust(&full); result != trust.ResultBasic {
+		as.T().Fatalf("Unexpected trust with a trust anchor: %s", result)
+	}
+}
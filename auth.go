@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+
+	"git.jba.io/go/webauthn/config"
+	"git.jba.io/go/webauthn/models"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// loginCookie is the cookie that keeps the token of the login session
+const loginCookie = "login-session"
+
+type contextKey int
+
+// authenticatedKey is where RequireAuth puts the Authenticated request
+const authenticatedKey contextKey = iota
+
+// Authenticated is the logged in user of a request
+type Authenticated struct {
+	User    models.User
+	Session models.LoginSession
+}
+
+// ErrNotLoggedIn is returned when a request has no valid login session
+var ErrNotLoggedIn = errors.New("Not logged in")
+
+// LoginSessionLifetime - How long a user stays logged in
+func LoginSessionLifetime() time.Duration {
+	if config.Conf.LoginSessionLifetime <= 0 {
+		return models.DefaultLoginSessionLifetime
+	}
+	return time.Duration(config.Conf.LoginSessionLifetime) * time.Second
+}
+
+// IsSecureRequest - Whether the browser reached us over HTTPS, either directly
+// or through the reverse proxy
+func IsSecureRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return config.Conf.HasProxy && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// loginCookieOptions - The login cookie can't be read by scripts or sent
+// along with cross site requests, maxAge -1 deletes it
+func loginCookieOptions(r *http.Request, maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   IsSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// StartLoginSession - Log the user in with the credential they just used and
+// give the browser the login cookie
+func StartLoginSession(w http.ResponseWriter, r *http.Request, user *models.User, cred *models.Credential) error {
+	lifetime := LoginSessionLifetime()
+	ls, err := models.CreateLoginSession(user, cred, lifetime)
+	if err != nil {
+		return err
+	}
+	// A cookie we can't decode just gets replaced
+	session, _ := store.Get(r, loginCookie)
+	session.Values["token"] = ls.Token
+	session.Options = loginCookieOptions(r, int(lifetime/time.Second))
+	return session.Save(r, w)
+}
+
+// clearLoginCookie - Tell the browser to forget the login cookie
+func clearLoginCookie(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, loginCookie)
+	session.Values = map[interface{}]interface{}{}
+	session.Options = loginCookieOptions(r, -1)
+	session.Save(r, w)
+}
+
+// CurrentLogin - The logged in user from the login cookie of a request
+func CurrentLogin(r *http.Request) (Authenticated, error) {
+	session, err := store.Get(r, loginCookie)
+	if err != nil {
+		return Authenticated{}, ErrNotLoggedIn
+	}
+	token, ok := session.Values["token"].(string)
+	if !ok || token == "" {
+		return Authenticated{}, ErrNotLoggedIn
+	}
+	ls, err := models.GetLoginSessionByToken(token)
+	if err != nil {
+		return Authenticated{}, ErrNotLoggedIn
+	}
+	user, err := models.GetUser(ls.UserID)
+	if err != nil {
+		fmt.Println("Error getting the user of a login session:", err)
+		return Authenticated{}, ErrNotLoggedIn
+	}
+	return Authenticated{User: user, Session: ls}, nil
+}
+
+// RequireAuth - Middleware that only lets logged in users through. Pages
+// redirect to the login page, everything else gets a 401.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, err := CurrentLogin(r)
+		if err != nil {
+			if strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			JSONResponse(w, "Please log in", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), authenticatedKey, auth)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AuthenticatedUser - The logged in user of a request that went through RequireAuth
+func AuthenticatedUser(r *http.Request) Authenticated {
+	auth, _ := r.Context().Value(authenticatedKey).(Authenticated)
+	return auth
+}
+
+// Logout - End the login session of the request
+func Logout(w http.ResponseWriter, r *http.Request) {
+	auth := AuthenticatedUser(r)
+	err := models.DeleteLoginSession(auth.Session.ID)
+	if err != nil && err != models.ErrNotFound {
+		fmt.Println("Error deleting login session:", err)
+		JSONResponse(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+	clearLoginCookie(w, r)
+	JSONResponse(w, "Success", http.StatusOK)
+}
+
+// GetLoginSessions - List the active login sessions of the logged in user
+func GetLoginSessions(w http.ResponseWriter, r *http.Request) {
+	auth := AuthenticatedUser(r)
+	sessions, err := models.GetLoginSessionsForUser(&auth.User)
+	if err != nil {
+		fmt.Println("Error getting login sessions:", err)
+		JSONResponse(w, "Error getting sessions", http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, res.FormatLoginSessions(sessions, auth.Session.ID), http.StatusOK)
+}
+
+// RevokeLoginSession - Log out one of the logged in user's sessions
+func RevokeLoginSession(w http.ResponseWriter, r *http.Request) {
+	auth := AuthenticatedUser(r)
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		JSONResponse(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	// Users can only revoke their own sessions
+	sessions, err := models.GetLoginSessionsForUser(&auth.User)
+	if err != nil {
+		fmt.Println("Error getting login sessions:", err)
+		JSONResponse(w, "Error getting sessions", http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, ls := range sessions {
+		if ls.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		JSONResponse(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	err = models.DeleteLoginSession(id)
+	if err != nil {
+		fmt.Println("Error deleting login session:", err)
+		JSONResponse(w, "Error revoking session", http.StatusInternalServerError)
+		return
+	}
+	if id == auth.Session.ID {
+		clearLoginCookie(w, r)
+	}
+	JSONResponse(w, "Success", http.StatusOK)
+}
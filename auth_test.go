@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"git.jba.io/go/webauthn/models"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// login logs a user in with a new credential and returns the login cookie.
+// The user is created when it doesn't exist yet.
+func (hs *HandlersSuite) login(name string) *http.Cookie {
+	user, err := models.GetUserByUsername(name)
+	if err == models.ErrNotFound {
+		user = models.User{Name: name, DisplayName: name}
+		err = models.PutUser(&user)
+	}
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting user: %s", err)
+	}
+	cred := models.Credential{CredID: fmt.Sprintf("login-%d", time.Now().UnixNano()), UserID: user.ID, RelyingPartyID: "localhost"}
+	err = models.CreateCredential(&cred)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error creating credential: %s", err)
+	}
+
+	r := httptest.NewRequest("POST", "/assertion", nil)
+	w := httptest.NewRecorder()
+	err = StartLoginSession(w, r, &user, &cred)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error starting login session: %s", err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == loginCookie {
+			if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+				hs.T().Fatalf("Login cookie needs to be HttpOnly and SameSite, got %#v", c)
+			}
+			return c
+		}
+	}
+	hs.T().Fatalf("No login cookie was set")
+	return nil
+}
+
+func (hs *HandlersSuite) do(method, path string, cookie *http.Cookie) *http.Response {
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error requesting %s %s: %s", method, path, err)
+	}
+	return resp
+}
+
+func (hs *HandlersSuite) TestRequireAuth() {
+	resp := hs.do("GET", "/sessions", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		hs.T().Fatalf("Unexpected status without a login cookie. Expected %d, Got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	forged := &http.Cookie{Name: loginCookie, Value: "forged"}
+	resp = hs.do("GET", "/sessions", forged)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		hs.T().Fatalf("Unexpected status with a forged login cookie. Expected %d, Got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func (hs *HandlersSuite) TestLoginSessions() {
+	other := hs.login("sessions@example.com")
+	current := hs.login("sessions@example.com")
+
+	resp := hs.do("GET", "/sessions", current)
+	sessions := []res.LoginSessionResponse{}
+	err := json.NewDecoder(resp.Body).Decode(&sessions)
+	resp.Body.Close()
+	if err != nil {
+		hs.T().Fatalf("Unexpected error decoding sessions: %s", err)
+	}
+	var otherID int64
+	currentCount := 0
+	for _, ls := range sessions {
+		if ls.Current {
+			currentCount++
+		} else {
+			otherID = ls.ID
+		}
+		if ls.CredID == "" || ls.CreatedAt.IsZero() {
+			hs.T().Fatalf("Session is missing its credential or time: %#v", ls)
+		}
+	}
+	if len(sessions) != 2 || currentCount != 1 {
+		hs.T().Fatalf("Unexpected sessions received: %#v", sessions)
+	}
+
+	// Revoking a session logs it out
+	resp = hs.do("DELETE", fmt.Sprintf("/sessions/%d", otherID), current)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		hs.T().Fatalf("Unexpected status revoking a session. Expected %d, Got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = hs.do("GET", "/sessions", other)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		hs.T().Fatalf("Unexpected status with a revoked session. Expected %d, Got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp = hs.do("POST", "/logout", current)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		hs.T().Fatalf("Unexpected status logging out. Expected %d, Got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = hs.do("GET", "/sessions", current)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		hs.T().Fatalf("Unexpected status after logging out. Expected %d, Got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+
+	req "git.jba.io/go/webauthn/request"
+)
+
+// Lengths of the fixed parts of authenticator data
+const (
+	authDataMinLength   = 37
+	attestedDataMinSize = 18
+)
+
+// ParseAuthenticatorData - Parses authenticator data, as returned during
+// registration and authentication. Attested credential data is only read when
+// the AT flag is set and extensions only when ED is set. Anything after them
+// is an error.
+func ParseAuthenticatorData(raw []byte) (req.AuthenticatorData, error) {
+	authData := req.AuthenticatorData{Raw: raw}
+
+	if len(raw) < authDataMinLength {
+		err := fmt.Errorf("Authenticator data is %d bytes, it must be at least %d", len(raw), authDataMinLength)
+		return authData, err
+	}
+
+	authData.RPIDHash = raw[:32]
+	authData.Flags = req.AuthenticatorFlags(raw[32])
+	authData.Counter = binary.BigEndian.Uint32(raw[33:37])
+
+	rest := raw[authDataMinLength:]
+
+	if authData.Flags.HasAttestedCredentialData() {
+		if len(rest) < attestedDataMinSize {
+			err := errors.New("Authenticator data is too short for attested credential data")
+			return authData, err
+		}
+		aaguid := rest[:16]
+		credIDLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[attestedDataMinSize:]
+		if len(rest) < credIDLen {
+			err := errors.New("Authenticator data is too short for the credential ID")
+			return authData, err
+		}
+		credID := rest[:credIDLen]
+		rest = rest[credIDLen:]
+
+		keyLen, err := cborItemLength(rest)
+		if err != nil {
+			fmt.Println("Error decoding the credential public key:", err)
+			err := errors.New("Error decoding the credential public key in authenticator data")
+			return authData, err
+		}
+		authData.AttestedCredentialData = &req.AttestedCredentialData{
+			AAGUID:              aaguid,
+			CredentialID:        credID,
+			CredentialPublicKey: rest[:keyLen],
+		}
+		rest = rest[keyLen:]
+	}
+
+	if authData.Flags.HasExtensions() {
+		var extensions map[string]interface{}
+		var handler codec.Handle = new(codec.CborHandle)
+		decoder := codec.NewDecoderBytes(rest, handler)
+		err := decoder.Decode(&extensions)
+		if err != nil {
+			fmt.Println("Error decoding extensions:", err)
+			err := errors.New("Error decoding the extensions in authenticator data")
+			return authData, err
+		}
+		authData.Extensions = extensions
+		rest = rest[decoder.NumBytesRead():]
+	}
+
+	if len(rest) != 0 {
+		err := fmt.Errorf("Authenticator data has %d unexpected trailing bytes", len(rest))
+		return authData, err
+	}
+
+	return authData, nil
+}
+
+// cborItemLength returns the length of the CBOR data item at the start of data
+func cborItemLength(data []byte) (int, error) {
+	var item interface{}
+	var handler codec.Handle = new(codec.CborHandle)
+	decoder := codec.NewDecoderBytes(data, handler)
+	err := decoder.Decode(&item)
+	if err != nil {
+		return 0, err
+	}
+	return decoder.NumBytesRead(), nil
+}
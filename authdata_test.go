@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+
+	req "git.jba.io/go/webauthn/request"
+)
+
+// makeAssertionAuthData assembles authenticator data without attested credential data
+func makeAssertionAuthData(rpID string, flags byte, counter uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, flags)
+	return binary.BigEndian.AppendUint32(authData, counter)
+}
+
+func (as *AttestationSuite) TestParseAuthenticatorDataAssertion() {
+	flags := byte(req.FlagUserPresent | req.FlagUserVerified | req.FlagBackupEligible | req.FlagBackupState)
+	authData, err := ParseAuthenticatorData(makeAssertionAuthData("localhost", flags, 0x01020304))
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing authenticator data: %s", err)
+	}
+	if authData.Counter != 0x01020304 {
+		as.T().Fatalf("Unexpected counter %d", authData.Counter)
+	}
+	if !authData.Flags.UserPresent() || !authData.Flags.UserVerified() ||
+		!authData.Flags.BackupEligible() || !authData.Flags.BackupState() {
+		as.T().Fatalf("Unexpected flags %s", authData.Flags)
+	}
+	if authData.AttestedCredentialData != nil || authData.Extensions != nil {
+		as.T().Fatalf("Unexpected attested credential data or extensions")
+	}
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	if hex.EncodeToString(authData.RPIDHash) != hex.EncodeToString(rpIDHash[:]) {
+		as.T().Fatalf("Unexpected RP ID hash %x", authData.RPIDHash)
+	}
+}
+
+func (as *AttestationSuite) TestParseAuthenticatorDataExtensions() {
+	raw := makeAssertionAuthData("localhost", byte(req.FlagUserPresent|req.FlagExtensionData), 7)
+	raw = append(raw, encodeCBOR(map[string]interface{}{"credProtect": 2})...)
+	authData, err := ParseAuthenticatorData(raw)
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing authenticator data with extensions: %s", err)
+	}
+	if _, ok := authData.Extensions["credProtect"]; !ok {
+		as.T().Fatalf("Expected the credProtect extension. Got: %v", authData.Extensions)
+	}
+
+	// Extensions after attested credential data
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	raw = makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&key.PublicKey, -7))
+	raw[32] |= byte(req.FlagExtensionData)
+	raw = append(raw, encodeCBOR(map[string]interface{}{"hmac-secret": true})...)
+	authData, err = ParseAuthenticatorData(raw)
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing authenticator data with extensions: %s", err)
+	}
+	if authData.AttestedCredentialData == nil || authData.Extensions["hmac-secret"] != true {
+		as.T().Fatalf("Unexpected authenticator data %+v", authData)
+	}
+}
+
+func (as *AttestationSuite) TestParseAuthenticatorDataLongCredentialID() {
+	// Credential IDs over 255 bytes need both length bytes
+	credID := []byte(strings.Repeat("c", 300))
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	coseKey := encodeEC2Key(&key.PublicKey, -7)
+	authData, err := ParseAuthenticatorData(makeAuthData("localhost", testAAGUID, credID, coseKey))
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing authenticator data: %s", err)
+	}
+	attested := authData.AttestedCredentialData
+	if string(attested.CredentialID) != string(credID) || string(attested.CredentialPublicKey) != string(coseKey) {
+		as.T().Fatalf("Unexpected attested credential data %+v", attested)
+	}
+	if string(attested.AAGUID) != string(testAAGUID) {
+		as.T().Fatalf("Unexpected AAGUID %x", attested.AAGUID)
+	}
+}
+
+func (as *AttestationSuite) TestParseAuthenticatorDataErrors() {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	registration := makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&key.PublicKey, -7))
+
+	tests := map[string][]byte{
+		"too short":                  makeAssertionAuthData("localhost", 0x01, 0)[:36],
+		"trailing bytes":             append(makeAssertionAuthData("localhost", 0x01, 0), 0x00),
+		"trailing bytes after key":   append(append([]byte{}, registration...), 0xa0),
+		"truncated credential id":    registration[:37+18+5],
+		"missing extensions":         makeAssertionAuthData("localhost", byte(req.FlagUserPresent|req.FlagExtensionData), 0),
+		"truncated attested data":    makeAssertionAuthData("localhost", byte(req.FlagUserPresent|req.FlagAttestedCredentialData), 0),
+		"truncated credential key":   registration[:len(registration)-1],
+		"attested data without flag": append(makeAssertionAuthData("localhost", 0x01, 0), registration[37:]...),
+	}
+	for name, raw := range tests {
+		_, err := ParseAuthenticatorData(raw)
+		if err == nil {
+			as.T().Fatalf("Expected an error for %s", name)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestParseAuthDataRequiresAttestedCredentialData() {
+	_, err := ParseAuthData(req.EncodedAuthData{
+		AuthData: makeAssertionAuthData("localhost", byte(req.FlagUserPresent), 0),
+		Format:   "none",
+	})
+	if err == nil {
+		as.T().Fatalf("Expected an error for registration without attested credential data")
+	}
+}
+
+func (as *AttestationSuite) TestParseAssertionData() {
+	raw := makeAssertionAuthData("localhost", byte(req.FlagUserPresent|req.FlagExtensionData), 42)
+	raw = append(raw, encodeCBOR(map[string]interface{}{"appid": true})...)
+	assertion, err := ParseAssertionData(raw, []byte{0x30, 0x45})
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing assertion data with extensions: %s", err)
+	}
+	if assertion.Counter != 42 || !assertion.Flags.UserPresent() || assertion.Extensions["appid"] != true {
+		as.T().Fatalf("Unexpected assertion data %+v", assertion)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"git.jba.io/go/webauthn/models"
+)
+
+// CanManage - Whether the logged in user may act on the resources of a user.
+// Users manage their own, admins manage everyone's.
+func CanManage(auth Authenticated, owner *models.User) bool {
+	if auth.User.ID == 0 {
+		return false
+	}
+	return auth.User.ID == owner.ID || auth.User.IsAdmin()
+}
+
+// Deny - Answer with a 403 and keep an audit entry of the attempt
+func Deny(w http.ResponseWriter, r *http.Request, auth Authenticated, action string, target string, reason string) {
+	AuditDenied(r, auth, action, target, reason)
+	JSONResponse(w, "You are not allowed to do that", http.StatusForbidden)
+}
+
+// AuditDenied - Keep an audit entry of an attempt that was denied
+func AuditDenied(r *http.Request, auth Authenticated, action string, target string, reason string) {
+	models.Audit(models.AuditEntry{
+		UserID:     auth.User.ID,
+		Action:     action,
+		Target:     target,
+		RemoteAddr: r.RemoteAddr,
+		Reason:     reason,
+	})
+}
+
+// AuthorizeUsername - Check the logged in user may act on the resources of the
+// user with the given name, denying the request when they can't. Users that
+// don't exist are denied like other people's so nobody learns which users
+// exist, only admins get a 404. It returns the user and whether the request
+// can go on.
+func AuthorizeUsername(w http.ResponseWriter, r *http.Request, username string, action string) (models.User, bool) {
+	auth := AuthenticatedUser(r)
+	user, err := models.GetUserByUsername(username)
+	if err != nil && err != models.ErrNotFound {
+		fmt.Println("Error getting user:", err)
+		JSONResponse(w, "Error getting user", http.StatusInternalServerError)
+		return user, false
+	}
+	if err == nil && CanManage(auth, &user) {
+		return user, true
+	}
+	if err == models.ErrNotFound && auth.User.IsAdmin() {
+		JSONResponse(w, "User not found", http.StatusNotFound)
+		return user, false
+	}
+	Deny(w, r, auth, action, username, fmt.Sprintf("%q is not %q or an admin", auth.User.Name, username))
+	return user, false
+}
+
+// RequireAdmin - Deny the request unless the logged in user is an admin. It
+// returns whether the request can go on.
+func RequireAdmin(w http.ResponseWriter, r *http.Request, action string, target string) bool {
+	auth := AuthenticatedUser(r)
+	if auth.User.IsAdmin() {
+		return true
+	}
+	Deny(w, r, auth, action, target, fmt.Sprintf("%q is not an admin", auth.User.Name))
+	return false
+}
+
+// GetAuditLog - The audit log, only for admins
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !RequireAdmin(w, r, "audit.list", "") {
+		return
+	}
+	entries, err := models.GetAuditEntries()
+	if err != nil {
+		fmt.Println("Error getting audit entries:", err)
+		JSONResponse(w, "Error getting the audit log", http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, entries, http.StatusOK)
+}
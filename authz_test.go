@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+)
+
+// createCredential registers a credential for a user without a ceremony
+func (hs *HandlersSuite) createCredential(name string, credID string) {
+	user, err := models.GetUserByUsername(name)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting user: %s", err)
+	}
+	cred := models.Credential{CredID: credID, UserID: user.ID, RelyingPartyID: "localhost"}
+	err = models.CreateCredential(&cred)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error creating credential: %s", err)
+	}
+}
+
+// loginAdmin logs in a user that was granted the admin role
+func (hs *HandlersSuite) loginAdmin() *http.Cookie {
+	cookie := hs.login("admin@example.com")
+	err := RunCommand([]string{"admin", "grant", "admin@example.com"}, &bytes.Buffer{})
+	if err != nil {
+		hs.T().Fatalf("Unexpected error granting the admin role: %s", err)
+	}
+	return cookie
+}
+
+func (hs *HandlersSuite) expectStatus(method, path string, cookie *http.Cookie, expected int) {
+	resp := hs.do(method, path, cookie)
+	resp.Body.Close()
+	if resp.StatusCode != expected {
+		hs.T().Fatalf("Unexpected status for %s %s. Expected %d, Got %d", method, path, expected, resp.StatusCode)
+	}
+}
+
+func (hs *HandlersSuite) TestOwnership() {
+	alice := hs.login("alice@example.com")
+	mallory := hs.login("mallory@example.com")
+	admin := hs.loginAdmin()
+	hs.createCredential("alice@example.com", "alice-key")
+
+	before, err := models.GetAuditEntries()
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting audit entries: %s", err)
+	}
+
+	hs.expectStatus("GET", "/user/alice@example.com", alice, http.StatusOK)
+	hs.expectStatus("GET", "/credential/alice@example.com", alice, http.StatusOK)
+	hs.expectStatus("GET", "/user/alice@example.com", mallory, http.StatusForbidden)
+	hs.expectStatus("GET", "/credential/alice@example.com", mallory, http.StatusForbidden)
+	hs.expectStatus("DELETE", "/credential/alice-key", mallory, http.StatusForbidden)
+	hs.expectStatus("GET", "/audit", mallory, http.StatusForbidden)
+	// Adding an authenticator to someone else's account
+	hs.expectStatus("GET", "/makeCredential/alice@example.com?rpId=localhost", mallory, http.StatusForbidden)
+
+	after, err := models.GetAuditEntries()
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting audit entries: %s", err)
+	}
+	if len(after)-len(before) != 5 {
+		hs.T().Fatalf("Unexpected number of audit entries. Expected 5, Got %d", len(after)-len(before))
+	}
+	last := after[len(after)-1]
+	if last.Action != "credential.create" || last.Target != "alice@example.com" || last.UserID == 0 {
+		hs.T().Fatalf("Unexpected audit entry: %#v", last)
+	}
+
+	// Admins manage everyone
+	hs.expectStatus("GET", "/user/alice@example.com", admin, http.StatusOK)
+	hs.expectStatus("GET", "/credential/alice@example.com", admin, http.StatusOK)
+	hs.expectStatus("GET", "/audit", admin, http.StatusOK)
+	hs.expectStatus("DELETE", "/credential/alice-key", admin, http.StatusOK)
+
+	// Only admins learn a user doesn't exist
+	hs.expectStatus("GET", "/user/nobody@example.com", mallory, http.StatusForbidden)
+	hs.expectStatus("GET", "/user/nobody@example.com", admin, http.StatusNotFound)
+}
+
+func (hs *HandlersSuite) TestDeleteLastCredential() {
+	cookie := hs.login("last@example.com")
+	user, _ := models.GetUserByUsername("last@example.com")
+	creds, _ := models.GetCredentialsForUser(&user)
+	if len(creds) != 1 {
+		hs.T().Fatalf("Unexpected number of credentials. Expected 1, Got %d", len(creds))
+	}
+	path := fmt.Sprintf("/credential/%s", creds[0].CredID)
+
+	hs.expectStatus("DELETE", path, cookie, http.StatusConflict)
+	hs.expectStatus("DELETE", path+"?confirm=true", cookie, http.StatusOK)
+}
+
+func (hs *HandlersSuite) TestAdminCommand() {
+	out := &bytes.Buffer{}
+	err := RunCommand([]string{"admin", "grant", "nobody@example.com"}, out)
+	if err == nil {
+		hs.T().Fatalf("Expected an error granting the admin role to a missing user")
+	}
+
+	// A user without an authenticator can't be made an admin
+	user := models.User{Name: "unregistered@example.com"}
+	err = models.PutUser(&user)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error creating user: %s", err)
+	}
+	err = RunCommand([]string{"admin", "grant", user.Name}, out)
+	if err == nil {
+		hs.T().Fatalf("Expected an error granting the admin role to a user without credentials")
+	}
+
+	hs.createCredential(user.Name, "unregistered-key")
+	err = RunCommand([]string{"admin", "grant", user.Name}, out)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error granting the admin role: %s", err)
+	}
+	user, _ = models.GetUserByUsername(user.Name)
+	if !user.IsAdmin() {
+		hs.T().Fatalf("Expected %s to be an admin", user.Name)
+	}
+
+	err = RunCommand([]string{"admin", "revoke", user.Name}, out)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error revoking the admin role: %s", err)
+	}
+	user, _ = models.GetUserByUsername(user.Name)
+	if user.IsAdmin() {
+		hs.T().Fatalf("Expected %s to no longer be an admin", user.Name)
+	}
+}
+
+func (hs *HandlersSuite) TestNoDefaultAdmin() {
+	_, err := models.GetUserByUsername("admin")
+	if err != models.ErrNotFound {
+		hs.T().Fatalf("Expected no default admin user. Got: %v", err)
+	}
+
+	// Nobody can register the first authenticator of a user with a role
+	user := models.User{Name: "roleholder@example.com", Role: models.RoleAdmin}
+	err = models.PutUser(&user)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error creating user: %s", err)
+	}
+	hs.expectStatus("GET", "/makeCredential/roleholder@example.com?rpId=localhost", nil, http.StatusForbidden)
+}
+
+func (hs *HandlersSuite) TestRegistrationLeavesNoOrphans() {
+	// The relying party is checked before anyone is created
+	hs.expectStatus("GET", "/makeCredential/orphan@example.com?rpId=unknown.example.com", nil, http.StatusBadRequest)
+	cc := hs.newCeremonyClient()
+	status := cc.send("POST", "/attestation/options?rpId=unknown.example.com", req.ServerPublicKeyCredentialCreationOptionsRequest{
+		Username: "orphan@example.com",
+	}, nil)
+	if status != http.StatusBadRequest {
+		hs.T().Fatalf("Unexpected status for an unknown relying party. Expected %d, Got %d", http.StatusBadRequest, status)
+	}
+	_, err := models.GetUserByUsername("orphan@example.com")
+	if err != models.ErrNotFound {
+		hs.T().Fatalf("Expected no user to be created. Got: %v", err)
+	}
+
+	// Starting the ceremony creates them
+	hs.expectStatus("GET", "/makeCredential/orphan@example.com?rpId=localhost", nil, http.StatusOK)
+	_, err = models.GetUserByUsername("orphan@example.com")
+	if err != nil {
+		hs.T().Fatalf("Expected the user to be created. Got: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+
+	"git.jba.io/go/webauthn/config"
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// ceremonyClient talks to the test server the way a browser on the default
+// relying party would, keeping the ceremony cookies
+type ceremonyClient struct {
+	hs     *HandlersSuite
+	client *http.Client
+}
+
+func (hs *HandlersSuite) newCeremonyClient() *ceremonyClient {
+	jar, _ := cookiejar.New(nil)
+	return &ceremonyClient{hs: hs, client: &http.Client{Jar: jar}}
+}
+
+func (cc *ceremonyClient) send(method, path string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	r, _ := http.NewRequest(method, server.URL+path, reader)
+	r.Host = "localhost"
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cc.client.Do(r)
+	if err != nil {
+		cc.hs.T().Fatalf("Unexpected error requesting %s %s: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// clientDataJSON is what the browser signs over for a ceremony
+func (hs *HandlersSuite) clientDataJSON(ceremony, challenge string) []byte {
+	rp, err := models.GetDefaultRelyingParty()
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting the default RP: %s", err)
+	}
+	raw, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    rp.DefaultOrigins()[0],
+	})
+	return raw
+}
+
+func (hs *HandlersSuite) TestJSONCeremonies() {
+	enc := base64.RawURLEncoding.EncodeToString
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credID := []byte("json-ceremony-credential")
+	cc := hs.newCeremonyClient()
+
+	// The options are PublicKeyCredentialCreationOptionsJSON
+	options := map[string]interface{}{}
+	status := cc.send("GET", "/makeCredential/json@example.com", nil, &options)
+	if status != http.StatusOK {
+		hs.T().Fatalf("Unexpected status getting creation options: %d", status)
+	}
+	challenge, _ := options["challenge"].(string)
+	user, _ := options["user"].(map[string]interface{})
+	userID, _ := user["id"].(string)
+	if _, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || challenge == "" {
+		hs.T().Fatalf("Challenge %q is not base64url: %v", challenge, err)
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(userID); err != nil || userID == "" {
+		hs.T().Fatalf("User ID %q is not base64url: %v", userID, err)
+	}
+	if _, ok := options["extenstions"]; ok {
+		hs.T().Fatalf("Options still have the misspelled extensions: %v", options)
+	}
+	if _, ok := options["extensions"]; ok {
+		hs.T().Fatalf("Options ask for extensions we don't use: %v", options)
+	}
+
+	attObj := encodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"authData": makeAuthData("localhost", make([]byte, 16), credID, encodeEC2Key(&key.PublicKey, models.COSEAlgES256)),
+		"attStmt":  map[string]interface{}{},
+	})
+	registration := req.RegistrationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAttestationResponseJSON{
+			ClientDataJSON:    enc(hs.clientDataJSON("webauthn.create", challenge)),
+			AttestationObject: enc(attObj),
+		},
+		ClientExtensionResults: map[string]interface{}{},
+	}
+	result := res.CredentialActionResponse{}
+	status = cc.send("POST", "/makeCredential", registration, &result)
+	if status != http.StatusOK || !result.Success || result.Credential.CredID != enc(credID) {
+		hs.T().Fatalf("Unexpected registration result %d %#v", status, result)
+	}
+
+	// Then log in with it, the options are PublicKeyCredentialRequestOptionsJSON
+	assertionOptions := res.AssertionOptionsResponse{}
+	status = cc.send("GET", "/assertion/json@example.com", nil, &assertionOptions)
+	if status != http.StatusOK || len(assertionOptions.AllowList) != 1 || assertionOptions.AllowList[0].CredID != enc(credID) {
+		hs.T().Fatalf("Unexpected assertion options %d %#v", status, assertionOptions)
+	}
+
+	// Registering left the counter at 1
+	authData := makeAssertionAuthData("localhost", 0x01, 2)
+	clientData := hs.clientDataJSON("webauthn.get", assertionOptions.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	assertion := req.AuthenticationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAssertionResponseJSON{
+			ClientDataJSON:    enc(clientData),
+			AuthenticatorData: enc(authData),
+			Signature:         enc(sig),
+			UserHandle:        userID,
+		},
+		ClientExtensionResults: map[string]interface{}{},
+	}
+	result = res.CredentialActionResponse{}
+	status = cc.send("POST", "/assertion", assertion, &result)
+	if status != http.StatusOK || !result.Success {
+		hs.T().Fatalf("Unexpected assertion result %d %#v", status, result)
+	}
+}
+
+func (hs *HandlersSuite) TestCredentialJSONChecks() {
+	cc := hs.newCeremonyClient()
+
+	// Form posts from the old script aren't accepted anymore
+	cc.send("GET", "/assertion", nil, nil)
+	r, _ := http.NewRequest("POST", server.URL+"/assertion", bytes.NewBufferString("id=x"))
+	r.Host = "localhost"
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cc.client.Do(r)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error posting a form: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		hs.T().Fatalf("Unexpected status for a form post. Expected %d, Got %d", http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+
+	cc.send("GET", "/assertion", nil, nil)
+
+	mismatched := req.AuthenticationResponseJSON{
+		ID:    "YWJj",
+		RawID: "eHl6",
+		Type:  "public-key",
+		Response: req.AuthenticatorAssertionResponseJSON{
+			ClientDataJSON:    "e30",
+			AuthenticatorData: "AA",
+			Signature:         "AA",
+		},
+	}
+	status := cc.send("POST", "/assertion", mismatched, nil)
+	if status != http.StatusBadRequest {
+		hs.T().Fatalf("Unexpected status for mismatched id and rawId. Expected %d, Got %d", http.StatusBadRequest, status)
+	}
+}
+
+func (hs *HandlersSuite) TestRegistrationAlgorithmNotOffered() {
+	enc := base64.RawURLEncoding.EncodeToString
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credID := []byte("not-offered-credential")
+	cc := hs.newCeremonyClient()
+
+	// Only RS256 is offered, the authenticator answers with an ES256 key
+	config.Conf.CredentialAlgorithms = []int64{models.COSEAlgRS256}
+	defer func() { config.Conf.CredentialAlgorithms = nil }()
+	options := res.MakeCredentialResponse{}
+	status := cc.send("GET", "/makeCredential/es256@example.com", nil, &options)
+	if status != http.StatusOK || len(options.Parameters) != 1 {
+		hs.T().Fatalf("Unexpected creation options %d %#v", status, options)
+	}
+
+	attObj := encodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"authData": makeAuthData("localhost", make([]byte, 16), credID, encodeEC2Key(&key.PublicKey, models.COSEAlgES256)),
+		"attStmt":  map[string]interface{}{},
+	})
+	status = cc.send("POST", "/makeCredential", req.RegistrationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAttestationResponseJSON{
+			ClientDataJSON:    enc(hs.clientDataJSON("webauthn.create", options.Challenge)),
+			AttestationObject: enc(attObj),
+		},
+	}, nil)
+	if status != http.StatusBadRequest {
+		hs.T().Fatalf("Unexpected status for an algorithm that wasn't offered. Expected %d, Got %d", http.StatusBadRequest, status)
+	}
+	_, err := models.GetCredentialByCredID(enc(credID))
+	if err == nil {
+		hs.T().Fatalf("Expected the credential not to be stored")
+	}
+}
+
+func (hs *HandlersSuite) TestRegistrationFIDOU2FKeyTypes() {
+	enc := base64.RawURLEncoding.EncodeToString
+	ca, caKey := makeTestCA("Test U2F Root")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	cc := hs.newCeremonyClient()
+
+	register := func(name string, certKey crypto.Signer, credKey *ecdsa.PrivateKey, alg int64) int {
+		credID := []byte(name + "-credential")
+		options := res.MakeCredentialResponse{}
+		cc.send("GET", "/makeCredential/"+name+"@example.com", nil, &options)
+		clientData := hs.clientDataJSON("webauthn.create", options.Challenge)
+		clientDataHash := sha256.Sum256(clientData)
+		rpIDHash := sha256.Sum256([]byte("localhost"))
+		size := (credKey.Curve.Params().BitSize + 7) / 8
+		signed := append([]byte{0x00}, rpIDHash[:]...)
+		signed = append(signed, clientDataHash[:]...)
+		signed = append(signed, credID...)
+		signed = append(signed, 0x04)
+		signed = append(signed, credKey.X.FillBytes(make([]byte, size))...)
+		signed = append(signed, credKey.Y.FillBytes(make([]byte, size))...)
+		digest := sha256.Sum256(signed)
+		sig, _ := ecKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+		cert := makeCertificate(&x509.Certificate{}, certKey.Public(), ca, caKey)
+
+		attObj := encodeCBOR(map[string]interface{}{
+			"fmt":      "fido-u2f",
+			"authData": makeAuthData("localhost", make([]byte, 16), credID, encodeEC2Key(&credKey.PublicKey, alg)),
+			"attStmt":  map[string]interface{}{"sig": sig, "x5c": [][]byte{cert.Raw}},
+		})
+		return cc.send("POST", "/makeCredential", req.RegistrationResponseJSON{
+			ID:    enc(credID),
+			RawID: enc(credID),
+			Type:  "public-key",
+			Response: req.AuthenticatorAttestationResponseJSON{
+				ClientDataJSON:    enc(clientData),
+				AttestationObject: enc(attObj),
+			},
+		}, nil)
+	}
+
+	status := register("u2f", ecKey, ecKey, models.COSEAlgES256)
+	if status != http.StatusOK {
+		hs.T().Fatalf("Unexpected status for a fido-u2f registration. Expected %d, Got %d", http.StatusOK, status)
+	}
+	status = register("u2f-rsa", rsaKey, ecKey, models.COSEAlgES256)
+	if status != http.StatusBadRequest {
+		hs.T().Fatalf("Unexpected status for an RSA attestation certificate. Expected %d, Got %d", http.StatusBadRequest, status)
+	}
+	status = register("u2f-p384", ecKey, p384Key, models.COSEAlgES384)
+	if status != http.StatusBadRequest {
+		hs.T().Fatalf("Unexpected status for a P-384 credential. Expected %d, Got %d", http.StatusBadRequest, status)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// LoadCertPool reads a PEM encoded bundle of certificates into a pool
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pemCerts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		err := fmt.Errorf("No certificates found in %s", path)
+		return nil, err
+	}
+	return pool, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"git.jba.io/go/webauthn/models"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// int64List is a flag for numbers that can be given more than once
+type int64List []int64
+
+func (l *int64List) String() string {
+	values := []string{}
+	for _, v := range *l {
+		values = append(values, strconv.FormatInt(v, 10))
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *int64List) Set(value string) error {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+const rpUsage = `Usage: webauthn rp <command> [flags]
+
+Commands:
+  list              List the relying parties
+  add -id <id>      Create a relying party
+  update -id <id>   Change the given settings of a relying party
+  delete -id <id>   Delete a relying party`
+
+const adminUsage = `Usage: webauthn admin <command> <username>
+
+Commands:
+  grant <username>    Make a user an admin, they need to have registered an authenticator
+  revoke <username>   Take the admin role away from a user`
+
+// RunCommand - Run a command line subcommand instead of the web server,
+// writing its output to out
+func RunCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("No command given")
+	}
+	switch args[0] {
+	case "rp":
+		return relyingPartyCommand(args[1:], out)
+	case "admin":
+		return adminCommand(args[1:], out)
+	}
+	return fmt.Errorf("Unknown command %q", args[0])
+}
+
+// relyingPartyCommand - Manage the relying parties from the command line
+func relyingPartyCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(rpUsage)
+	}
+
+	fs := flag.NewFlagSet("rp "+args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	settings := RelyingPartySettings{}
+	origins := stringList{}
+	relatedOrigins := stringList{}
+	algs := int64List{}
+	fs.StringVar(&settings.ID, "id", "", "ID of the relying party, its domain")
+	fs.StringVar(&settings.DisplayName, "name", "", "Display name")
+	fs.StringVar(&settings.Icon, "icon", "", "Icon URL")
+	fs.Var(&origins, "origin", "Allowed origin, https://*.example.com allows subdomains, can be repeated")
+	fs.Var(&relatedOrigins, "related-origin", "Origin on another domain listed in /.well-known/webauthn, can be repeated")
+	fs.StringVar(&settings.Attestation, "attestation", "", "Attestation preference: none, indirect, direct or enterprise")
+	fs.StringVar(&settings.UserVerification, "uv", "", "User verification: required, preferred or discouraged")
+	fs.Var(&algs, "alg", "COSE algorithm offered for new credentials, can be repeated")
+	fs.StringVar(&settings.AuthenticatorAttachment, "attachment", "", "Authenticator attachment: platform or cross-platform")
+	fs.StringVar(&settings.CounterPolicy, "counter-policy", "", "Counter policy: reject, suspect or allow")
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	settings.Origins = origins
+	settings.RelatedOrigins = relatedOrigins
+	settings.Algorithms = algs
+
+	switch args[0] {
+	case "list":
+		rps, err := models.GetRelyingParties()
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, res.FormatRelyingParties(rps))
+	case "add":
+		rp := models.RelyingParty{ID: settings.ID}
+		settings.Apply(&rp)
+		err = models.CreateRelyingParty(&rp)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, res.FormatRelyingParty(rp))
+	case "update":
+		rp, err := models.GetRelyingPartyByHost(settings.ID)
+		if err != nil {
+			return fmt.Errorf("Relying party %q not found", settings.ID)
+		}
+		// Only the flags that were given change
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				rp.DisplayName = settings.DisplayName
+			case "icon":
+				rp.Icon = settings.Icon
+			case "origin":
+				rp.Origins = settings.Origins
+			case "related-origin":
+				rp.RelatedOrigins = settings.RelatedOrigins
+			case "attestation":
+				rp.Attestation = settings.Attestation
+			case "uv":
+				rp.UserVerification = settings.UserVerification
+			case "alg":
+				rp.Algorithms = settings.Algorithms
+			case "attachment":
+				rp.AuthenticatorAttachment = settings.AuthenticatorAttachment
+			case "counter-policy":
+				rp.CounterPolicy = settings.CounterPolicy
+			}
+		})
+		err = models.PutRelyingParty(&rp)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, res.FormatRelyingParty(rp))
+	case "delete":
+		err = models.DeleteRelyingParty(settings.ID)
+		if err == models.ErrNotFound {
+			return fmt.Errorf("Relying party %q not found", settings.ID)
+		}
+		return err
+	}
+	return fmt.Errorf("Unknown rp command %q\n%s", args[0], rpUsage)
+}
+
+// adminCommand - Grant or revoke the admin role from the command line. It's
+// the only way to get the first admin.
+func adminCommand(args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errors.New(adminUsage)
+	}
+	user, err := models.GetUserByUsername(args[1])
+	if err == models.ErrNotFound {
+		return fmt.Errorf("User %q not found", args[1])
+	}
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "grant":
+		// Only a user with an authenticator proved who they are, anyone can
+		// start registering for a user without one
+		creds, err := models.GetCredentialsForUser(&user)
+		if err != nil {
+			return err
+		}
+		if len(creds) == 0 {
+			return fmt.Errorf("User %q has no authenticators, they need to register one first", user.Name)
+		}
+		user.Role = models.RoleAdmin
+	case "revoke":
+		user.Role = ""
+	default:
+		return fmt.Errorf("Unknown admin command %q\n%s", args[0], adminUsage)
+	}
+
+	err = models.PutUser(&user)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, map[string]string{"name": user.Name, "role": user.Role})
+}
+
+// writeJSON - Write v as indented JSON
+func writeJSON(out io.Writer, v interface{}) error {
+	dj, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\n", dj)
+	return nil
+}
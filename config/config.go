@@ -6,6 +6,13 @@ import (
 	"io/ioutil"
 )
 
+// CookieKeyPair is a base64 encoded key to sign cookies with and one to
+// encrypt them with
+type CookieKeyPair struct {
+	HashKey  string `json:"hash_key"`
+	BlockKey string `json:"block_key"`
+}
+
 // Config represents the configuration information.
 type Config struct {
 	DBName         string `json:"db_name"`
@@ -14,6 +21,36 @@ type Config struct {
 	HostAddress    string `json:"host_address"`
 	HostPort       string `json:"host_port"`
 	HasProxy       bool   `json:"has_proxy"`
+	// PEM file with the roots SafetyNet attestation responses must chain to
+	SafetyNetRoots string `json:"safetynet_roots"`
+	// PEM file with the Apple WebAuthn root CA for Apple anonymous attestation
+	AppleRootCA string `json:"apple_root_ca"`
+	// Directory of <AAGUID or key identifier>.pem attestation trust anchors
+	TrustAnchorsDir string `json:"trust_anchors_dir"`
+	// Attestation trust anchors as AAGUID or key identifier to PEM file
+	TrustAnchors map[string]string `json:"trust_anchors"`
+	// Fail registration when the attestation can't be traced to a trust anchor,
+	// or the metadata service reports compromised keys for the authenticator
+	RequireTrustedAttestation bool `json:"require_trusted_attestation"`
+	// FIDO Metadata Service BLOB, loaded from disk at startup
+	MetadataBlob string `json:"metadata_blob"`
+	// PEM file with the root the metadata BLOB must be signed under
+	MetadataRoot string `json:"metadata_root"`
+	// COSE algorithms offered in pubKeyCredParams, most preferred first
+	CredentialAlgorithms []int64 `json:"credential_algorithms"`
+	// Milliseconds a ceremony challenge is valid, sent to the client as the timeout
+	ChallengeTimeout int `json:"challenge_timeout"`
+	// Seconds between deleting expired ceremony sessions
+	SessionSweepInterval int `json:"session_sweep_interval"`
+	// Seconds a user stays logged in after authenticating
+	LoginSessionLifetime int `json:"login_session_lifetime"`
+	// Keys for the session cookies, WEBAUTHN_COOKIE_HASH_KEY and
+	// WEBAUTHN_COOKIE_BLOCK_KEY take precedence
+	CookieKeys CookieKeyPair `json:"cookie_keys"`
+	// Where keys are generated and kept when none are configured
+	CookieKeysFile string `json:"cookie_keys_file"`
+	// Keys cookies may still be signed with, so rotating keys doesn't log everyone out
+	PreviousCookieKeys []CookieKeyPair `json:"previous_cookie_keys"`
 }
 
 // Conf contains the initialized configuration struct
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// The FIDO2 server API the FIDO Alliance conformance tools test against. It
+// runs the same ceremonies as /makeCredential and /assertion, only the
+// request and response shapes differ, so a conformance failure is a failure
+// of the core.
+
+// conformanceFailed - Answer with the failed envelope
+func conformanceFailed(w http.ResponseWriter, message string, status int) {
+	JSONResponse(w, res.ServerFailed(message), status)
+}
+
+// conformanceError - Answer with the failed envelope for an error, with the
+// status of a CeremonyError and what went wrong
+func conformanceError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if ce, ok := err.(*CeremonyError); ok {
+		status = ce.Status
+	}
+	conformanceFailed(w, err.Error(), status)
+}
+
+// decodeConformanceRequest - Decode the JSON body of a conformance request
+func decodeConformanceRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		conformanceFailed(w, fmt.Sprintf("Error decoding the request: %s", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// conformanceRelyingParty - The relying party of a conformance request
+func conformanceRelyingParty(w http.ResponseWriter, r *http.Request) (models.RelyingParty, bool) {
+	rp, err := ResolveRelyingParty(r)
+	if err != nil {
+		if _, ok := err.(*ResolveError); !ok {
+			fmt.Println("Error resolving the relying party:", err)
+			conformanceFailed(w, "Error getting the relying party", http.StatusInternalServerError)
+			return rp, false
+		}
+		conformanceFailed(w, err.Error(), http.StatusBadRequest)
+		return rp, false
+	}
+	return rp, true
+}
+
+// AttestationOptions - POST /attestation/options, start a registration
+func AttestationOptions(w http.ResponseWriter, r *http.Request) {
+	options := req.ServerPublicKeyCredentialCreationOptionsRequest{}
+	if !decodeConformanceRequest(w, r, &options) {
+		return
+	}
+	if options.Username == "" {
+		conformanceFailed(w, "Missing username", http.StatusBadRequest)
+		return
+	}
+
+	// requireResidentKey is what Level 1 clients send
+	selection := options.AuthenticatorSelection
+	if selection.ResidentKey == "" && selection.RequireResidentKey {
+		selection.ResidentKey = "required"
+	}
+	residentKey, err := ResidentKeyRequirement(selection.ResidentKey)
+	if err != nil {
+		conformanceFailed(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rp, ok := conformanceRelyingParty(w, r)
+	if !ok {
+		return
+	}
+	user, err := RegistrationUser(r, options.Username, options.DisplayName)
+	if err != nil {
+		conformanceError(w, err)
+		return
+	}
+
+	makeResponse, err := BeginRegistration(w, r, &user, &rp, RegistrationChoices{
+		Attestation:             options.Attestation,
+		ResidentKey:             residentKey,
+		UserVerification:        selection.UserVerification,
+		AuthenticatorAttachment: selection.AuthenticatorAttachment,
+	})
+	if err != nil {
+		conformanceError(w, err)
+		return
+	}
+
+	makeResponse.Extensions = options.Extensions
+	JSONResponse(w, res.ServerPublicKeyCredentialCreationOptionsResponse{
+		ServerResponse:         res.ServerOK,
+		MakeCredentialResponse: makeResponse,
+	}, http.StatusOK)
+}
+
+// AttestationResult - POST /attestation/result, finish a registration
+func AttestationResult(w http.ResponseWriter, r *http.Request) {
+	credential := req.RegistrationResponseJSON{}
+	if !decodeConformanceRequest(w, r, &credential) {
+		return
+	}
+
+	newCredential, verified, err := FinishRegistration(r, &credential)
+	if err != nil {
+		conformanceError(w, err)
+		return
+	}
+	if !verified {
+		conformanceFailed(w, "The attestation could not be verified", http.StatusBadRequest)
+		return
+	}
+
+	err = StartLoginSession(w, r, &newCredential.User, &newCredential)
+	if err != nil {
+		fmt.Println("Error starting login session:", err)
+		conformanceFailed(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, res.ServerOK, http.StatusOK)
+}
+
+// AssertionOptions - POST /assertion/options, start a login. Without a
+// username the authenticator picks a discoverable credential.
+func AssertionOptions(w http.ResponseWriter, r *http.Request) {
+	options := req.ServerPublicKeyCredentialGetOptionsRequest{}
+	if !decodeConformanceRequest(w, r, &options) {
+		return
+	}
+	switch options.UserVerification {
+	case "", "required", "preferred", "discouraged":
+	default:
+		conformanceFailed(w, "userVerification needs to be 'required', 'preferred' or 'discouraged'", http.StatusBadRequest)
+		return
+	}
+
+	rp, ok := conformanceRelyingParty(w, r)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if options.Username != "" {
+		var err error
+		user, err = models.GetUserByUsername(options.Username)
+		if err != nil {
+			conformanceFailed(w, "User does not exist", http.StatusBadRequest)
+			return
+		}
+		creds, err := models.GetCredentialsForUserAndRelyingParty(&user, &rp)
+		if err != nil || len(creds) == 0 {
+			conformanceFailed(w, "User has no credentials", http.StatusBadRequest)
+			return
+		}
+	}
+
+	assertionResponse, err := BeginAssertion(w, r, &user, &rp, options.UserVerification)
+	if err != nil {
+		conformanceError(w, err)
+		return
+	}
+
+	JSONResponse(w, res.ServerPublicKeyCredentialGetOptionsResponse{
+		ServerResponse:           res.ServerOK,
+		AssertionOptionsResponse: assertionResponse,
+		Extensions:               options.Extensions,
+	}, http.StatusOK)
+}
+
+// AssertionResult - POST /assertion/result, finish a login
+func AssertionResult(w http.ResponseWriter, r *http.Request) {
+	credential := req.AuthenticationResponseJSON{}
+	if !decodeConformanceRequest(w, r, &credential) {
+		return
+	}
+
+	user, cred, verified, err := FinishAssertion(r, &credential)
+	if err != nil {
+		conformanceError(w, err)
+		return
+	}
+	if !verified {
+		conformanceFailed(w, "The assertion signature could not be verified", http.StatusBadRequest)
+		return
+	}
+
+	err = StartLoginSession(w, r, &user, &cred)
+	if err != nil {
+		fmt.Println("Error starting login session:", err)
+		conformanceFailed(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, res.ServerOK, http.StatusOK)
+}
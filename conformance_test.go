@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+	res "git.jba.io/go/webauthn/response"
+)
+
+func (hs *HandlersSuite) TestConformanceCeremonies() {
+	enc := base64.RawURLEncoding.EncodeToString
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credID := []byte("conformance-credential")
+	cc := hs.newCeremonyClient()
+
+	creationOptions := map[string]interface{}{}
+	status := cc.send("POST", "/attestation/options", req.ServerPublicKeyCredentialCreationOptionsRequest{
+		Username:    "conformance@example.com",
+		DisplayName: "Conformance",
+		Attestation: "none",
+		AuthenticatorSelection: req.ServerAuthenticatorSelectionCriteria{
+			RequireResidentKey: false,
+			UserVerification:   "preferred",
+		},
+		Extensions: map[string]interface{}{"example.extension": true},
+	}, &creationOptions)
+	if status != http.StatusOK || creationOptions["status"] != "ok" || creationOptions["errorMessage"] != "" {
+		hs.T().Fatalf("Unexpected creation options %d %v", status, creationOptions)
+	}
+	extensions, _ := creationOptions["extensions"].(map[string]interface{})
+	if extensions["example.extension"] != true {
+		hs.T().Fatalf("Creation options don't echo the extensions: %v", creationOptions)
+	}
+	challenge, _ := creationOptions["challenge"].(string)
+	user, _ := creationOptions["user"].(map[string]interface{})
+	userID, _ := user["id"].(string)
+
+	attObj := encodeCBOR(map[string]interface{}{
+		"fmt":      "none",
+		"authData": makeAuthData("localhost", make([]byte, 16), credID, encodeEC2Key(&key.PublicKey, models.COSEAlgES256)),
+		"attStmt":  map[string]interface{}{},
+	})
+	result := res.ServerResponse{}
+	status = cc.send("POST", "/attestation/result", req.RegistrationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAttestationResponseJSON{
+			ClientDataJSON:    enc(hs.clientDataJSON("webauthn.create", challenge)),
+			AttestationObject: enc(attObj),
+		},
+	}, &result)
+	if status != http.StatusOK || result != res.ServerOK {
+		hs.T().Fatalf("Unexpected attestation result %d %#v", status, result)
+	}
+
+	assertionOptions := res.ServerPublicKeyCredentialGetOptionsResponse{}
+	status = cc.send("POST", "/assertion/options", req.ServerPublicKeyCredentialGetOptionsRequest{
+		Username:         "conformance@example.com",
+		UserVerification: "preferred",
+	}, &assertionOptions)
+	if status != http.StatusOK || assertionOptions.ServerResponse != res.ServerOK || len(assertionOptions.AllowList) != 1 {
+		hs.T().Fatalf("Unexpected assertion options %d %#v", status, assertionOptions)
+	}
+
+	authData := makeAssertionAuthData("localhost", 0x01, 2)
+	clientData := hs.clientDataJSON("webauthn.get", assertionOptions.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	result = res.ServerResponse{}
+	status = cc.send("POST", "/assertion/result", req.AuthenticationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAssertionResponseJSON{
+			ClientDataJSON:    enc(clientData),
+			AuthenticatorData: enc(authData),
+			Signature:         enc(sig),
+			UserHandle:        userID,
+		},
+	}, &result)
+	if status != http.StatusOK || result != res.ServerOK {
+		hs.T().Fatalf("Unexpected assertion result %d %#v", status, result)
+	}
+}
+
+func (hs *HandlersSuite) TestConformanceFailures() {
+	cc := hs.newCeremonyClient()
+
+	result := res.ServerResponse{}
+	status := cc.send("POST", "/attestation/options", req.ServerPublicKeyCredentialCreationOptionsRequest{}, &result)
+	if status != http.StatusBadRequest || result.Status != "failed" || result.ErrorMessage == "" {
+		hs.T().Fatalf("Unexpected result without a username %d %#v", status, result)
+	}
+
+	result = res.ServerResponse{}
+	status = cc.send("POST", "/assertion/options", req.ServerPublicKeyCredentialGetOptionsRequest{
+		Username: "nobody@example.com",
+	}, &result)
+	if status != http.StatusBadRequest || result.Status != "failed" || result.ErrorMessage == "" {
+		hs.T().Fatalf("Unexpected result for an unknown user %d %#v", status, result)
+	}
+
+	// A result without options first has no ceremony to finish
+	result = res.ServerResponse{}
+	status = cc.send("POST", "/assertion/result", req.AuthenticationResponseJSON{
+		ID:    "YWJj",
+		RawID: "YWJj",
+		Type:  "public-key",
+		Response: req.AuthenticatorAssertionResponseJSON{
+			ClientDataJSON:    "e30",
+			AuthenticatorData: "AA",
+			Signature:         "AA",
+		},
+	}, &result)
+	if status == http.StatusOK || result.Status != "failed" || result.ErrorMessage == "" {
+		hs.T().Fatalf("Unexpected result without a ceremony %d %#v", status, result)
+	}
+}
+
+func (hs *HandlersSuite) TestConformanceUserVerificationRequired() {
+	enc := base64.RawURLEncoding.EncodeToString
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credID := []byte("conformance-uv-credential")
+	cc := hs.newCeremonyClient()
+
+	// The authenticator data only has UP set
+	creationOptions := res.ServerPublicKeyCredentialCreationOptionsResponse{}
+	cc.send("POST", "/attestation/options", req.ServerPublicKeyCredentialCreationOptionsRequest{
+		Username:               "conformance-uv@example.com",
+		AuthenticatorSelection: req.ServerAuthenticatorSelectionCriteria{UserVerification: "required"},
+	}, &creationOptions)
+	if creationOptions.AuthenticatorSelection.UserVerification != "required" {
+		hs.T().Fatalf("Unexpected creation options %#v", creationOptions)
+	}
+	registration := req.RegistrationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAttestationResponseJSON{
+			ClientDataJSON: enc(hs.clientDataJSON("webauthn.create", creationOptions.Challenge)),
+			AttestationObject: enc(encodeCBOR(map[string]interface{}{
+				"fmt":      "none",
+				"authData": makeAuthData("localhost", make([]byte, 16), credID, encodeEC2Key(&key.PublicKey, models.COSEAlgES256)),
+				"attStmt":  map[string]interface{}{},
+			})),
+		},
+	}
+	result := res.ServerResponse{}
+	status := cc.send("POST", "/attestation/result", registration, &result)
+	if status == http.StatusOK || result.Status != "failed" || !strings.Contains(result.ErrorMessage, "User Verified") {
+		hs.T().Fatalf("Expected a registration without UV to fail. Got %d %#v", status, result)
+	}
+
+	// Registered without UV, the login asks for it
+	cc.send("POST", "/attestation/options", req.ServerPublicKeyCredentialCreationOptionsRequest{
+		Username: "conformance-uv@example.com",
+	}, &creationOptions)
+	registration.Response.ClientDataJSON = enc(hs.clientDataJSON("webauthn.create", creationOptions.Challenge))
+	status = cc.send("POST", "/attestation/result", registration, &result)
+	if status != http.StatusOK || result != res.ServerOK {
+		hs.T().Fatalf("Unexpected attestation result %d %#v", status, result)
+	}
+
+	assertionOptions := res.ServerPublicKeyCredentialGetOptionsResponse{}
+	cc.send("POST", "/assertion/options", req.ServerPublicKeyCredentialGetOptionsRequest{
+		Username:         "conformance-uv@example.com",
+		UserVerification: "required",
+	}, &assertionOptions)
+	if assertionOptions.UserVerification != "required" {
+		hs.T().Fatalf("Unexpected assertion options %#v", assertionOptions)
+	}
+	authData := makeAssertionAuthData("localhost", 0x01, 2)
+	clientData := hs.clientDataJSON("webauthn.get", assertionOptions.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	result = res.ServerResponse{}
+	status = cc.send("POST", "/assertion/result", req.AuthenticationResponseJSON{
+		ID:    enc(credID),
+		RawID: enc(credID),
+		Type:  "public-key",
+		Response: req.AuthenticatorAssertionResponseJSON{
+			ClientDataJSON:    enc(clientData),
+			AuthenticatorData: enc(authData),
+			Signature:         enc(sig),
+		},
+	}, &result)
+	if status == http.StatusOK || result.Status != "failed" || !strings.Contains(result.ErrorMessage, "User Verified") {
+		hs.T().Fatalf("Expected an assertion without UV to fail. Got %d %#v", status, result)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/sessions"
+
+	"git.jba.io/go/webauthn/config"
+)
+
+// DefaultCookieKeysFile is where generated cookie keys are kept when no file is configured
+const DefaultCookieKeysFile = "cookie_keys.json"
+
+// store keeps the registration, assertion and login cookies. It's set up
+// by SetupCookieStore.
+var store *sessions.CookieStore
+
+// ErrMissingCookieKey is returned when only one of the hash and block keys is configured
+var ErrMissingCookieKey = errors.New("Both a cookie hash key and block key need to be configured")
+
+// DecodeCookieKeys - Decode a pair of base64 cookie keys and check their sizes.
+// The hash key signs cookies and needs at least 32 bytes, the block key
+// encrypts them with AES so it has to be 16, 24 or 32 bytes.
+func DecodeCookieKeys(pair config.CookieKeyPair) ([]byte, []byte, error) {
+	hashKey, err := b64.StdEncoding.DecodeString(pair.HashKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error decoding the cookie hash key: %s", err)
+	}
+	blockKey, err := b64.StdEncoding.DecodeString(pair.BlockKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error decoding the cookie block key: %s", err)
+	}
+	if len(hashKey) < 32 {
+		return nil, nil, fmt.Errorf("The cookie hash key needs at least 32 bytes, got %d", len(hashKey))
+	}
+	switch len(blockKey) {
+	case 16, 24, 32:
+	default:
+		return nil, nil, fmt.Errorf("The cookie block key needs 16, 24 or 32 bytes, got %d", len(blockKey))
+	}
+	return hashKey, blockKey, nil
+}
+
+// GenerateCookieKeys - A new random 64 byte hash key and 32 byte block key
+func GenerateCookieKeys() (config.CookieKeyPair, error) {
+	hashKey := make([]byte, 64)
+	blockKey := make([]byte, 32)
+	_, err := rand.Read(hashKey)
+	if err == nil {
+		_, err = rand.Read(blockKey)
+	}
+	if err != nil {
+		return config.CookieKeyPair{}, err
+	}
+	return config.CookieKeyPair{
+		HashKey:  b64.StdEncoding.EncodeToString(hashKey),
+		BlockKey: b64.StdEncoding.EncodeToString(blockKey),
+	}, nil
+}
+
+// loadOrGenerateCookieKeys - Read the cookie keys from path, or generate them
+// and write them there when the file doesn't exist yet
+func loadOrGenerateCookieKeys(path string) (config.CookieKeyPair, error) {
+	pair := config.CookieKeyPair{}
+	contents, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(contents, &pair)
+		if err != nil {
+			return pair, fmt.Errorf("Error reading cookie keys from %s: %s", path, err)
+		}
+		return pair, nil
+	}
+	if !os.IsNotExist(err) {
+		return pair, err
+	}
+
+	log.Println("Generating cookie keys in", path)
+	pair, err = GenerateCookieKeys()
+	if err != nil {
+		return pair, err
+	}
+	contents, err = json.MarshalIndent(pair, "", "\t")
+	if err != nil {
+		return pair, err
+	}
+	// Fail if another process created the file in the meantime
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return pair, fmt.Errorf("Error saving cookie keys to %s: %s", path, err)
+	}
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return pair, fmt.Errorf("Error saving cookie keys to %s: %s", path, err)
+	}
+	return pair, nil
+}
+
+// CookieKeyPairs - The cookie keys from the environment, the config or the
+// keys file, followed by the previous keys. Cookies are signed and encrypted
+// with the first pair and accepted with any of them.
+func CookieKeyPairs() ([][]byte, error) {
+	current := config.Conf.CookieKeys
+	if hashKey := os.Getenv("WEBAUTHN_COOKIE_HASH_KEY"); hashKey != "" {
+		current.HashKey = hashKey
+	}
+	if blockKey := os.Getenv("WEBAUTHN_COOKIE_BLOCK_KEY"); blockKey != "" {
+		current.BlockKey = blockKey
+	}
+
+	if current.HashKey == "" && current.BlockKey == "" {
+		path := config.Conf.CookieKeysFile
+		if path == "" {
+			path = DefaultCookieKeysFile
+		}
+		var err error
+		current, err = loadOrGenerateCookieKeys(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if current.HashKey == "" || current.BlockKey == "" {
+		return nil, ErrMissingCookieKey
+	}
+
+	keyPairs := [][]byte{}
+	for x, pair := range append([]config.CookieKeyPair{current}, config.Conf.PreviousCookieKeys...) {
+		hashKey, blockKey, err := DecodeCookieKeys(pair)
+		if err != nil {
+			if x > 0 {
+				return nil, fmt.Errorf("Previous cookie keys %d: %s", x, err)
+			}
+			return nil, err
+		}
+		keyPairs = append(keyPairs, hashKey, blockKey)
+	}
+	return keyPairs, nil
+}
+
+// SetupCookieStore - Create the cookie store with the configured keys
+func SetupCookieStore() error {
+	keyPairs, err := CookieKeyPairs()
+	if err != nil {
+		return err
+	}
+	store = sessions.NewCookieStore(keyPairs...)
+	// Scripts never need to read the ceremony cookies either
+	store.Options.HttpOnly = true
+	store.Options.SameSite = http.SameSiteLaxMode
+	return nil
+}
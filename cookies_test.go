@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/sessions"
+
+	"git.jba.io/go/webauthn/config"
+)
+
+// withCookieConfig runs f with the cookie key settings given and puts the
+// previous ones back afterwards
+func withCookieConfig(t *testing.T, keys config.CookieKeyPair, previous []config.CookieKeyPair, f func(path string)) {
+	saved := config.Conf
+	defer func() { config.Conf = saved }()
+	config.Conf.CookieKeys = keys
+	config.Conf.PreviousCookieKeys = previous
+	config.Conf.CookieKeysFile = filepath.Join(t.TempDir(), "cookie_keys.json")
+	f(config.Conf.CookieKeysFile)
+}
+
+func TestCookieKeysGeneratedOnce(t *testing.T) {
+	withCookieConfig(t, config.CookieKeyPair{}, nil, func(path string) {
+		first, err := CookieKeyPairs()
+		if err != nil {
+			t.Fatalf("Unexpected error generating cookie keys: %s", err)
+		}
+		second, err := CookieKeyPairs()
+		if err != nil {
+			t.Fatalf("Unexpected error loading cookie keys from %s: %s", path, err)
+		}
+		if len(first) != 2 || string(first[0]) != string(second[0]) || string(first[1]) != string(second[1]) {
+			t.Fatalf("Cookie keys changed after they were saved")
+		}
+	})
+}
+
+func TestCookieKeysFromEnvironment(t *testing.T) {
+	keys, err := GenerateCookieKeys()
+	if err != nil {
+		t.Fatalf("Unexpected error generating cookie keys: %s", err)
+	}
+	t.Setenv("WEBAUTHN_COOKIE_HASH_KEY", keys.HashKey)
+	withCookieConfig(t, config.CookieKeyPair{}, nil, func(path string) {
+		// Only the hash key is set, so we don't guess the block key
+		_, err := CookieKeyPairs()
+		if err != ErrMissingCookieKey {
+			t.Fatalf("Unexpected error with a missing block key: %v", err)
+		}
+	})
+	t.Setenv("WEBAUTHN_COOKIE_BLOCK_KEY", keys.BlockKey)
+	withCookieConfig(t, config.CookieKeyPair{}, nil, func(path string) {
+		got, err := CookieKeyPairs()
+		if err != nil {
+			t.Fatalf("Unexpected error loading cookie keys from the environment: %s", err)
+		}
+		expected, _, _ := DecodeCookieKeys(keys)
+		if string(got[0]) != string(expected) {
+			t.Fatalf("Cookie hash key doesn't come from the environment")
+		}
+	})
+}
+
+func TestInvalidCookieKeys(t *testing.T) {
+	valid, err := GenerateCookieKeys()
+	if err != nil {
+		t.Fatalf("Unexpected error generating cookie keys: %s", err)
+	}
+	cases := map[string]config.CookieKeyPair{
+		"not base64":      {HashKey: "!", BlockKey: valid.BlockKey},
+		"short hash key":  {HashKey: "c2hvcnQ=", BlockKey: valid.BlockKey},
+		"wrong block key": {HashKey: valid.HashKey, BlockKey: "c2hvcnQ="},
+	}
+	for name, keys := range cases {
+		withCookieConfig(t, keys, nil, func(path string) {
+			_, err := CookieKeyPairs()
+			if err == nil {
+				t.Fatalf("Expected an error for %s", name)
+			}
+		})
+		withCookieConfig(t, valid, []config.CookieKeyPair{keys}, func(path string) {
+			_, err := CookieKeyPairs()
+			if err == nil {
+				t.Fatalf("Expected an error for previous keys with %s", name)
+			}
+		})
+	}
+}
+
+func TestCookieKeyRotation(t *testing.T) {
+	old, _ := GenerateCookieKeys()
+	current, _ := GenerateCookieKeys()
+
+	// A cookie from before the keys were rotated
+	oldPairs := [][]byte{}
+	withCookieConfig(t, old, nil, func(path string) {
+		var err error
+		oldPairs, err = CookieKeyPairs()
+		if err != nil {
+			t.Fatalf("Unexpected error loading cookie keys: %s", err)
+		}
+	})
+	oldStore := sessions.NewCookieStore(oldPairs...)
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	session, _ := oldStore.Get(r, loginCookie)
+	session.Values["token"] = "still-valid"
+	session.Save(r, w)
+	cookie := w.Result().Cookies()[0]
+
+	for name, previous := range map[string][]config.CookieKeyPair{"accepted": {old}, "rejected": nil} {
+		withCookieConfig(t, current, previous, func(path string) {
+			pairs, err := CookieKeyPairs()
+			if err != nil {
+				t.Fatalf("Unexpected error loading cookie keys: %s", err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+			session, err := sessions.NewCookieStore(pairs...).Get(r, loginCookie)
+			token, _ := session.Values["token"].(string)
+			if name == "accepted" && (err != nil || token != "still-valid") {
+				t.Fatalf("Cookie signed with the previous keys wasn't accepted: %v", err)
+			}
+			if name == "rejected" && (err == nil || token != "") {
+				t.Fatalf("Cookie signed with unknown keys was accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"crypto"
+
+	req "git.jba.io/go/webauthn/request"
+)
+
+// CredentialPublicKeyMatches reports whether the credential public key in
+// authData is the same key as pub, typically taken from a certificate
+func CredentialPublicKeyMatches(authData *req.DecodedAuthData, pub crypto.PublicKey) bool {
+	return authData.PubKey.Matches(pub)
+}
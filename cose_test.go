@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+
+	"git.jba.io/go/webauthn/config"
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// encodeOKPKey encodes an Ed25519 public key as a COSE_Key
+func encodeOKPKey(pub ed25519.PublicKey) []byte {
+	return encodeCBOR(map[int64]interface{}{
+		1:  models.COSEKeyTypeOKP,
+		3:  models.COSEAlgEdDSA,
+		-1: models.COSECurveEd25519,
+		-2: []byte(pub),
+	})
+}
+
+// encodeRSAKey encodes an RSA public key as a COSE_Key with the given alg
+func encodeRSAKey(pub *rsa.PublicKey, alg int64) []byte {
+	return encodeCBOR(map[int64]interface{}{
+		1:  models.COSEKeyTypeRSA,
+		3:  alg,
+		-1: pub.N.Bytes(),
+		-2: big.NewInt(int64(pub.E)).Bytes(),
+	})
+}
+
+// coseTestKey is a credential key pair for one of the supported algorithms
+type coseTestKey struct {
+	alg     int64
+	coseKey []byte
+	sign    func(data []byte) []byte
+	public  crypto.PublicKey
+}
+
+func makeCOSETestKeys() []coseTestKey {
+	var keys []coseTestKey
+	for _, ec := range []struct {
+		alg   int64
+		curve elliptic.Curve
+	}{
+		{models.COSEAlgES256, elliptic.P256()},
+		{models.COSEAlgES384, elliptic.P384()},
+		{models.COSEAlgES512, elliptic.P521()},
+	} {
+		key, _ := ecdsa.GenerateKey(ec.curve, rand.Reader)
+		hash := models.COSEHash(ec.alg)
+		keys = append(keys, coseTestKey{
+			alg:     ec.alg,
+			coseKey: encodeEC2Key(&key.PublicKey, ec.alg),
+			public:  key.Public(),
+			sign: func(data []byte) []byte {
+				h := hash.New()
+				h.Write(data)
+				sig, _ := ecdsa.SignASN1(rand.Reader, key, h.Sum(nil))
+				return sig
+			},
+		})
+	}
+
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys = append(keys, coseTestKey{
+		alg:     models.COSEAlgEdDSA,
+		coseKey: encodeOKPKey(edPub),
+		public:  edPub,
+		sign: func(data []byte) []byte {
+			return ed25519.Sign(edKey, data)
+		},
+	})
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, alg := range []int64{models.COSEAlgRS256, models.COSEAlgPS256, models.COSEAlgRS512, models.COSEAlgPS384} {
+		alg := alg
+		hash := models.COSEHash(alg)
+		keys = append(keys, coseTestKey{
+			alg:     alg,
+			coseKey: encodeRSAKey(&rsaKey.PublicKey, alg),
+			public:  rsaKey.Public(),
+			sign: func(data []byte) []byte {
+				h := hash.New()
+				h.Write(data)
+				var sig []byte
+				if alg == models.COSEAlgPS256 || alg == models.COSEAlgPS384 {
+					sig, _ = rsa.SignPSS(rand.Reader, rsaKey, hash, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+				} else {
+					sig, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, hash, h.Sum(nil))
+				}
+				return sig
+			},
+		})
+	}
+	return keys
+}
+
+func (as *AttestationSuite) TestCOSEKeyVerify() {
+	data := []byte("authenticator data and client data hash")
+	for _, key := range makeCOSETestKeys() {
+		pubKey, err := models.ParseCOSEKey(key.coseKey)
+		if err != nil {
+			as.T().Fatalf("Unexpected error parsing COSE key for alg %d: %s", key.alg, err)
+		}
+		if pubKey.Type != key.alg {
+			as.T().Fatalf("Unexpected alg %d, expected %d", pubKey.Type, key.alg)
+		}
+		if !pubKey.Matches(key.public) {
+			as.T().Fatalf("Expected the parsed key to match for alg %d", key.alg)
+		}
+
+		valid, err := pubKey.Verify(key.sign(data), data)
+		if err != nil || !valid {
+			as.T().Fatalf("Expected a valid signature for alg %d. Got: %v, %s", key.alg, valid, err)
+		}
+		valid, _ = pubKey.Verify(key.sign(data), []byte("other data"))
+		if valid {
+			as.T().Fatalf("Signature verified over the wrong data for alg %d", key.alg)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestFormatCredentialsKeyTypes() {
+	for _, key := range makeCOSETestKeys() {
+		pubKey, _ := models.ParseCOSEKey(key.coseKey)
+		fcs, err := res.FormatCredentials([]models.Credential{{PublicKey: pubKey}})
+		if err != nil {
+			as.T().Fatalf("Unexpected error formatting the credential for alg %d: %s", key.alg, err)
+		}
+		if fcs[0].PubKeyType != models.COSEKeyTypeName(pubKey.KeyType) || fcs[0].PubKeyAlg != models.COSEAlgorithmName(key.alg) {
+			as.T().Fatalf("Unexpected key type and algorithm for alg %d: %s %s", key.alg, fcs[0].PubKeyType, fcs[0].PubKeyAlg)
+		}
+		spki, _ := base64.StdEncoding.DecodeString(fcs[0].PubKey)
+		pub, err := x509.ParsePKIXPublicKey(spki)
+		if err != nil || !pubKey.Matches(pub) {
+			as.T().Fatalf("Expected the formatted public key to match for alg %d. Got: %v", key.alg, err)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestCOSEKeyUnsupported() {
+	_, err := models.ParseCOSEKey(encodeCBOR(map[int64]interface{}{1: 4, 3: models.COSEAlgES256}))
+	if err == nil {
+		as.T().Fatalf("Expected an error for a symmetric COSE key")
+	}
+
+	// An EC2 key can't be used with an RSA algorithm
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pubKey, err := models.ParseCOSEKey(encodeEC2Key(&key.PublicKey, models.COSEAlgRS256))
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing COSE key: %s", err)
+	}
+	_, err = pubKey.Verify([]byte("sig"), []byte("data"))
+	if err == nil {
+		as.T().Fatalf("Expected an error for an EC2 key with alg RS256")
+	}
+
+	// ES256, ES384 and ES512 are bound to their own curves
+	p521Key, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, err = models.ParseCOSEKey(encodeEC2Key(&p521Key.PublicKey, models.COSEAlgES256))
+	if err == nil {
+		as.T().Fatalf("Expected an error for ES256 with a P-521 key")
+	}
+	_, err = models.ParseCOSEKey(encodeEC2Key(&key.PublicKey, models.COSEAlgES512))
+	if err == nil {
+		as.T().Fatalf("Expected an error for ES512 with a P-256 key")
+	}
+	pubKey = models.PublicKey{
+		KeyType: models.COSEKeyTypeEC2,
+		Type:    models.COSEAlgES512,
+		Curve:   models.COSECurveP256,
+		XCoord:  key.X.FillBytes(make([]byte, 32)),
+		YCoord:  key.Y.FillBytes(make([]byte, 32)),
+	}
+	_, err = pubKey.Verify([]byte("sig"), []byte("data"))
+	if err == nil {
+		as.T().Fatalf("Expected an error verifying ES512 with a P-256 key")
+	}
+
+	// RS1 is only for TPM attestation statements, not credentials
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	data := []byte("data")
+	digest := sha1.Sum(data)
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA1, digest[:])
+	pubKey, err = models.ParseCOSEKey(encodeRSAKey(&rsaKey.PublicKey, models.COSEAlgRS1))
+	if err != nil {
+		as.T().Fatalf("Unexpected error parsing COSE key: %s", err)
+	}
+	valid, err := pubKey.Verify(sig, data)
+	if err == nil || valid {
+		as.T().Fatalf("Expected an error for an RS1 credential key. Got: %v", valid)
+	}
+}
+
+func (as *AttestationSuite) TestPackedSelfAttestationAlgorithms() {
+	for _, key := range makeCOSETestKeys() {
+		authData := makeAuthData("localhost", testAAGUID, testCredID, key.coseKey)
+		decoded := as.parseTestAuthData("packed", authData, req.EncodedAttestationStatement{
+			Algorithm: key.alg,
+			Signature: key.sign(append(append([]byte{}, authData...), testClientDataHash[:]...)),
+		})
+		valid, err := VerifyPackedAttestation(&decoded, testClientDataHash[:])
+		if err != nil || !valid {
+			as.T().Fatalf("Expected valid self attestation for alg %d. Got: %v, %s", key.alg, valid, err)
+		}
+	}
+}
+
+func (as *AttestationSuite) TestCredentialParameters() {
+	rp := models.RelyingParty{}
+	params := CredentialParameters(&rp)
+	if len(params) != len(models.SupportedAlgorithms) || params[0].Algorithm != models.COSEAlgES256 {
+		as.T().Fatalf("Unexpected default credential parameters %+v", params)
+	}
+
+	// The configured order is kept and unsupported algorithms are left out
+	config.Conf.CredentialAlgorithms = []int64{models.COSEAlgRS256, 12345, models.COSEAlgES256}
+	defer func() { config.Conf.CredentialAlgorithms = nil }()
+	params = CredentialParameters(&rp)
+	if len(params) != 2 || params[0].Algorithm != models.COSEAlgRS256 || params[1].Algorithm != models.COSEAlgES256 {
+		as.T().Fatalf("Unexpected configured credential parameters %+v", params)
+	}
+
+	// The algorithms of the relying party come before the configured ones
+	rp.Algorithms = []int64{models.COSEAlgEdDSA}
+	params = CredentialParameters(&rp)
+	if len(params) != 1 || params[0].Algorithm != models.COSEAlgEdDSA {
+		as.T().Fatalf("Unexpected relying party credential parameters %+v", params)
+	}
+}
@@ -0,0 +1,46 @@
+-- Users, relying parties, credentials and WebAuthn ceremony sessions
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	-- Random WebAuthn user handle
+	handle BLOB UNIQUE,
+	name TEXT NOT NULL,
+	display_name TEXT NOT NULL DEFAULT '',
+	icon TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX users_name ON users (name);
+
+CREATE TABLE relying_parties (
+	id TEXT PRIMARY KEY,
+	display_name TEXT NOT NULL DEFAULT '',
+	icon TEXT NOT NULL DEFAULT '',
+	-- reject, suspect or allow, empty means reject
+	counter_policy TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE credentials (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	cred_id TEXT NOT NULL UNIQUE,
+	user_id INTEGER NOT NULL,
+	rp_id TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	sign_count INTEGER NOT NULL DEFAULT 0,
+	suspect BOOLEAN NOT NULL DEFAULT 0,
+	type TEXT NOT NULL DEFAULT '',
+	format TEXT NOT NULL DEFAULT '',
+	flags BLOB,
+	attestation_trust TEXT NOT NULL DEFAULT '',
+	-- JSON encoded models.PublicKey
+	public_key TEXT NOT NULL
+);
+CREATE INDEX credentials_user_rp ON credentials (user_id, rp_id);
+
+CREATE TABLE sessions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	challenge BLOB NOT NULL,
+	origin TEXT NOT NULL DEFAULT '',
+	session_type TEXT NOT NULL,
+	user_id INTEGER NOT NULL DEFAULT 0,
+	rp_id TEXT NOT NULL
+);
+CREATE INDEX sessions_user_rp ON sessions (user_id, rp_id);
@@ -0,0 +1,6 @@
+-- Challenges expire with the timeout sent to the client. Sessions created
+-- before this have no expiry and are deleted by the sweeper.
+
+ALTER TABLE sessions ADD COLUMN issued_at DATETIME;
+ALTER TABLE sessions ADD COLUMN expires_at DATETIME;
+CREATE INDEX sessions_expires_at ON sessions (expires_at);
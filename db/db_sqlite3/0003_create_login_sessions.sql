@@ -0,0 +1,15 @@
+-- Logged in users, the token is kept in a signed cookie
+
+CREATE TABLE login_sessions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	token TEXT NOT NULL UNIQUE,
+	user_id INTEGER NOT NULL,
+	-- The credential the user authenticated with
+	credential_id INTEGER NOT NULL,
+	cred_id TEXT NOT NULL,
+	rp_id TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+CREATE INDEX login_sessions_user ON login_sessions (user_id);
+CREATE INDEX login_sessions_expires_at ON login_sessions (expires_at);
@@ -0,0 +1,14 @@
+-- Admins can manage other users, denied requests are kept in the audit log
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at DATETIME NOT NULL,
+	-- 0 when nobody was logged in
+	user_id INTEGER NOT NULL DEFAULT 0,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '',
+	remote_addr TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,7 @@
+-- Per relying party ceremony settings, lists are JSON arrays
+
+ALTER TABLE relying_parties ADD COLUMN origins TEXT NOT NULL DEFAULT 'null';
+ALTER TABLE relying_parties ADD COLUMN attestation TEXT NOT NULL DEFAULT '';
+ALTER TABLE relying_parties ADD COLUMN user_verification TEXT NOT NULL DEFAULT '';
+ALTER TABLE relying_parties ADD COLUMN algorithms TEXT NOT NULL DEFAULT 'null';
+ALTER TABLE relying_parties ADD COLUMN authenticator_attachment TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Origins on other domains that may use a relying party ID, a JSON array
+
+ALTER TABLE relying_parties ADD COLUMN related_origins TEXT NOT NULL DEFAULT 'null';
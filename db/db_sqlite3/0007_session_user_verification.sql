@@ -0,0 +1,4 @@
+-- The user verification requirement sent to the client, so it's enforced
+-- when the ceremony finishes
+
+ALTER TABLE sessions ADD COLUMN user_verification TEXT NOT NULL DEFAULT '';
@@ -3,13 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"git.jba.io/go/webauthn/config"
 	"git.jba.io/go/webauthn/models"
+	res "git.jba.io/go/webauthn/response"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -20,10 +21,14 @@ type HandlersSuite struct {
 var server = httptest.NewUnstartedServer(CreateRouter())
 
 func (hs *HandlersSuite) SetupSuite() {
-	config.Conf.DBName = "boltdb"
-	config.Conf.DBPath = ":memory:"
+	config.Conf.DBName = "memory"
 	config.Conf.HostAddress = "localhost"
-	err := models.Setup()
+	config.Conf.CookieKeysFile = filepath.Join(hs.T().TempDir(), "cookie_keys.json")
+	err := SetupCookieStore()
+	if err != nil {
+		hs.T().Fatalf("Failed setting up cookie keys: %v", err)
+	}
+	err = models.Setup()
 	if err != nil {
 		hs.T().Fatalf("Failed creating database: %v", err)
 	}
@@ -47,25 +52,42 @@ func (hs *HandlersSuite) TearDownTest() {
 }
 
 func (hs *HandlersSuite) TestGetUser() {
-	expected, _ := models.GetUserByUsername("rcrumb@duo.com")
+	cookie := hs.login("getuser@example.com")
+	expected, _ := models.GetUserByUsername("getuser@example.com")
 
-	resp, err := http.Get(fmt.Sprintf("%s/user/%s", server.URL, expected.Name))
-	if err != nil {
-		hs.T().Fatalf("Unexpected error fetching user details: %s", err)
-	}
+	resp := hs.do("GET", fmt.Sprintf("/user/%s", expected.Name), cookie)
 	defer resp.Body.Close()
 
-	got := models.User{}
-	err = json.NewDecoder(resp.Body).Decode(&got)
+	got := res.UserResponse{}
+	err := json.NewDecoder(resp.Body).Decode(&got)
 	if err != nil {
 		hs.T().Fatalf("Unexpected error when unmarshaling user response body: %s\nGot response %#v", err, got)
 	}
 
-	if reflect.DeepEqual(expected, got) {
+	if !reflect.DeepEqual(res.FormatUser(expected), got) {
 		hs.T().Fatalf("Invalid user object received from getUser. Expected %#v\nGot%#v", expected, got)
 	}
 }
 
+func TestResidentKeyRequirement(t *testing.T) {
+	cases := map[string]string{
+		"":            "discouraged",
+		"discouraged": "discouraged",
+		"preferred":   "preferred",
+		"required":    "required",
+	}
+	for given, expected := range cases {
+		got, err := ResidentKeyRequirement(given)
+		if err != nil || got != expected {
+			t.Fatalf("Unexpected residentKey for %q. Expected: %s, Got: %s (%v)", given, expected, got, err)
+		}
+	}
+	_, err := ResidentKeyRequirement("always")
+	if err == nil {
+		t.Fatalf("Expected an error for an invalid residentKey")
+	}
+}
+
 func TestHandlersSuite(t *testing.T) {
 	suite.Run(t, new(HandlersSuite))
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
@@ -16,21 +17,20 @@ import (
 	"log"
 	"math/big"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 	"github.com/ugorji/go/codec"
 
 	"git.jba.io/go/webauthn/config"
 	"git.jba.io/go/webauthn/models"
 	req "git.jba.io/go/webauthn/request"
 	res "git.jba.io/go/webauthn/response"
+	"git.jba.io/go/webauthn/trust"
 )
 
-var store = sessions.NewCookieStore([]byte("duo-rox"))
-
 // renderTemplate renders the template to the ResponseWriter
 func renderTemplate(w http.ResponseWriter, f string, data interface{}) {
 	t, err := template.ParseFiles(fmt.Sprintf("./templates/%s", f))
@@ -53,36 +53,42 @@ func JSONResponse(w http.ResponseWriter, d interface{}, c int) {
 	fmt.Fprintf(w, "%s", dj)
 }
 
-// Index returns the static index template
+// Index returns the dashboard of the logged in user
 func Index(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	username := vars["name"]
-
-	if username == "" {
-		fmt.Println("Getting default user for dashboard")
-		username = "testuser@example.com"
-	}
-
-	user, err := models.GetUserByUsername(username + "@example.com")
-
-	if err != nil {
-		fmt.Println("Error retreiving user for dashboard: ", err)
-		JSONResponse(w, "Error retreiving user", http.StatusInternalServerError)
-		return
-	}
+	auth := AuthenticatedUser(r)
+	user := auth.User
 
 	type TemplateData struct {
 		User        string
 		Credentials []res.FormattedCredential
+		Sessions    []res.LoginSessionResponse
 	}
 
 	creds, err := models.GetCredentialsForUser(&user)
+	if err != nil {
+		fmt.Println("Error retreiving credentials for dashboard: ", err)
+		JSONResponse(w, "Error retreiving credentials", http.StatusInternalServerError)
+		return
+	}
 
 	fcs, err := res.FormatCredentials(creds)
+	if err != nil {
+		fmt.Println("Error formatting credentials for dashboard: ", err)
+		JSONResponse(w, "Error formatting credentials", http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := models.GetLoginSessionsForUser(&user)
+	if err != nil {
+		fmt.Println("Error retreiving sessions for dashboard: ", err)
+		JSONResponse(w, "Error retreiving sessions", http.StatusInternalServerError)
+		return
+	}
 
 	td := TemplateData{
 		User:        user.DisplayName,
 		Credentials: fcs,
+		Sessions:    res.FormatLoginSessions(sessions, auth.Session.ID),
 	}
 
 	renderTemplate(w, "index.html", td)
@@ -93,61 +99,188 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, "login.html", nil)
 }
 
+// CredentialParameters - The pubKeyCredParams we offer, every supported
+// algorithm in the order set for the relying party or configured with
+// credential_algorithms
+func CredentialParameters(rp *models.RelyingParty) []res.CredentialParameter {
+	algs := rp.Algorithms
+	if len(algs) == 0 {
+		algs = config.Conf.CredentialAlgorithms
+	}
+	if len(algs) == 0 {
+		algs = models.SupportedAlgorithms
+	}
+
+	var params []res.CredentialParameter
+	for _, alg := range algs {
+		if !models.SupportedAlgorithm(alg) {
+			log.Println("Skipping unsupported credential algorithm", alg)
+			continue
+		}
+		params = append(params, res.CredentialParameter{
+			Type:      "public-key",
+			Algorithm: alg,
+		})
+	}
+	return params
+}
+
+// OfferedAlgorithm - Whether alg is in the CredentialParameters of rp
+func OfferedAlgorithm(rp *models.RelyingParty, alg int64) bool {
+	for _, param := range CredentialParameters(rp) {
+		if param.Algorithm == alg {
+			return true
+		}
+	}
+	return false
+}
+
 // RequestNewCredential begins Credential Registration Request when /MakeNewCredential gets hit
 func RequestNewCredential(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["name"]
 
-	attType := r.FormValue("attType")
-	timeout := 60000
+	residentKey, err := ResidentKeyRequirement(r.FormValue("residentKey"))
+	if err != nil {
+		JSONResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get Relying Party that is requesting Registration
+	rp, ok := RelyingPartyForRequest(w, r)
+	if !ok {
+		return
+	}
+
 	// Get Registrant User
+	user, err := RegistrationUser(r, username, "")
+	if err != nil {
+		CeremonyErrorResponse(w, err)
+		return
+	}
 
-	user, err := models.GetUserByUsername(username)
+	makeResponse, err := BeginRegistration(w, r, &user, &rp, RegistrationChoices{
+		Attestation: r.FormValue("attType"),
+		ResidentKey: residentKey,
+	})
 	if err != nil {
-		user = models.User{
-			DisplayName: strings.Split(username, "@")[0],
-			Name:        username,
-		}
-		err = models.PutUser(&user)
-		if err != nil {
-			JSONResponse(w, "Error creating new user", http.StatusInternalServerError)
-			return
-		}
+		CeremonyErrorResponse(w, err)
+		return
 	}
 
-	params := []res.CredentialParameter{
-		res.CredentialParameter{
-			Type:      "public-key",
-			Algorithm: "-7",
-		},
+	JSONResponse(w, makeResponse, http.StatusOK)
+}
+
+// CeremonyError is a ceremony failing before its data could be verified,
+// with the status and message the client gets
+type CeremonyError struct {
+	Status  int
+	Message string
+	// Err is what went wrong, it's only logged
+	Err error
+}
+
+func (e *CeremonyError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
 	}
+	return e.Message
+}
 
-	// Get the proper URL the request is coming from
-	u, err := url.Parse(r.Referer())
+// CeremonyErrorResponse - Answer with the status and message of a
+// CeremonyError, any other error is a 500
+func CeremonyErrorResponse(w http.ResponseWriter, err error) {
+	if ce, ok := err.(*CeremonyError); ok {
+		JSONResponse(w, ce.Message, ce.Status)
+		return
+	}
+	JSONResponse(w, "Internal server error", http.StatusInternalServerError)
+}
 
-	// Get Relying Party that is requesting Registration
-	rp, err := models.GetRelyingPartyByHost(u.Hostname())
+// RegistrationUser - The user a credential is registered for. A user that
+// doesn't exist yet is only stored by BeginRegistration. Once a user has an
+// authenticator or a role, only they or an admin can add another.
+func RegistrationUser(r *http.Request, username string, displayName string) (models.User, error) {
+	user, err := models.GetUserByUsername(username)
+	if err == models.ErrNotFound {
+		if displayName == "" {
+			displayName = strings.Split(username, "@")[0]
+		}
+		return models.User{
+			DisplayName: displayName,
+			Name:        username,
+		}, nil
+	}
 	if err != nil {
-		log.Println("No RP found for host", err)
-		JSONResponse(w, "No relying party defined", http.StatusInternalServerError)
-		return
+		fmt.Println("Error getting user:", err)
+		return user, &CeremonyError{http.StatusInternalServerError, "Error getting user", err}
 	}
 
-	/*
-		if err == gorm.ErrRecordNotFound {
-			fmt.Println("No RP found for host ", u.Hostname())
-			fmt.Printf("Request: %+v\n", r)
-			JSONResponse(w, "No relying party defined", http.StatusInternalServerError)
-			return
+	registered, err := models.GetCredentialsForUser(&user)
+	if err != nil {
+		fmt.Println("Error getting existing credentials:", err)
+		return user, &CeremonyError{http.StatusInternalServerError, "Error getting existing credentials", err}
+	}
+	if len(registered) > 0 || user.Role != "" {
+		auth, _ := CurrentLogin(r)
+		if !CanManage(auth, &user) {
+			AuditDenied(r, auth, "credential.create", user.Name, "Adding an authenticator to a user needs their login")
+			return user, &CeremonyError{http.StatusForbidden, "You are not allowed to do that", nil}
 		}
-	*/
+	}
+	return user, nil
+}
+
+// RegistrationChoices are what the client asked for in a new credential. The
+// settings of the relying party win over them.
+type RegistrationChoices struct {
+	Attestation             string
+	ResidentKey             string
+	UserVerification        string
+	AuthenticatorAttachment string
+}
+
+// BeginRegistration - Start registering a credential for user with rp,
+// storing the user first when they're new. The session is kept in the
+// registration cookie and the options for navigator.credentials.create() are
+// returned.
+func BeginRegistration(w http.ResponseWriter, r *http.Request, user *models.User, rp *models.RelyingParty, choices RegistrationChoices) (res.MakeCredentialResponse, error) {
+	timeout := ChallengeTimeout()
+	params := CredentialParameters(rp)
+
+	// The RP's attestation preference wins over the one picked on the login page
+	attType := rp.Attestation
+	if attType == "" {
+		attType = choices.Attestation
+	}
+	attachment := rp.AuthenticatorAttachment
+	if attachment == "" {
+		attachment = choices.AuthenticatorAttachment
+	}
+	userVerification := rp.UserVerification
+	if userVerification == "" {
+		userVerification = choices.UserVerification
+	}
+	if userVerification == "" {
+		userVerification = UserVerificationRequirement(rp)
+	}
+
+	// New users are only stored once their registration starts
+	if user.ID == 0 {
+		err := models.PutUser(user)
+		if err == models.ErrUsernameTaken {
+			return res.MakeCredentialResponse{}, &CeremonyError{http.StatusConflict, "Username already taken", err}
+		}
+		if err != nil {
+			return res.MakeCredentialResponse{}, &CeremonyError{http.StatusInternalServerError, "Error creating new user", err}
+		}
+	}
 
 	// Log this Registration session
-	sd, err := models.CreateNewSession(&user, &rp, "reg")
+	sd, err := models.CreateNewSession(user, rp, "reg", userVerification, timeout)
 	if err != nil {
 		fmt.Println("Something went wrong creating session data:", err)
-		JSONResponse(w, "Session Data Creation Error", http.StatusInternalServerError)
-		return
+		return res.MakeCredentialResponse{}, &CeremonyError{http.StatusInternalServerError, "Session Data Creation Error", err}
 	}
 
 	// Give us a safe (looking) way to manage the session btwn us and the client
@@ -163,187 +296,280 @@ func RequestNewCredential(w http.ResponseWriter, r *http.Request) {
 	makeOptUser := res.MakeOptionUser{
 		Name:        user.Name,
 		DisplayName: user.DisplayName,
-		ID:          user.ID,
+		ID:          res.EncodeBase64URL(user.Handle),
 	}
 
 	authSelector := res.AuthenticatorSelection{
-		AuthenticatorAttachment: "cross-platform",
-		RequireResidentKey:      false,
-		UserVerification:        "preferred",
+		AuthenticatorAttachment: attachment,
+		ResidentKey:             choices.ResidentKey,
+		RequireResidentKey:      choices.ResidentKey == "required",
+		UserVerification:        userVerification,
 	}
 
-	makeResponse := res.MakeCredentialResponse{
-		Challenge:              sd.Challenge,
+	// Don't let the user register an authenticator they already registered
+	existing, err := models.GetCredentialsForUserAndRelyingParty(user, rp)
+	if err != nil {
+		fmt.Println("Error getting existing credentials:", err)
+		return res.MakeCredentialResponse{}, &CeremonyError{http.StatusInternalServerError, "Error getting existing credentials", err}
+	}
+
+	return res.MakeCredentialResponse{
+		Challenge:              res.EncodeBase64URL(sd.Challenge),
 		RP:                     makeOptRP,
 		User:                   makeOptUser,
 		Parameters:             params,
-		Timeout:                timeout,
+		Timeout:                int(timeout / time.Millisecond),
 		AuthenticatorSelection: authSelector,
+		ExcludeList:            res.FormatCredentialDescriptors(existing),
 		AttestationType:        attType,
-		Extensions:             res.Extensions{true},
-	}
-
-	JSONResponse(w, makeResponse, http.StatusOK)
+	}, nil
 }
 
-// GetUserAndRelyingParty - Get the relevant user and rp for a given WebAuthn ceremony
-func GetUserAndRelyingParty(username string, hostname string) (models.User, models.RelyingParty, error) {
-	// Get Registering User
-	user, err := models.GetUserByUsername(username)
-	if err != nil {
-		log.Println("GetUserAndRelyingParty/GetUserByUsername Error:", err)
-		return models.User{}, models.RelyingParty{}, err
+// UserVerificationRequirement - The user verification a relying party asks
+// for, preferred unless it's set
+func UserVerificationRequirement(rp *models.RelyingParty) string {
+	if rp.UserVerification == "" {
+		return "preferred"
 	}
+	return rp.UserVerification
+}
 
-	/*
-		if err == gorm.ErrRecordNotFound {
-			fmt.Println("No user record found with username ", username)
-			err = errors.New("No User found")
-			return user, models.RelyingParty{}, err
-		}
-	*/
+// ChallengeTimeout - How long the challenge of a ceremony can be used, this is
+// also the timeout we send to the client
+func ChallengeTimeout() time.Duration {
+	if config.Conf.ChallengeTimeout <= 0 {
+		return models.DefaultChallengeTimeout
+	}
+	return time.Duration(config.Conf.ChallengeTimeout) * time.Millisecond
+}
 
-	// Get Relying Party that is requesting Registration
-	rp, err := models.GetRelyingPartyByHost(hostname)
+// ConsumeSessionForRequest - Get the ceremony session saved in the named cookie
+// and delete it, so its challenge can't be used again
+func ConsumeSessionForRequest(r *http.Request, name string, st string) (models.SessionData, error) {
+	session, err := store.Get(r, name)
 	if err != nil {
-		log.Println("GetUserAndRelyingParty/GetRelyingPartyByHost Error:", err)
-		return models.User{}, models.RelyingParty{}, err
+		return models.SessionData{}, err
 	}
+	sessionID, ok := session.Values["session_id"].(int64)
+	if !ok {
+		return models.SessionData{}, errors.New("Missing Session Data Cookie")
+	}
+	log.Println("SessionID:", sessionID)
+	return models.ConsumeSessionData(sessionID, st)
+}
 
-	/*
-		if err == gorm.ErrRecordNotFound {
-			err = errors.New("No RP found")
-			return user, rp, err
-		}
-	*/
-
-	return user, rp, nil
+// ResidentKeyRequirement - Whether registration asks for a discoverable
+// credential. Defaults to discouraged like the spec.
+func ResidentKeyRequirement(residentKey string) (string, error) {
+	switch residentKey {
+	case "":
+		return "discouraged", nil
+	case "discouraged", "preferred", "required":
+		return residentKey, nil
+	}
+	return "", errors.New("residentKey needs to be 'discouraged', 'preferred' or 'required'")
 }
 
 // GetAssertion - assemble the data we need to make an assertion against
-// a given user and authenticator
+// a given user and authenticator. Without a username this starts a
+// usernameless login, the authenticator picks one of its discoverable
+// credentials and tells us who the user is with the userHandle.
 func GetAssertion(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["name"]
-	timeout := 60000
 
-	u, err := url.Parse(r.Referer())
+	rp, ok := RelyingPartyForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	var err error
+	if username != "" {
+		user, err = models.GetUserByUsername(username)
+		if err != nil {
+			fmt.Println("Couldn't Find the User:", err)
+			JSONResponse(w, "Couldn't Find User", http.StatusInternalServerError)
+			return
+		}
+	}
 
-	user, rp, err := GetUserAndRelyingParty(username, u.Hostname())
+	assertionResponse, err := BeginAssertion(w, r, &user, &rp, "")
 	if err != nil {
-		fmt.Println("Couldn't Find the User or RP, most likely the User:", err)
-		JSONResponse(w, "Couldn't Find User", http.StatusInternalServerError)
+		CeremonyErrorResponse(w, err)
 		return
 	}
 
-	sd, err := models.CreateNewSession(&user, &rp, "att")
+	JSONResponse(w, assertionResponse, http.StatusOK)
+}
+
+// BeginAssertion - Start a login of user with rp, or a usernameless login
+// when the user is empty. The session is kept in the assertion cookie and the
+// options for navigator.credentials.get() are returned.
+func BeginAssertion(w http.ResponseWriter, r *http.Request, user *models.User, rp *models.RelyingParty, userVerification string) (res.AssertionOptionsResponse, error) {
+	timeout := ChallengeTimeout()
+	if rp.UserVerification != "" || userVerification == "" {
+		userVerification = UserVerificationRequirement(rp)
+	}
+
+	sd, err := models.CreateNewSession(user, rp, "att", userVerification, timeout)
 	if err != nil {
 		fmt.Println("Something went wrong creating session data:", err)
-		JSONResponse(w, "Session Data Creation Error", http.StatusInternalServerError)
-		return
+		return res.AssertionOptionsResponse{}, &CeremonyError{http.StatusInternalServerError, "Session Data Creation Error", err}
 	}
 
-	creds, err := models.GetCredentialsForUserAndRelyingParty(&user, &rp)
-	if err != nil {
-		fmt.Println("No Credential Record Found:", err)
-		JSONResponse(w, "Session Data Creation Error", http.StatusNotFound)
-		return
+	creds := []models.Credential{}
+	if user.ID != 0 {
+		creds, err = models.GetCredentialsForUserAndRelyingParty(user, rp)
+		if err != nil {
+			fmt.Println("No Credential Record Found:", err)
+			return res.AssertionOptionsResponse{}, &CeremonyError{http.StatusNotFound, "Session Data Creation Error", err}
+		}
 	}
 
 	session, _ := store.Get(r, "assertion-session")
 	session.Values["session_id"] = sd.ID
 	session.Save(r, w)
 
-	type AllowedCredential struct {
-		CredID     string   `json:"id"`
-		Type       string   `json:"type"`
-		Transports []string `json:"transports"`
-	}
+	// An empty list lets the authenticator choose a discoverable credential
+	return res.AssertionOptionsResponse{
+		Challenge:        res.EncodeBase64URL(sd.Challenge),
+		Timeout:          int(timeout / time.Millisecond),
+		AllowList:        res.FormatCredentialDescriptors(creds),
+		RPID:             rp.ID,
+		UserVerification: userVerification,
+	}, nil
+}
 
-	type PublicKeyCredentialOptions struct {
-		Challenge []byte              `json:"challenge,omitempty"`
-		Timeout   int                 `json:"timeout,omitempty"`
-		AllowList []AllowedCredential `json:"allowCredentials,omitempty"`
-		RPID      string              `json:"rpId,omitempty"`
+// MakeAssertion - Validate the Assertion Data provided by the authenticator and
+// resond whether or not it was successful alongside the relevant credential.
+func MakeAssertion(w http.ResponseWriter, r *http.Request) {
+	credential := req.AuthenticationResponseJSON{}
+	if !DecodeCredentialJSON(w, r, &credential) {
+		return
 	}
 
-	if err != nil {
-		fmt.Println("Error Decoding Credential ID:", err)
-		JSONResponse(w, "Error Decoding Credential ID", http.StatusNotFound)
+	user, cred, verified, err := FinishAssertion(r, &credential)
+	if _, ok := err.(*CeremonyError); ok {
+		CeremonyErrorResponse(w, err)
 		return
 	}
-
-	var acs []AllowedCredential
-
-	for _, cred := range creds {
-		ac := AllowedCredential{
-			CredID:     cred.CredID,
-			Type:       "public-key", // This should always be type 'public-key' for now
-			Transports: []string{"usb", "nfc", "ble"},
+	if verified {
+		err = StartLoginSession(w, r, &user, &cred)
+		if err != nil {
+			fmt.Println("Error starting login session:", err)
+			JSONResponse(w, "Error logging in", http.StatusInternalServerError)
+			return
 		}
-		acs = append(acs, ac)
-	}
-
-	assertionResponse := PublicKeyCredentialOptions{
-		Challenge: sd.Challenge,
-		Timeout:   timeout,
-		AllowList: acs,
-		RPID:      rp.ID,
 	}
 
-	JSONResponse(w, assertionResponse, http.StatusOK)
+	JSONResponse(w, res.CredentialActionResponse{
+		Success:    verified,
+		Credential: res.FormatCredential(cred),
+	}, http.StatusOK)
 }
 
-// MakeAssertion - Validate the Assertion Data provided by the authenticator and
-// resond whether or not it was successful alongside the relevant credential.
-func MakeAssertion(w http.ResponseWriter, r *http.Request) {
-	session, _ := store.Get(r, "assertion-session")
-	sessionID := session.Values["session_id"].(int64)
-	sessionData, err := models.GetSessionData(sessionID)
+// FinishAssertion - Verify the credential returned by navigator.credentials.get()
+// against the session in the assertion cookie. Problems with the request are
+// a *CeremonyError, an assertion that doesn't verify returns false with what
+// was wrong with it.
+func FinishAssertion(r *http.Request, credential *req.AuthenticationResponseJSON) (models.User, models.Credential, bool, error) {
+	sessionData, err := ConsumeSessionForRequest(r, "assertion-session", "att")
+	if err == models.ErrSessionExpired {
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "The challenge has expired, please try again", err}
+	}
 	if err != nil {
-		JSONResponse(w, "Missing Session Data Cookie", http.StatusBadRequest)
-		return
+		fmt.Println("Error getting session data", err)
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Missing Session Data Cookie", err}
 	}
 
-	encoder := b64.URLEncoding.Strict()
-	encAssertionData, err := encoder.DecodeString(r.PostFormValue("authData"))
+	err = credential.Check()
 	if err != nil {
-		fmt.Println("b64 Decode Error: ", err)
-		JSONResponse(w, "Error decoding assertion data", http.StatusBadRequest)
-		return
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, err.Error(), nil}
 	}
 
-	authData, err := ParseAssertionData(encAssertionData, r.PostFormValue("signature"))
+	encAssertionData, err := req.DecodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		fmt.Println("b64 Decode Error: ", err)
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Error decoding assertion data", err}
+	}
+	signature, err := req.DecodeBase64URL(credential.Response.Signature)
+	if err != nil {
+		fmt.Println("b64 Decode Error: ", err)
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Error decoding the signature", err}
+	}
 
+	authData, err := ParseAssertionData(encAssertionData, signature)
 	if err != nil {
 		fmt.Println("Parse Assertion Error: ", err)
-		JSONResponse(w, "Error parsing assertion data", http.StatusBadRequest)
-		return
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Error parsing assertion data", err}
 	}
 
-	clientData, err := UnmarshallClientData(r.PostFormValue("clientData"))
+	clientData, err := UnmarshallClientData(credential.Response.ClientDataJSON)
+	if err != nil {
+		fmt.Println("Error decoding client data:", err)
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Error getting client data", err}
+	}
 
-	var credentialID string
-	credentialID = r.FormValue("id")
+	credentialID := strings.TrimRight(credential.ID, "=")
 
-	if credentialID == "" {
-		JSONResponse(w, "Missing Credential ID", http.StatusBadRequest)
-		return
+	user, err := GetAssertionUser(&sessionData, credential.Response.UserHandle)
+	if err != nil {
+		fmt.Println("Couldn't Find the User for the assertion:", err)
+		return models.User{}, models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Couldn't Find User", err}
 	}
+	sessionData.User = user
 
-	verified, credential, _ := VerifyAssertionData(&clientData, &authData, &sessionData, credentialID)
+	verified, cred, err := VerifyAssertionData(&clientData, &authData, &sessionData, credentialID)
+	if verified {
+		cred.User = user
+	}
+	return user, cred, verified, err
+}
 
-	JSONResponse(w, res.CredentialActionResponse{
-		Success:    verified,
-		Credential: credential,
-	}, http.StatusOK)
+// GetAssertionUser - The user an assertion is for. When the user was identified
+// before the ceremony it's the user of the session, otherwise the authenticator
+// used a discoverable credential and the userHandle tells us who it is.
+func GetAssertionUser(sessionData *models.SessionData, encodedHandle string) (models.User, error) {
+	var handle []byte
+	if encodedHandle != "" {
+		var err error
+		handle, err = req.DecodeBase64URL(encodedHandle)
+		if err != nil {
+			fmt.Println("Error decoding user handle:", err)
+			return models.User{}, errors.New("Error decoding the user handle")
+		}
+	}
+
+	if sessionData.UserID == 0 {
+		if handle == nil {
+			return models.User{}, errors.New("Missing user handle for a usernameless assertion")
+		}
+		return models.GetUserByHandle(handle)
+	}
+
+	user, err := models.GetUser(sessionData.UserID)
+	if err != nil {
+		return user, err
+	}
+	// If response.userHandle is present, verify that it maps to the same
+	// user as the one identified before the ceremony.
+	if handle != nil && !bytes.Equal(handle, user.Handle) {
+		return models.User{}, errors.New("User handle does not match the user of the session")
+	}
+	return user, nil
 }
 
-// CheckCredentialCounter - We may want to check for replay attacks but
-// we definitely want to update the internal counter
-// Note: this currently doesn't do that, lol
-func CheckCredentialCounter(cred *models.Credential) error {
-	return models.UpdateCredential(cred)
+// CheckCredentialCounter - Compare the signature counter of a verified assertion
+// with the stored one and store it. What happens when it went backwards is up
+// to the counter policy of the credential's Relying Party.
+func CheckCredentialCounter(cred *models.Credential, counter uint32) error {
+	policy := models.CounterPolicyReject
+	rp, err := models.GetRelyingPartyByHost(cred.RelyingPartyID)
+	if err == nil && rp.CounterPolicy != "" {
+		policy = rp.CounterPolicy
+	}
+	return models.UpdateCredentialCounter(cred, counter, policy)
 }
 
 // VerifyAssertionData - Verifies that the Assertion data provided is correct and valid
@@ -356,6 +582,10 @@ func VerifyAssertionData(
 	// if base64url encoding is inappropriate for your use case), look up the
 	// corresponding credential public key.
 
+	// The credential is looked up for the user of the session, which was
+	// either identified before the ceremony or by the returned userHandle,
+	// so we never accept a credential the user doesn't own.
+
 	fmt.Printf("Auth data is %+v\n", authData)
 
 	// var credential models.Credential
@@ -379,14 +609,6 @@ func VerifyAssertionData(
 	fmt.Printf("Decoded Client Data: %+v\n", clientData)
 	fmt.Printf("Auth Data: %+v\n", authData)
 
-	credential.Counter = authData.Counter
-	err = CheckCredentialCounter(&credential)
-	if err != nil {
-		fmt.Println("Error updating the the counter")
-		err := errors.New("Error updating the the counter")
-		return false, credential, err
-	}
-
 	// Step 4. Verify that the type in C is the string webauthn.create
 	if clientData.ActionType != "webauthn.get" {
 		fmt.Println("Client Request type is: ", string(clientData.ActionType))
@@ -406,17 +628,10 @@ func VerifyAssertionData(
 	}
 
 	// Step 6. Verify that the origin member of C matches the Relying Party's origin.
-	cdo, err := url.Parse(clientData.Origin)
+	// The scheme, host and port all have to be one of the RP's origins.
+	err = sessionData.RelyingParty.CheckOrigin(clientData.Origin)
 	if err != nil {
-		fmt.Println("Error Parsing Client Data Origin: ", string(clientData.Origin))
-		err := errors.New("Error Parsing the Client Data Origin")
-		return false, credential, err
-	}
-
-	if sessionData.RelyingPartyID != cdo.Hostname() {
-		fmt.Println("Stored Origin is: ", string(sessionData.RelyingPartyID))
-		fmt.Println("Client Origin is: ", string(clientData.Origin))
-		err := errors.New("Stored and Client Origin do not match")
+		fmt.Println("Client Origin mismatch:", err)
 		return false, credential, err
 	}
 
@@ -434,7 +649,7 @@ func VerifyAssertionData(
 	// Step 9. Verify that the RP ID hash in aData is the SHA-256 hash of the RP ID expected
 	// by the Relying Party.
 	hasher := sha256.New()
-	hasher.Write([]byte(config.Conf.HostAddress)) // We use our default RP ID - Host
+	hasher.Write([]byte(sessionData.RelyingPartyID)) // The RP ID the challenge was issued for
 	RPIDHash := hasher.Sum(nil)
 	hexRPIDHash := hex.EncodeToString(RPIDHash)
 	if hexRPIDHash != (authData.RPIDHash) {
@@ -444,6 +659,19 @@ func VerifyAssertionData(
 		return false, credential, err
 	}
 
+	// Verify that the User Present bit of the flags in aData is set.
+	if !authData.Flags.UserPresent() {
+		err := errors.New("User Present flag is not set in the authenticator data")
+		return false, credential, err
+	}
+
+	// If user verification is required for this assertion, verify that the
+	// User Verified bit of the flags in aData is set.
+	if sessionData.UserVerificationRequired() && !authData.Flags.UserVerified() {
+		err := errors.New("User Verified flag is not set in the authenticator data")
+		return false, credential, err
+	}
+
 	// Step 10. Let hash be the result of computing a hash over the cData using the
 	// algorithm represented by the hashAlgorithm member of C.
 
@@ -472,97 +700,133 @@ func VerifyAssertionData(
 	// is a valid signature over the binary concatenation of aData and hash.
 	binCat := append(authData.RawAssertionData, clientDataHash...)
 
-	pubKey, err := models.GetPublicKeyForCredential(&credential)
+	valid, err := credential.PublicKey.Verify(authData.Signature, binCat)
 	if err != nil {
-		fmt.Println("Error retreiving Public Key for Credential")
-		err := errors.New("Error retrieving public key for credential")
+		fmt.Println("Error verifying assertion signature:", err)
+		err := errors.New("Error verifying the signature with the credential public key")
 		return false, credential, err
 	}
-
-	var ecsdaSig struct {
-		R, S *big.Int
+	if !valid {
+		return false, credential, nil
 	}
 
-	sig := authData.Signature
-	_, err = asn1.Unmarshal(sig, &ecsdaSig)
+	// Step 12. If the signature counter value in aData or the value stored in
+	// conjunction with credential's id attribute is nonzero, and the value in
+	// aData is less than or equal to the stored value, the authenticator may
+	// be cloned. Otherwise update the stored value to the one in aData.
+	err = CheckCredentialCounter(&credential, authData.Counter)
+	if err == models.ErrCounterRegression {
+		return false, credential, err
+	}
 	if err != nil {
-		return false, credential, errors.New("Error unmarshalling signature")
+		fmt.Println("Error updating the counter:", err)
+		err := errors.New("Error updating the counter")
+		return false, credential, err
 	}
 
-	h := sha256.New()
-	h.Write(binCat)
-
-	return ecdsa.Verify(&pubKey, h.Sum(nil), ecsdaSig.R, ecsdaSig.S), credential, nil
+	return true, credential, nil
 }
 
 // MakeNewCredential - Attempt to make a new credential given an authenticator's response
 func MakeNewCredential(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	credential := req.RegistrationResponseJSON{}
+	if !DecodeCredentialJSON(w, r, &credential) {
+		return
+	}
+
+	newCredential, verified, err := FinishRegistration(r, &credential)
 	if err != nil {
-		http.Error(w, "Error creating JSON response", http.StatusInternalServerError)
+		CeremonyErrorResponse(w, err)
+		return
 	}
 
-	encodedAuthData, err := DecodeAttestationObject(r.PostFormValue("attObj"))
+	if verified {
+		// Registering proves the user has the authenticator, so they're logged in
+		err = StartLoginSession(w, r, &newCredential.User, &newCredential)
+		if err != nil {
+			fmt.Println("Error starting login session:", err)
+			JSONResponse(w, "Error logging in", http.StatusInternalServerError)
+			return
+		}
+		JSONResponse(w, res.CredentialActionResponse{
+			Success:    true,
+			Credential: res.FormatCredential(newCredential),
+		}, http.StatusOK)
+	} else {
+		JSONResponse(w, res.CredentialActionResponse{
+			Success:    false,
+			Credential: res.CredentialResponse{},
+		}, http.StatusOK)
+	}
+}
+
+// FinishRegistration - Verify the credential returned by
+// navigator.credentials.create() against the session in the registration
+// cookie and store it. Every problem is a *CeremonyError.
+func FinishRegistration(r *http.Request, credential *req.RegistrationResponseJSON) (models.Credential, bool, error) {
+	err := credential.Check()
+	if err != nil {
+		return models.Credential{}, false, &CeremonyError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	encodedAuthData, err := DecodeAttestationObject(credential.Response.AttestationObject)
+	if err != nil {
+		return models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Error decoding the attestation object", err}
+	}
 	decodedAuthData, err := ParseAuthData(encodedAuthData)
 
 	if err != nil {
-		JSONResponse(w, "Error parsing the authentication data", http.StatusNotFound)
-		return
+		return models.Credential{}, false, &CeremonyError{http.StatusNotFound, "Error parsing the authentication data", err}
 	}
 
-	clientData, err := UnmarshallClientData(r.PostFormValue("clientData"))
+	clientData, err := UnmarshallClientData(credential.Response.ClientDataJSON)
 	if err != nil {
-		JSONResponse(w, "Error getting client data", http.StatusNotFound)
-		return
+		return models.Credential{}, false, &CeremonyError{http.StatusNotFound, "Error getting client data", err}
 	}
 
-	session, err := store.Get(r, "registration-session")
+	sessionData, err := ConsumeSessionForRequest(r, "registration-session", "reg")
+	if err == models.ErrSessionExpired {
+		return models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "The challenge has expired, please try again", err}
+	}
 	if err != nil {
 		fmt.Println("Error getting session data", err)
-		JSONResponse(w, "Error getting session data", http.StatusNotFound)
-		return
+		return models.Credential{}, false, &CeremonyError{http.StatusNotFound, "Error getting session data", err}
 	}
 
-	sessionID := session.Values["session_id"].(int64)
-	log.Println("SessionID:", sessionID)
-	sessionData, err := models.GetSessionData(sessionID)
-
 	verified, err := VerifyRegistrationData(&clientData, &decodedAuthData, &sessionData)
 
 	if err != nil {
 		fmt.Println("Error verifying credential", err)
-		JSONResponse(w, "Error verifying credential", http.StatusBadRequest)
-		return
+		return models.Credential{}, false, &CeremonyError{http.StatusBadRequest, "Error verifying credential", err}
+	}
+	if !verified {
+		return models.Credential{}, false, nil
 	}
 
-	if verified {
-		newCredential := models.Credential{
-			Counter:        decodedAuthData.Counter,
-			RelyingPartyID: sessionData.RelyingPartyID,
-			RelyingParty:   sessionData.RelyingParty,
-			UserID:         sessionData.UserID,
-			User:           sessionData.User,
-			Format:         decodedAuthData.Format,
-			Type:           r.PostFormValue("type"),
-			Flags:          decodedAuthData.Flags,
-			CredID:         r.PostFormValue("id"),
-			PublicKey:      decodedAuthData.PubKey,
-		}
-		err := models.CreateCredential(&newCredential)
-		if err != nil {
-			fmt.Println(err)
-		}
-		fmt.Printf("%+v\n", newCredential)
-		JSONResponse(w, res.CredentialActionResponse{
-			Success:    true,
-			Credential: newCredential,
-		}, http.StatusOK)
-	} else {
-		JSONResponse(w, res.CredentialActionResponse{
-			Success:    false,
-			Credential: models.Credential{},
-		}, http.StatusOK)
+	newCredential := models.Credential{
+		Counter:        decodedAuthData.Counter,
+		RelyingPartyID: sessionData.RelyingPartyID,
+		RelyingParty:   sessionData.RelyingParty,
+		UserID:         sessionData.UserID,
+		User:           sessionData.User,
+		Format:         decodedAuthData.Format,
+		Type:           credential.Type,
+		Flags:          []byte(decodedAuthData.Flags.String()),
+		CredID:         strings.TrimRight(credential.ID, "="),
+		PublicKey:      decodedAuthData.PubKey,
+
+		AttestationTrust: string(decodedAuthData.AttestationTrust),
+	}
+	err = models.CreateCredential(&newCredential)
+	if err == models.ErrCredentialExists {
+		return newCredential, false, &CeremonyError{http.StatusConflict, "This authenticator is already registered", err}
 	}
+	if err != nil {
+		fmt.Println("Error creating credential:", err)
+		return newCredential, false, &CeremonyError{http.StatusInternalServerError, "Error creating credential", err}
+	}
+	fmt.Printf("%+v\n", newCredential)
+	return newCredential, true, nil
 }
 
 // VerifyRegistrationData - Verify that the provided Authenticator and Client
@@ -597,17 +861,10 @@ func VerifyRegistrationData(
 	}
 
 	// Step 4. Verify that to origin in C matches the relying party's origin
-	cdo, err := url.Parse(clientData.Origin)
+	// The scheme, host and port all have to be one of the RP's origins.
+	err := sessionData.RelyingParty.CheckOrigin(clientData.Origin)
 	if err != nil {
-		fmt.Println("Error Parsing Client Data Origin: ", string(clientData.Origin))
-		err := errors.New("Error Parsing the Client Data Origin")
-		return false, err
-	}
-
-	if sessionData.RelyingPartyID != cdo.Hostname() {
-		fmt.Println("Stored Origin is: ", string(sessionData.RelyingPartyID))
-		fmt.Println("Client Origin is: ", string(clientData.Origin))
-		err := errors.New("Stored and Client Origin do not match")
+		fmt.Println("Client Origin mismatch:", err)
 		return false, err
 	}
 
@@ -657,7 +914,7 @@ func VerifyRegistrationData(
 	// Step 9. Verify that the RP ID hash in authData is indeed the
 	// SHA-256 hash of the RP ID expected by the RP.
 	hasher := sha256.New()
-	hasher.Write([]byte(config.Conf.HostAddress)) // We use our default RP ID - Host
+	hasher.Write([]byte(sessionData.RelyingPartyID)) // The RP ID the challenge was issued for
 	RPIDHash := hasher.Sum(nil)
 	computedRPIDHash := hex.EncodeToString(RPIDHash)
 	if string(computedRPIDHash) != (authData.RPIDHash) {
@@ -667,19 +924,83 @@ func VerifyRegistrationData(
 		return false, err
 	}
 
+	// Verify that the User Present bit of the flags in authData is set.
+	if !authData.Flags.UserPresent() {
+		err := errors.New("User Present flag is not set in the authenticator data")
+		return false, err
+	}
+
+	// If user verification is required for this registration, verify that
+	// the User Verified bit of the flags in authData is set.
+	if sessionData.UserVerificationRequired() && !authData.Flags.UserVerified() {
+		err := errors.New("User Verified flag is not set in the authenticator data")
+		return false, err
+	}
+
+	// Verify that the "alg" parameter in the credential public key in
+	// authData matches the alg attribute of one of the items in
+	// options.pubKeyCredParams.
+	if !OfferedAlgorithm(&sessionData.RelyingParty, authData.PubKey.Type) {
+		err := fmt.Errorf("Credential algorithm %d was not offered", authData.PubKey.Type)
+		return false, err
+	}
+
 	// Step 10. Determine the attestation statement format by performing
 	// an USASCII case-sensitive match on fmt against the set of supported
 	// WebAuthn Attestation Statement Format Identifier values.
 
-	if authData.Format != "none" && authData.Format != "fido-u2f" {
+	switch authData.Format {
+	case "none", "fido-u2f", "packed", "tpm", "android-key", "android-safetynet", "apple":
+	default:
 		fmt.Println("Auth Data Format is incorrect:", authData.Format)
-		err := errors.New("Auth data is not in proper format (none)")
+		err := errors.New("Auth data is not in proper format (none, fido-u2f, packed, tpm, android-key, android-safetynet, apple)")
 		return false, err
 	}
 
 	isValid := false
 
-	if authData.Format == "fido-u2f" {
+	switch authData.Format {
+	case "packed":
+		// Step 11. Verify the attestation statement using the packed
+		// attestation statement format's verification procedure.
+		isValid, err = VerifyPackedAttestation(authData, clientDataHash)
+		if err != nil {
+			fmt.Println("Error verifying packed attestation:", err)
+			return false, err
+		}
+	case "tpm":
+		// Step 11. Verify the attestation statement using the TPM
+		// attestation statement format's verification procedure.
+		isValid, err = VerifyTPMAttestation(authData, clientDataHash)
+		if err != nil {
+			fmt.Println("Error verifying tpm attestation:", err)
+			return false, err
+		}
+	case "android-key":
+		// Step 11. Verify the attestation statement using the Android key
+		// attestation statement format's verification procedure.
+		isValid, err = VerifyAndroidKeyAttestation(authData, clientDataHash)
+		if err != nil {
+			fmt.Println("Error verifying android-key attestation:", err)
+			return false, err
+		}
+	case "android-safetynet":
+		// Step 11. Verify the attestation statement using the Android
+		// SafetyNet attestation statement format's verification procedure.
+		isValid, err = VerifySafetyNetAttestation(authData, clientDataHash)
+		if err != nil {
+			fmt.Println("Error verifying android-safetynet attestation:", err)
+			return false, err
+		}
+	case "apple":
+		// Step 11. Verify the attestation statement using the Apple
+		// anonymous attestation statement format's verification procedure.
+		isValid, err = VerifyAppleAttestation(authData, clientDataHash)
+		if err != nil {
+			fmt.Println("Error verifying apple attestation:", err)
+			return false, err
+		}
+	case "fido-u2f":
 		// Step 11. Verify that attStmt is a correct, validly-signed attestation
 		// statement, using the attestation statement format fmt’s verification
 		// procedure given authenticator data authData and the hash of the
@@ -706,7 +1027,17 @@ func VerifyRegistrationData(
 			return false, err
 		}
 
-		pubKey := authData.AttStatement.Certificate.PublicKey.(*ecdsa.PublicKey)
+		if authData.AttStatement.Certificate == nil {
+			err := errors.New("Missing fido-u2f attestation certificate")
+			return false, err
+		}
+
+		// U2F attestation certificates and credentials are P-256 keys
+		pubKey, ok := authData.AttStatement.Certificate.PublicKey.(*ecdsa.PublicKey)
+		if !ok || pubKey.Curve != elliptic.P256() {
+			err := errors.New("fido-u2f attestation certificate doesn't have a P-256 public key")
+			return false, err
+		}
 		fmt.Printf("Public Key from Certificate: %+v\n", authData.AttStatement.Certificate.PublicKey)
 		fmt.Printf("Public Key from Auth Data: %+v\n", authData.PubKey)
 
@@ -733,7 +1064,7 @@ func VerifyRegistrationData(
 		h := sha256.New()
 		h.Write(assembledData)
 		isValid = ecdsa.Verify(pubKey, h.Sum(nil), ecsdaSig.R, ecsdaSig.S)
-	} else {
+	default:
 		isValid = true
 	}
 
@@ -744,6 +1075,28 @@ func VerifyRegistrationData(
 	// attestation certificate chain if the client did not provide this chain in the attestation
 	// information.
 
+	if isValid {
+		// Step 12. If validation is successful, obtain a list of acceptable trust
+		// anchors (attestation root certificates) for that attestation type and
+		// attestation statement format fmt, for example from the FIDO Metadata Service.
+
+		// The trust anchor store also has the roots from the metadata BLOB, here
+		// we just make sure the metadata service isn't warning against this model
+		err = CheckAuthenticatorStatus(authData)
+		if err != nil {
+			return false, err
+		}
+
+		// Step 13. Assess the attestation trustworthiness using the outputs of
+		// the verification procedure in step 11.
+		authData.AttestationTrust = AssessAttestationTrust(authData)
+		fmt.Println("Attestation trust is", authData.AttestationTrust)
+		if authData.AttestationTrust == trust.ResultUntrusted && config.Conf.RequireTrustedAttestation {
+			err := errors.New("Attestation is not from a trusted authenticator")
+			return false, err
+		}
+	}
+
 	// To avoid ambiguity during authentication, the Relying Party SHOULD check that
 	// each credential is registered to no more than one user. If registration is
 	// requested for a credential that is already registered to a different user, the
@@ -761,6 +1114,11 @@ func AssembleSignedRegistrationData(
 	credID []byte,
 	pubKey models.PublicKey,
 ) ([]byte, error) {
+	// The public key is written as an uncompressed P-256 point
+	if pubKey.KeyType != models.COSEKeyTypeEC2 || pubKey.Curve != models.COSECurveP256 ||
+		len(pubKey.XCoord) != 32 || len(pubKey.YCoord) != 32 {
+		return nil, errors.New("fido-u2f credential public key is not an EC2 P-256 key")
+	}
 	buf := bytes.NewBuffer([]byte{0x00})
 	buf.Write(rpIDHash)
 	buf.Write(tbsHash)
@@ -771,26 +1129,44 @@ func AssembleSignedRegistrationData(
 	return buf.Bytes(), nil
 }
 
+// DecodeCredentialJSON - Decode the RegistrationResponseJSON or
+// AuthenticationResponseJSON body of a request into credential, answering
+// with a 400 when it isn't one. It returns whether the request can go on.
+func DecodeCredentialJSON(w http.ResponseWriter, r *http.Request, credential interface{}) bool {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		JSONResponse(w, "The credential needs to be sent as application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	err := json.NewDecoder(r.Body).Decode(credential)
+	if err != nil {
+		fmt.Println("Error decoding credential JSON:", err)
+		JSONResponse(w, "Error decoding the credential", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 // UnmarshallClientData - Unmarshall the ClientDataJSON provided by the authenticator.
-// It is Base 64 encoded before being sent up to the server, so we b6 decode
+// It is base64url encoded before being sent up to the server, so we decode
 // it first.
 func UnmarshallClientData(clientData string) (req.DecodedClientData, error) {
-	b64Decoder := b64.StdEncoding.Strict()
-	clientDataBytes, _ := b64Decoder.DecodeString(clientData)
+	clientDataBytes, err := req.DecodeBase64URL(clientData)
+	if err != nil {
+		return req.DecodedClientData{}, err
+	}
 	var handler codec.Handle = new(codec.JsonHandle)
 	var decoder = codec.NewDecoderBytes(clientDataBytes, handler)
 	var ucd req.DecodedClientData
-	err := decoder.Decode(&ucd)
+	err = decoder.Decode(&ucd)
 	ucd.RawClientData = string(clientDataBytes)
 	return ucd, err
 }
 
 // DecodeAttestationObject - Decode the authenticator Attestation Data from CBOR.
-// It is Base 64 encoded before being sent up to the server, so we b6 decode
+// It is base64url encoded before being sent up to the server, so we decode
 // it first.
 func DecodeAttestationObject(rawAttObj string) (req.EncodedAuthData, error) {
-	b64Decoder := b64.URLEncoding.Strict()
-	attObjBytes, err := b64Decoder.DecodeString(rawAttObj)
+	attObjBytes, err := req.DecodeBase64URL(rawAttObj)
 	if err != nil {
 		fmt.Println("b64 Decode error:", err)
 		return req.EncodedAuthData{}, err
@@ -807,30 +1183,20 @@ func DecodeAttestationObject(rawAttObj string) (req.EncodedAuthData, error) {
 }
 
 // ParseAssertionData - Parses assertion data from byte array to a struct
-func ParseAssertionData(assertionData []byte, hexSig string) (req.DecodedAssertionData, error) {
+func ParseAssertionData(assertionData []byte, rawSig []byte) (req.DecodedAssertionData, error) {
 	decodedAssertionData := req.DecodedAssertionData{}
 
-	rpID := assertionData[:32]
-	rpIDHash := hex.EncodeToString(rpID)
-
-	intFlags := assertionData[32]
-
-	counter := assertionData[33:]
-
-	if len(assertionData) > 38 {
-		err := errors.New("assertionData byte array is too long")
-		return decodedAssertionData, err
-	}
-
-	rawSig, err := hex.DecodeString(hexSig)
+	authData, err := ParseAuthenticatorData(assertionData)
 	if err != nil {
+		fmt.Println("Error parsing assertion authenticator data:", err)
 		return decodedAssertionData, err
 	}
 
 	decodedAssertionData = req.DecodedAssertionData{
-		Flags:            intFlags,
-		RPIDHash:         rpIDHash,
-		Counter:          counter,
+		Flags:            authData.Flags,
+		RPIDHash:         hex.EncodeToString(authData.RPIDHash),
+		Counter:          authData.Counter,
+		Extensions:       authData.Extensions,
 		RawAssertionData: assertionData,
 		Signature:        rawSig,
 	}
@@ -842,58 +1208,52 @@ func ParseAssertionData(assertionData []byte, hexSig string) (req.DecodedAsserti
 func ParseAuthData(ead req.EncodedAuthData) (req.DecodedAuthData, error) {
 	decodedAuthData := req.DecodedAuthData{}
 
-	rpID := ead.AuthData[:32]
-	rpIDHash := hex.EncodeToString(rpID)
-
-	intFlags := ead.AuthData[32]
-	flags := fmt.Sprintf("%08b", intFlags)
-
-	counter := ead.AuthData[33:38]
-
-	if len(ead.AuthData) < 38 {
-		err := errors.New("AuthData byte array is not long enough")
+	authData, err := ParseAuthenticatorData(ead.AuthData)
+	if err != nil {
+		fmt.Println("Error parsing registration authenticator data:", err)
 		return decodedAuthData, err
 	}
 
-	aaguid := ead.AuthData[38:54]
-
-	credIDLen := ead.AuthData[53] + ead.AuthData[54]
-
-	credID := ead.AuthData[55 : 55+credIDLen]
-
-	cborPubKey := ead.AuthData[55+credIDLen:]
-
-	var handler codec.Handle = new(codec.CborHandle)
-	decoder := codec.NewDecoderBytes(cborPubKey, handler)
+	// A new credential always comes with attested credential data
+	attestedData := authData.AttestedCredentialData
+	if attestedData == nil {
+		err := errors.New("Authenticator data is missing attested credential data")
+		return decodedAuthData, err
+	}
 
-	var pubKey models.PublicKey
-	err := decoder.Decode(&pubKey)
+	pubKey, err := models.ParseCOSEKey(attestedData.CredentialPublicKey)
 	if err != nil {
-		log.Println(cborPubKey)
 		fmt.Println("Error decoding the Public Key in Authentication Data", err)
 		return decodedAuthData, err
 	}
 
 	decodedAuthData = req.DecodedAuthData{
+		// RawAuthData is signed over by attestation formats such as "packed"
+		RawAuthData: ead.AuthData,
 		// Flags are used to determine user presence, user verification, and if attData is present
-		Flags: []byte(flags),
+		Flags: authData.Flags,
 		// Counter is used to prevent replay attacks
-		Counter: counter,
+		Counter: authData.Counter,
 		// RPIDHash is used to verify the Auth Request
-		RPIDHash: rpIDHash,
+		RPIDHash: hex.EncodeToString(authData.RPIDHash),
 		// AAGUID is the ID of the Authenticator device line
-		AAGUID: aaguid,
+		AAGUID: attestedData.AAGUID,
 		// CredID is the ID of the credential we are creating
-		CredID: credID,
+		CredID: attestedData.CredentialID,
 		// Public Key of the credential key pair
 		PubKey: pubKey,
+		// The CBOR encoded COSE_Key the Public Key was decoded from
+		RawPubKey: attestedData.CredentialPublicKey,
+		// Authenticator extension outputs, if the authenticator returned any
+		Extensions: authData.Extensions,
 		// Format of the attestation statement (ex, "u2f", "safety-net"), currently defaults to "none"
 		Format: ead.Format,
 	}
 
-	// If the format is one that contains an authenticator attestation certificate then parse it
-	if ead.Format == "fido-u2f" {
-		das, err := ParseAttestationStatement(ead.AttStatement)
+	// If the format is one that contains an authenticator attestation statement then parse it
+	switch ead.Format {
+	case "fido-u2f", "packed", "tpm", "android-key", "android-safetynet", "apple":
+		das, err := ParseAttestationStatement(ead.Format, ead.AttStatement)
 		if err != nil {
 			fmt.Println("Error parsing Attestation Statement from Authentication Data")
 			return decodedAuthData, err
@@ -907,18 +1267,44 @@ func ParseAuthData(ead req.EncodedAuthData) (req.DecodedAuthData, error) {
 
 // ParseAttestationStatement - parse the Attestation Certificate returned by the
 // the authenticator
-func ParseAttestationStatement(
+func ParseAttestationStatement(format string,
 	ead req.EncodedAttestationStatement) (req.DecodedAttestationStatement, error) {
-	das := req.DecodedAttestationStatement{}
-	// Currently, for fido-u2f formatted attStatements, we only support one x509 cert
-	// but it is returned to us as an array
-	cert, err := x509.ParseCertificate(ead.X509Cert[0])
-	if err != nil {
-		return das, err
+	das := req.DecodedAttestationStatement{
+		Algorithm: ead.Algorithm,
+		Signature: ead.Signature,
+		Version:   ead.Version,
+		CertInfo:  ead.CertInfo,
+		PubArea:   ead.PubArea,
+	}
+	// The x5c chain is absent for self attestation. When present, the
+	// attestation certificate comes first, followed by its CA certificates.
+	parseCertificate := x509.ParseCertificate
+	if format == "tpm" {
+		parseCertificate = ParseTPMCertificate
+	}
+	for _, rawCert := range ead.X509Cert {
+		cert, err := parseCertificate(rawCert)
+		if err != nil {
+			return das, err
+		}
+		das.CertificateChain = append(das.CertificateChain, cert)
 	}
-	das = req.DecodedAttestationStatement{
-		Certificate: cert,
-		Signature:   ead.Signature,
+	if len(das.CertificateChain) > 0 {
+		das.Certificate = das.CertificateChain[0]
+		// Android keystore certificates describe the key they were issued for
+		kd, err := ParseAndroidKeyDescription(das.Certificate)
+		if err != nil {
+			return das, err
+		}
+		das.AndroidKeyDescription = kd
+	}
+	// SafetyNet carries its certificates in the JWS header instead of x5c
+	if len(ead.Response) > 0 {
+		sn, err := ParseSafetyNetJWS(ead.Response)
+		if err != nil {
+			return das, err
+		}
+		das.SafetyNet = sn
 	}
 	return das, nil
 }
@@ -962,42 +1348,75 @@ func CreateNewUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	JSONResponse(w, u, http.StatusCreated)
+	JSONResponse(w, res.FormatUser(u), http.StatusCreated)
 }
 
 // GetUser - get a user from the db
 func GetUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["name"]
-	u, err := models.GetUserByUsername(username)
-	if err != nil {
-		fmt.Println(err)
-		JSONResponse(w, "User not found, try registering one first!", http.StatusNotFound)
+	u, ok := AuthorizeUsername(w, r, username, "user.get")
+	if !ok {
 		return
 	}
-	JSONResponse(w, u, http.StatusOK)
+	JSONResponse(w, res.FormatUser(u), http.StatusOK)
 }
 
 // GetCredentials - get a user's credentials from the db
 func GetCredentials(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	username := vars["name"]
-	u, _ := models.GetUserByUsername(username)
+	u, ok := AuthorizeUsername(w, r, username, "credential.list")
+	if !ok {
+		return
+	}
 	cs, err := models.GetCredentialsForUser(&u)
 	if err != nil {
 		fmt.Println(err)
 		JSONResponse(w, "", http.StatusNotFound)
 	} else {
-		JSONResponse(w, cs, http.StatusOK)
+		JSONResponse(w, res.FormatCredentialList(cs), http.StatusOK)
 	}
 }
 
-// DeleteCredential - Delete a credential from the db
+// DeleteCredential - Delete one of the user's credentials from the db. The
+// last credential of a user is only deleted with confirm=true, without it the
+// user can't log in anymore.
 func DeleteCredential(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	credID := vars["id"]
-	err := models.DeleteCredentialByID(credID)
+	auth := AuthenticatedUser(r)
+
+	cred, err := models.GetCredentialByCredID(credID)
+	if err != nil {
+		fmt.Println(err)
+		JSONResponse(w, "Credential not Found", http.StatusNotFound)
+		return
+	}
+	owner, err := models.GetUser(cred.UserID)
+	if err != nil {
+		fmt.Println("Error getting the owner of a credential:", err)
+		JSONResponse(w, "Credential not Found", http.StatusNotFound)
+		return
+	}
+	if !CanManage(auth, &owner) {
+		Deny(w, r, auth, "credential.delete", credID, fmt.Sprintf("Credential belongs to %q", owner.Name))
+		return
+	}
+
+	creds, err := models.GetCredentialsForUser(&owner)
+	if err != nil {
+		fmt.Println("Error getting credentials:", err)
+		JSONResponse(w, "Error getting credentials", http.StatusInternalServerError)
+		return
+	}
+	if len(creds) == 1 && r.FormValue("confirm") != "true" {
+		JSONResponse(w, "This is the last credential of the user, deleting it needs confirm=true", http.StatusConflict)
+		return
+	}
+
 	fmt.Println("Deleting credential with ID ", credID)
+	err = models.DeleteCredential(&cred)
 	if err != nil {
 		fmt.Println(err)
 		JSONResponse(w, "Credential not Found", http.StatusNotFound)
@@ -1011,26 +1430,99 @@ func CreateRouter() http.Handler {
 	router := mux.NewRouter()
 	// New handlers should be added here
 	router.HandleFunc("/", Login)
-	router.HandleFunc("/dashboard/{name}", Index)
-	router.HandleFunc("/dashboard", Index)
-	router.HandleFunc("/makeCredential/{name}", RequestNewCredential).Methods("GET")
-	router.HandleFunc("/makeCredential", MakeNewCredential).Methods("POST")
-	router.HandleFunc("/assertion/{name}", GetAssertion).Methods("GET")
-	router.HandleFunc("/assertion", MakeAssertion).Methods("POST")
+	router.HandleFunc("/.well-known/webauthn", RelatedOrigins).Methods("GET")
+	router.Handle("/dashboard/{name}", RequireAuth(http.HandlerFunc(Index)))
+	router.Handle("/dashboard", RequireAuth(http.HandlerFunc(Index)))
+	// Ceremonies are also served under /rp/{rpId} for the relying parties
+	// that don't have a host of their own
+	for _, prefix := range []string{"", "/rp/{rpId}"} {
+		router.HandleFunc(prefix+"/makeCredential/{name}", RequestNewCredential).Methods("GET")
+		router.HandleFunc(prefix+"/makeCredential", MakeNewCredential).Methods("POST")
+		router.HandleFunc(prefix+"/assertion/{name}", GetAssertion).Methods("GET")
+		router.HandleFunc(prefix+"/assertion", GetAssertion).Methods("GET")
+		router.HandleFunc(prefix+"/assertion", MakeAssertion).Methods("POST")
+		// The FIDO2 conformance API
+		router.HandleFunc(prefix+"/attestation/options", AttestationOptions).Methods("POST")
+		router.HandleFunc(prefix+"/attestation/result", AttestationResult).Methods("POST")
+		router.HandleFunc(prefix+"/assertion/options", AssertionOptions).Methods("POST")
+		router.HandleFunc(prefix+"/assertion/result", AssertionResult).Methods("POST")
+	}
 	router.HandleFunc("/user", CreateNewUser).Methods("POST")
-	router.HandleFunc("/user/{name}", GetUser).Methods("GET")
-	router.HandleFunc("/credential/{name}", GetCredentials).Methods("GET")
-	router.HandleFunc("/credential/{id}", DeleteCredential).Methods("DELETE")
+	router.Handle("/user/{name}", RequireAuth(http.HandlerFunc(GetUser))).Methods("GET")
+	router.Handle("/credential/{name}", RequireAuth(http.HandlerFunc(GetCredentials))).Methods("GET")
+	router.Handle("/credential/{id}", RequireAuth(http.HandlerFunc(DeleteCredential))).Methods("DELETE")
+	router.Handle("/logout", RequireAuth(http.HandlerFunc(Logout))).Methods("POST")
+	router.Handle("/sessions", RequireAuth(http.HandlerFunc(GetLoginSessions))).Methods("GET")
+	router.Handle("/sessions/{id}", RequireAuth(http.HandlerFunc(RevokeLoginSession))).Methods("DELETE")
+	router.Handle("/audit", RequireAuth(http.HandlerFunc(GetAuditLog))).Methods("GET")
+	router.Handle("/rp", RequireAuth(http.HandlerFunc(ListRelyingParties))).Methods("GET")
+	router.Handle("/rp", RequireAuth(http.HandlerFunc(CreateRelyingParty))).Methods("POST")
+	router.Handle("/rp/{id}", RequireAuth(http.HandlerFunc(GetRelyingParty))).Methods("GET")
+	router.Handle("/rp/{id}", RequireAuth(http.HandlerFunc(UpdateRelyingParty))).Methods("PUT")
+	router.Handle("/rp/{id}", RequireAuth(http.HandlerFunc(DeleteRelyingParty))).Methods("DELETE")
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
 	return router
 }
 
 func main() {
 	config.LoadConfig("config.json")
+
+	// Subcommands like `webauthn rp list` run against the database and exit
+	if len(os.Args) > 1 {
+		err := models.Setup()
+		if err != nil {
+			log.Fatal("Error opening the database: ", err)
+		}
+		err = RunCommand(os.Args[1:], os.Stdout)
+		models.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Printf("Config: %+v\n", config.Conf)
-	err := models.Setup()
+	err := SetupCookieStore()
 	if err != nil {
-		fmt.Println(err)
+		log.Fatal("Error loading cookie keys: ", err)
+	}
+	err = models.Setup()
+	if err != nil {
+		log.Fatal("Error opening the database: ", err)
+	}
+	defer models.Close()
+	stopSweeper := models.StartSessionSweeper(time.Duration(config.Conf.SessionSweepInterval) * time.Second)
+	defer stopSweeper()
+	if config.Conf.SafetyNetRoots != "" {
+		safetyNetRoots, err = LoadCertPool(config.Conf.SafetyNetRoots)
+		if err != nil {
+			log.Fatal("Error loading SafetyNet roots: ", err)
+		}
+	}
+	if config.Conf.AppleRootCA != "" {
+		appleRoots, err = LoadCertPool(config.Conf.AppleRootCA)
+		if err != nil {
+			log.Fatal("Error loading Apple root CA: ", err)
+		}
+	}
+	if config.Conf.TrustAnchorsDir != "" {
+		err = trustAnchors.LoadDir(config.Conf.TrustAnchorsDir)
+		if err != nil {
+			log.Fatal("Error loading trust anchors: ", err)
+		}
+	}
+	for identifier, path := range config.Conf.TrustAnchors {
+		err = trustAnchors.LoadFile(identifier, path)
+		if err != nil {
+			log.Fatal("Error loading trust anchors: ", err)
+		}
+	}
+	if config.Conf.MetadataBlob != "" {
+		err = LoadMetadata(config.Conf.MetadataBlob, config.Conf.MetadataRoot)
+		if err != nil {
+			log.Fatal("Error loading metadata BLOB: ", err)
+		}
 	}
 	// Start Web Server
 	if config.Conf.HasProxy {
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"git.jba.io/go/webauthn/metadata"
+	req "git.jba.io/go/webauthn/request"
+	"git.jba.io/go/webauthn/trust"
+)
+
+// metadataService is the FIDO Metadata Service BLOB loaded from metadata_blob,
+// nil when none is configured
+var metadataService *metadata.Metadata
+
+// LoadMetadata verifies the metadata BLOB at blobPath against the root in
+// rootPath and makes its attestation roots available as trust anchors. It is
+// also how the BLOB is refreshed, there is no fetching over the network.
+func LoadMetadata(blobPath, rootPath string) error {
+	if metadataService == nil {
+		roots, err := LoadCertPool(rootPath)
+		if err != nil {
+			return err
+		}
+		md := metadata.New(roots)
+		err = md.LoadFile(blobPath)
+		if err != nil {
+			return err
+		}
+		metadataService = md
+		trustAnchors.AddSource(md)
+		return nil
+	}
+	return metadataService.LoadFile(blobPath)
+}
+
+// CheckAuthenticatorStatus - Fail registration for authenticator models the
+// metadata service has revoked or knows to let user verification be bypassed
+func CheckAuthenticatorStatus(authData *req.DecodedAuthData) error {
+	entry := metadataEntry(authData)
+	if entry == nil {
+		return nil
+	}
+
+	if entry.HasStatus(metadata.Revoked) {
+		fmt.Println("Authenticator is revoked:", entry.MetadataStatement.Description)
+		return errors.New("Authenticator has been revoked by the FIDO Metadata Service")
+	}
+	if entry.HasStatus(metadata.UserVerificationBypass) {
+		fmt.Println("Authenticator allows user verification bypass:", entry.MetadataStatement.Description)
+		return errors.New("Authenticator allows user verification to be bypassed")
+	}
+	return nil
+}
+
+// AuthenticatorCompromised - Report whether the metadata service says the
+// attestation key or the user keys of the authenticator model are compromised
+func AuthenticatorCompromised(authData *req.DecodedAuthData) bool {
+	entry := metadataEntry(authData)
+	if entry == nil {
+		return false
+	}
+	return entry.HasStatus(metadata.AttestationKeyCompromise,
+		metadata.UserKeyRemoteCompromise, metadata.UserKeyPhysicalCompromise)
+}
+
+// metadataEntry finds the metadata of the authenticator by AAGUID, or by the
+// key identifier of its attestation certificate
+func metadataEntry(authData *req.DecodedAuthData) *metadata.Entry {
+	if metadataService == nil {
+		return nil
+	}
+	var keyID []byte
+	if authData.AttStatement.Certificate != nil {
+		keyID, _ = trust.KeyIdentifier(authData.AttStatement.Certificate)
+	}
+	return metadataService.Entry(authData.AAGUID, keyID)
+}
@@ -0,0 +1,245 @@
+package metadata
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"git.jba.io/go/webauthn/trust"
+)
+
+// timeNow is swapped out by tests that use recorded blobs
+var timeNow = time.Now
+
+// Metadata is the FIDO Metadata Service (MDS3) BLOB, indexed by AAGUID and
+// attestation certificate key identifier. It is only ever loaded from a local
+// file, so refreshing it is an explicit LoadFile.
+type Metadata struct {
+	mu      sync.RWMutex
+	roots   *x509.CertPool
+	payload Payload
+	aaguids map[string]*Entry
+	keyIDs  map[string]*Entry
+}
+
+// New creates an empty Metadata whose BLOBs have to be signed by a certificate
+// chaining up to one of roots
+func New(roots *x509.CertPool) *Metadata {
+	return &Metadata{
+		roots:   roots,
+		aaguids: make(map[string]*Entry),
+		keyIDs:  make(map[string]*Entry),
+	}
+}
+
+// LoadFile verifies the BLOB at path and replaces the loaded entries with it
+func (m *Metadata) LoadFile(path string) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return m.Load(bytes.TrimSpace(blob))
+}
+
+// Load verifies a BLOB and replaces the loaded entries with it
+func (m *Metadata) Load(blob []byte) error {
+	payload, err := m.verifyBlob(blob)
+	if err != nil {
+		return err
+	}
+
+	aaguids := make(map[string]*Entry)
+	keyIDs := make(map[string]*Entry)
+	for i := range payload.Entries {
+		entry := &payload.Entries[i]
+		if entry.AAGUID != "" {
+			aaguids[strings.ToLower(strings.Replace(entry.AAGUID, "-", "", -1))] = entry
+		}
+		for _, keyID := range entry.AttestationCertificateKeyIdentifiers {
+			keyIDs[strings.ToLower(keyID)] = entry
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.payload = *payload
+	m.aaguids = aaguids
+	m.keyIDs = keyIDs
+	return nil
+}
+
+// Number is the serial number of the loaded BLOB, 0 when nothing is loaded
+func (m *Metadata) Number() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.payload.Number
+}
+
+// Entry finds the entry for an authenticator by its AAGUID, or for
+// authenticators without one by the key identifier of its attestation
+// certificate. It returns nil for unknown authenticators.
+func (m *Metadata) Entry(aaguid, keyID []byte) *Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(aaguid) != 0 && !bytes.Equal(aaguid, make([]byte, len(aaguid))) {
+		if entry, ok := m.aaguids[hex.EncodeToString(aaguid)]; ok {
+			return entry
+		}
+	}
+	if len(keyID) != 0 {
+		if entry, ok := m.keyIDs[hex.EncodeToString(keyID)]; ok {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Anchors returns the attestation root certificates from the metadata
+// statement of the authenticator, so Metadata can be a trust.Source
+func (m *Metadata) Anchors(aaguid, keyID []byte) []*x509.Certificate {
+	entry := m.Entry(aaguid, keyID)
+	if entry == nil {
+		return nil
+	}
+	var anchors []*x509.Certificate
+	for _, encodedCert := range entry.MetadataStatement.AttestationRootCertificates {
+		rawCert, err := base64.StdEncoding.DecodeString(encodedCert)
+		if err != nil {
+			fmt.Println("Error decoding metadata root certificate:", err)
+			continue
+		}
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			fmt.Println("Error parsing metadata root certificate:", err)
+			continue
+		}
+		anchors = append(anchors, cert)
+	}
+	return anchors
+}
+
+var _ trust.Source = (*Metadata)(nil)
+
+// verifyBlob checks the signature of the BLOB JWT and decodes its payload
+func (m *Metadata) verifyBlob(blob []byte) (*Payload, error) {
+	parts := bytes.Split(blob, []byte("."))
+	if len(parts) != 3 {
+		return nil, errors.New("Metadata BLOB is not a compact JWT")
+	}
+	signedData := blob[:len(parts[0])+1+len(parts[1])]
+
+	var header blobHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, errors.New("Error decoding metadata BLOB header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(string(parts[2]))
+	if err != nil {
+		return nil, errors.New("Error decoding metadata BLOB signature")
+	}
+
+	// The BLOB is signed by the first certificate in x5c, which has to chain
+	// up to the configured metadata root
+	var certs []*x509.Certificate
+	for _, encodedCert := range header.X5C {
+		rawCert, err := base64.StdEncoding.DecodeString(encodedCert)
+		if err != nil {
+			return nil, errors.New("Error decoding metadata BLOB certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("Metadata BLOB is missing x5c")
+	}
+	if m.roots == nil {
+		return nil, errors.New("No metadata root is configured")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         m.roots,
+		Intermediates: intermediates,
+		CurrentTime:   timeNow(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		fmt.Println("Metadata BLOB certificate chain error:", err)
+		return nil, errors.New("Metadata BLOB certificate does not chain to the metadata root")
+	}
+
+	err = checkBlobSignature(certs[0], header.Algorithm, signedData, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload Payload
+	err = decodeSegment(parts[1], &payload)
+	if err != nil {
+		fmt.Println("Error decoding metadata BLOB payload:", err)
+		return nil, errors.New("Error decoding metadata BLOB payload")
+	}
+	return &payload, nil
+}
+
+// checkBlobSignature verifies the JWS signature of the BLOB with the signing certificate
+func checkBlobSignature(cert *x509.Certificate, alg string, signedData, signature []byte) error {
+	var sigAlg x509.SignatureAlgorithm
+	switch alg {
+	case "RS256":
+		sigAlg = x509.SHA256WithRSA
+	case "PS256":
+		sigAlg = x509.SHA256WithRSAPSS
+	case "ES256":
+		sigAlg = x509.ECDSAWithSHA256
+	default:
+		return fmt.Errorf("Unsupported metadata BLOB algorithm %s", alg)
+	}
+
+	// JWS ECDSA signatures are R || S rather than ASN.1
+	if sigAlg == x509.ECDSAWithSHA256 {
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("Metadata BLOB signature is invalid")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		digest := sha256.Sum256(signedData)
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("Metadata BLOB signature is invalid")
+		}
+		return nil
+	}
+
+	err := cert.CheckSignature(sigAlg, signedData, signature)
+	if err != nil {
+		fmt.Println("Metadata BLOB signature error:", err)
+		return errors.New("Metadata BLOB signature is invalid")
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of the BLOB
+func decodeSegment(segment []byte, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(string(segment))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
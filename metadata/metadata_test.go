@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetadataSuite struct {
+	suite.Suite
+	root       *x509.Certificate
+	signer     *x509.Certificate
+	signerKey  *ecdsa.PrivateKey
+	attestRoot *x509.Certificate
+}
+
+const testAAGUID = "cb69481e-8ff7-4039-93ec-0a2729a154a8"
+const testKeyID = "bf7c4dfdcd1bc78c1d31e9da1d9bb1d2f0ee8e4a"
+
+func makeTestCertificate(cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		panic(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func (ms *MetadataSuite) SetupTest() {
+	root, rootKey := makeTestCertificate("Test MDS Root", true, nil, nil)
+	ms.root = root
+	ms.signer, ms.signerKey = makeTestCertificate("mds.example.com", false, root, rootKey)
+	ms.attestRoot, _ = makeTestCertificate("Test Attestation Root", true, nil, nil)
+}
+
+// makeBlob signs payload as an ES256 metadata BLOB
+func (ms *MetadataSuite) makeBlob(payload Payload) []byte {
+	header, _ := json.Marshal(blobHeader{
+		Algorithm: "ES256",
+		Type:      "JWT",
+		X5C:       []string{base64.StdEncoding.EncodeToString(ms.signer.Raw)},
+	})
+	body, _ := json.Marshal(payload)
+	signedData := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signedData))
+	r, s, _ := ecdsa.Sign(rand.Reader, ms.signerKey, digest[:])
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return []byte(signedData + "." + base64.RawURLEncoding.EncodeToString(sig))
+}
+
+func (ms *MetadataSuite) testPayload() Payload {
+	return Payload{
+		Number:     42,
+		NextUpdate: "2030-01-01",
+		Entries: []Entry{
+			{
+				AAGUID: testAAGUID,
+				MetadataStatement: MetadataStatement{
+					Description:                 "Test Authenticator",
+					AttestationRootCertificates: []string{base64.StdEncoding.EncodeToString(ms.attestRoot.Raw)},
+				},
+				StatusReports: []StatusReport{{Status: FidoCertified}},
+			},
+			{
+				AttestationCertificateKeyIdentifiers: []string{testKeyID},
+				MetadataStatement:                    MetadataStatement{Description: "Test U2F Key"},
+				StatusReports:                        []StatusReport{{Status: FidoCertified}, {Status: Revoked}},
+			},
+		},
+	}
+}
+
+func (ms *MetadataSuite) roots() *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(ms.root)
+	return roots
+}
+
+func (ms *MetadataSuite) TestLoad() {
+	md := New(ms.roots())
+	err := md.Load(ms.makeBlob(ms.testPayload()))
+	if err != nil {
+		ms.T().Fatalf("Unexpected error loading BLOB: %s", err)
+	}
+	if md.Number() != 42 {
+		ms.T().Fatalf("Unexpected BLOB number %d", md.Number())
+	}
+
+	aaguid, _ := hex.DecodeString("cb69481e8ff7403993ec0a2729a154a8")
+	entry := md.Entry(aaguid, nil)
+	if entry == nil || entry.MetadataStatement.Description != "Test Authenticator" {
+		ms.T().Fatalf("Expected to find the entry by AAGUID. Got: %+v", entry)
+	}
+	anchors := md.Anchors(aaguid, nil)
+	if len(anchors) != 1 || !anchors[0].Equal(ms.attestRoot) {
+		ms.T().Fatalf("Expected the attestation root from the metadata statement. Got: %v", anchors)
+	}
+
+	keyID, _ := hex.DecodeString(testKeyID)
+	entry = md.Entry(make([]byte, 16), keyID)
+	if entry == nil || !entry.HasStatus(Revoked) {
+		ms.T().Fatalf("Expected to find the revoked entry by key identifier. Got: %+v", entry)
+	}
+	if entry.HasStatus(UserVerificationBypass) {
+		ms.T().Fatalf("Unexpected USER_VERIFICATION_BYPASS status")
+	}
+
+	if md.Entry([]byte("unknown aaguid!!"), nil) != nil {
+		ms.T().Fatalf("Expected no entry for an unknown AAGUID")
+	}
+}
+
+func (ms *MetadataSuite) TestHasStatus() {
+	fixed := Entry{StatusReports: []StatusReport{
+		{Status: UserKeyRemoteCompromise, EffectiveDate: "2021-03-01"},
+		{Status: FidoCertifiedL1, EffectiveDate: "2020-01-15"},
+		{Status: UpdateAvailable, EffectiveDate: "2021-06-01"},
+	}}
+	if fixed.HasStatus(UserKeyRemoteCompromise) || !fixed.HasStatus(UpdateAvailable) {
+		ms.T().Fatalf("Expected only the latest report to count. Got: %+v", fixed.LatestStatusReport())
+	}
+
+	revoked := Entry{StatusReports: []StatusReport{
+		{Status: Revoked, EffectiveDate: "2022-05-10"},
+		{Status: FidoCertified, EffectiveDate: "2019-11-20"},
+	}}
+	if !revoked.HasStatus(Revoked) || revoked.HasStatus(FidoCertified) {
+		ms.T().Fatalf("Expected a revocation after the certification to count. Got: %+v", revoked.LatestStatusReport())
+	}
+
+	if (&Entry{}).HasStatus(FidoCertified) {
+		ms.T().Fatalf("Unexpected status for an entry without reports")
+	}
+}
+
+func (ms *MetadataSuite) TestLoadReplacesEntries() {
+	md := New(ms.roots())
+	err := md.Load(ms.makeBlob(ms.testPayload()))
+	if err != nil {
+		ms.T().Fatalf("Unexpected error loading BLOB: %s", err)
+	}
+	err = md.Load(ms.makeBlob(Payload{Number: 43}))
+	if err != nil {
+		ms.T().Fatalf("Unexpected error reloading BLOB: %s", err)
+	}
+	aaguid, _ := hex.DecodeString("cb69481e8ff7403993ec0a2729a154a8")
+	if md.Number() != 43 || md.Entry(aaguid, nil) != nil {
+		ms.T().Fatalf("Expected the old entries to be replaced")
+	}
+}
+
+func (ms *MetadataSuite) TestLoadRejected() {
+	blob := ms.makeBlob(ms.testPayload())
+
+	err := New(x509.NewCertPool()).Load(blob)
+	if err == nil {
+		ms.T().Fatalf("Expected an error for a BLOB from an untrusted root")
+	}
+
+	tampered := append([]byte{}, blob...)
+	tampered[len(tampered)-5] ^= 0x01
+	err = New(ms.roots()).Load(tampered)
+	if err == nil {
+		ms.T().Fatalf("Expected an error for a BLOB with a bad signature")
+	}
+
+	err = New(ms.roots()).Load([]byte("not.a-jwt"))
+	if err == nil {
+		ms.T().Fatalf("Expected an error for a malformed BLOB")
+	}
+
+	timeNow = func() time.Time { return time.Now().Add(24 * time.Hour) }
+	defer func() { timeNow = time.Now }()
+	err = New(ms.roots()).Load(blob)
+	if err == nil {
+		ms.T().Fatalf("Expected an error for a BLOB signed by an expired certificate")
+	}
+}
+
+func TestRunMetadataSuite(t *testing.T) {
+	suite.Run(t, new(MetadataSuite))
+}
@@ -0,0 +1,103 @@
+package metadata
+
+// AuthenticatorStatus is the status of an authenticator model in a status report
+type AuthenticatorStatus string
+
+// The authenticator statuses defined by MDS3
+const (
+	NotFidoCertified          AuthenticatorStatus = "NOT_FIDO_CERTIFIED"
+	FidoCertified             AuthenticatorStatus = "FIDO_CERTIFIED"
+	UserVerificationBypass    AuthenticatorStatus = "USER_VERIFICATION_BYPASS"
+	AttestationKeyCompromise  AuthenticatorStatus = "ATTESTATION_KEY_COMPROMISE"
+	UserKeyRemoteCompromise   AuthenticatorStatus = "USER_KEY_REMOTE_COMPROMISE"
+	UserKeyPhysicalCompromise AuthenticatorStatus = "USER_KEY_PHYSICAL_COMPROMISE"
+	UpdateAvailable           AuthenticatorStatus = "UPDATE_AVAILABLE"
+	Revoked                   AuthenticatorStatus = "REVOKED"
+	SelfAssertionSubmitted    AuthenticatorStatus = "SELF_ASSERTION_SUBMITTED"
+	FidoCertifiedL1           AuthenticatorStatus = "FIDO_CERTIFIED_L1"
+	FidoCertifiedL1Plus       AuthenticatorStatus = "FIDO_CERTIFIED_L1plus"
+	FidoCertifiedL2           AuthenticatorStatus = "FIDO_CERTIFIED_L2"
+	FidoCertifiedL2Plus       AuthenticatorStatus = "FIDO_CERTIFIED_L2plus"
+	FidoCertifiedL3           AuthenticatorStatus = "FIDO_CERTIFIED_L3"
+	FidoCertifiedL3Plus       AuthenticatorStatus = "FIDO_CERTIFIED_L3plus"
+)
+
+// blobHeader is the JOSE header of the metadata BLOB
+type blobHeader struct {
+	Algorithm string   `json:"alg"`
+	Type      string   `json:"typ"`
+	X5C       []string `json:"x5c"`
+}
+
+// Payload is the body of the metadata BLOB
+type Payload struct {
+	LegalHeader string  `json:"legalHeader"`
+	Number      int     `json:"no"`
+	NextUpdate  string  `json:"nextUpdate"`
+	Entries     []Entry `json:"entries"`
+}
+
+// Entry is what the metadata service knows about one authenticator model
+type Entry struct {
+	AAID                                 string            `json:"aaid,omitempty"`
+	AAGUID                               string            `json:"aaguid,omitempty"`
+	AttestationCertificateKeyIdentifiers []string          `json:"attestationCertificateKeyIdentifiers,omitempty"`
+	MetadataStatement                    MetadataStatement `json:"metadataStatement"`
+	StatusReports                        []StatusReport    `json:"statusReports"`
+	TimeOfLastStatusChange               string            `json:"timeOfLastStatusChange"`
+}
+
+// LatestStatusReport is the status report with the latest effectiveDate,
+// the current status of the authenticator model. Reports with the same date
+// are taken in the order they're listed. It's nil when there are none.
+func (e *Entry) LatestStatusReport() *StatusReport {
+	var latest *StatusReport
+	for x := range e.StatusReports {
+		report := &e.StatusReports[x]
+		// The dates are ISO 8601 YYYY-MM-DD, so they sort as strings
+		if latest == nil || report.EffectiveDate >= latest.EffectiveDate {
+			latest = report
+		}
+	}
+	return latest
+}
+
+// HasStatus reports whether the latest status report of the entry has one
+// of the given statuses. Earlier reports were superseded, a compromise can
+// be fixed by an update and a certification can be revoked.
+func (e *Entry) HasStatus(statuses ...AuthenticatorStatus) bool {
+	latest := e.LatestStatusReport()
+	if latest == nil {
+		return false
+	}
+	for _, status := range statuses {
+		if latest.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataStatement describes an authenticator model. Only the fields used
+// during registration are decoded.
+type MetadataStatement struct {
+	Description                 string   `json:"description"`
+	AuthenticatorVersion        uint32   `json:"authenticatorVersion"`
+	ProtocolFamily              string   `json:"protocolFamily"`
+	Schema                      int      `json:"schema"`
+	AttestationTypes            []string `json:"attestationTypes"`
+	AttestationRootCertificates []string `json:"attestationRootCertificates"`
+}
+
+// StatusReport is a change in the status of an authenticator model
+type StatusReport struct {
+	Status                           AuthenticatorStatus `json:"status"`
+	EffectiveDate                    string              `json:"effectiveDate,omitempty"`
+	AuthenticatorVersion             uint32              `json:"authenticatorVersion,omitempty"`
+	Certificate                      string              `json:"certificate,omitempty"`
+	URL                              string              `json:"url,omitempty"`
+	CertificationDescriptor          string              `json:"certificationDescriptor,omitempty"`
+	CertificateNumber                string              `json:"certificateNumber,omitempty"`
+	CertificationPolicyVersion       string              `json:"certificationPolicyVersion,omitempty"`
+	CertificationRequirementsVersion string              `json:"certificationRequirementsVersion,omitempty"`
+}
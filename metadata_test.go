@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"git.jba.io/go/webauthn/metadata"
+	"git.jba.io/go/webauthn/models"
+	req "git.jba.io/go/webauthn/request"
+	"git.jba.io/go/webauthn/trust"
+)
+
+// writeTestMetadata signs entries into a metadata BLOB and writes it, along
+// with the root it is signed under, to dir
+func writeTestMetadata(dir string, entries []metadata.Entry) (string, string) {
+	root, rootKey := makeTestCA("Test MDS Root")
+	signerKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer := makeCertificate(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "mds.example.com"},
+	}, signerKey.Public(), root, rootKey)
+
+	header, _ := json.Marshal(map[string]interface{}{
+		"alg": "ES256",
+		"typ": "JWT",
+		"x5c": []string{base64.StdEncoding.EncodeToString(signer.Raw)},
+	})
+	payload, _ := json.Marshal(metadata.Payload{Number: 1, Entries: entries})
+	signedData := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signedData))
+	r, s, _ := ecdsa.Sign(rand.Reader, signerKey, digest[:])
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	blob := signedData + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	blobPath := filepath.Join(dir, "blob.jwt")
+	rootPath := filepath.Join(dir, "root.pem")
+	ioutil.WriteFile(blobPath, []byte(blob), 0600)
+	ioutil.WriteFile(rootPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0600)
+	return blobPath, rootPath
+}
+
+func (as *AttestationSuite) TestMetadataStatus() {
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		as.T().Fatalf("Unexpected error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		metadataService = nil
+		trustAnchors = trust.NewStore()
+	}()
+
+	credKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attCert, ca := makePackedAttestationCert(attKey.Public(), testAAGUID)
+	authData := as.parseTestAuthData("packed", makeAuthData("localhost", testAAGUID, testCredID, encodeEC2Key(&credKey.PublicKey, models.COSEAlgES256)), req.EncodedAttestationStatement{
+		Algorithm: models.COSEAlgES256,
+		X509Cert:  [][]byte{attCert.Raw},
+	})
+
+	entry := metadata.Entry{
+		AAGUID: "f8a011f3-8c0a-4d15-8006-17111f9edc7d",
+		MetadataStatement: metadata.MetadataStatement{
+			Description:                 "Test Authenticator",
+			AttestationRootCertificates: []string{base64.StdEncoding.EncodeToString(ca.Raw)},
+		},
+		StatusReports: []metadata.StatusReport{{Status: metadata.FidoCertified}},
+	}
+	blobPath, rootPath := writeTestMetadata(dir, []metadata.Entry{entry})
+	err = LoadMetadata(blobPath, rootPath)
+	if err != nil {
+		as.T().Fatalf("Unexpected error loading metadata: %s", err)
+	}
+
+	// The attestation root comes from the metadata statement
+	err = CheckAuthenticatorStatus(&authData)
+	if err != nil {
+		as.T().Fatalf("Unexpected error for a certified authenticator: %s", err)
+	}
+	if result := AssessAttestationTrust(&authData); result != trust.ResultBasic {
+		as.T().Fatalf("Unexpected trust with metadata roots: %s", result)
+	}
+
+	for _, status := range []metadata.AuthenticatorStatus{metadata.Revoked, metadata.UserVerificationBypass} {
+		entry.StatusReports = append(entry.StatusReports, metadata.StatusReport{Status: status})
+		blobPath, _ = writeTestMetadata(dir, []metadata.Entry{entry})
+		metadataService = nil
+		err = LoadMetadata(blobPath, filepath.Join(dir, "root.pem"))
+		if err != nil {
+			as.T().Fatalf("Unexpected error loading metadata: %s", err)
+		}
+		err = CheckAuthenticatorStatus(&authData)
+		if err == nil {
+			as.T().Fatalf("Expected an error for an authenticator with status %s", status)
+		}
+		entry.StatusReports = entry.StatusReports[:1]
+	}
+
+	// Compromised keys make the attestation untrusted
+	compromised := []metadata.AuthenticatorStatus{
+		metadata.AttestationKeyCompromise,
+		metadata.UserKeyRemoteCompromise,
+		metadata.UserKeyPhysicalCompromise,
+	}
+	for _, status := range compromised {
+		entry.StatusReports = append(entry.StatusReports, metadata.StatusReport{Status: status})
+		blobPath, _ = writeTestMetadata(dir, []metadata.Entry{entry})
+		metadataService = nil
+		err = LoadMetadata(blobPath, filepath.Join(dir, "root.pem"))
+		if err != nil {
+			as.T().Fatalf("Unexpected error loading metadata: %s", err)
+		}
+		if result := AssessAttestationTrust(&authData); result != trust.ResultUntrusted {
+			as.T().Fatalf("Unexpected trust for an authenticator with status %s: %s", status, result)
+		}
+		entry.StatusReports = entry.StatusReports[:1]
+	}
+}
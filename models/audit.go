@@ -0,0 +1,36 @@
+package models
+
+import (
+	"log"
+	"time"
+)
+
+// AuditEntry records a request that was denied
+type AuditEntry struct {
+	ID        int64     `json:"id" storm:"id,increment"`
+	CreatedAt time.Time `json:"created_at"`
+	// UserID is the logged in user that made the request, 0 if nobody was
+	UserID int64 `json:"user_id"`
+	// Action is what was attempted, like "credential.delete"
+	Action string `json:"action"`
+	// Target is the user or credential it was attempted on
+	Target     string `json:"target"`
+	RemoteAddr string `json:"remote_addr"`
+	Reason     string `json:"reason"`
+}
+
+// Audit stores an audit entry and logs it. Failing to store it is only logged
+// so the request is still denied.
+func Audit(e AuditEntry) {
+	e.CreatedAt = time.Now().UTC().Round(0)
+	log.Printf("AUDIT: user %d denied %s on %q from %s: %s\n", e.UserID, e.Action, e.Target, e.RemoteAddr, e.Reason)
+	err := db.CreateAuditEntry(&e)
+	if err != nil {
+		log.Println("Error storing audit entry:", err)
+	}
+}
+
+// GetAuditEntries returns the audit log, oldest first
+func GetAuditEntries() ([]AuditEntry, error) {
+	return db.GetAuditEntries()
+}
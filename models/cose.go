@@ -0,0 +1,384 @@
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	// The hashes COSEHash can return
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/ugorji/go/codec"
+)
+
+// COSE algorithm identifiers, as registered in the IANA COSE Algorithms registry
+const (
+	COSEAlgES256 int64 = -7
+	COSEAlgEdDSA int64 = -8
+	COSEAlgES384 int64 = -35
+	COSEAlgES512 int64 = -36
+	COSEAlgPS256 int64 = -37
+	COSEAlgPS384 int64 = -38
+	COSEAlgPS512 int64 = -39
+	COSEAlgRS256 int64 = -257
+	COSEAlgRS384 int64 = -258
+	COSEAlgRS512 int64 = -259
+	// RS1 uses SHA-1 and is only for TPM attestation statements, credentials
+	// can't use it
+	COSEAlgRS1 int64 = -65535
+)
+
+// SupportedAlgorithms are the credential algorithms we can verify, in the
+// order we prefer them when none is configured
+var SupportedAlgorithms = []int64{
+	COSEAlgES256,
+	COSEAlgEdDSA,
+	COSEAlgES384,
+	COSEAlgES512,
+	COSEAlgPS256,
+	COSEAlgRS256,
+	COSEAlgPS384,
+	COSEAlgRS384,
+	COSEAlgPS512,
+	COSEAlgRS512,
+}
+
+// coseAlgorithmNames are the names of the algorithms in the IANA registry
+var coseAlgorithmNames = map[int64]string{
+	COSEAlgES256: "ES256",
+	COSEAlgEdDSA: "EdDSA",
+	COSEAlgES384: "ES384",
+	COSEAlgES512: "ES512",
+	COSEAlgPS256: "PS256",
+	COSEAlgPS384: "PS384",
+	COSEAlgPS512: "PS512",
+	COSEAlgRS256: "RS256",
+	COSEAlgRS384: "RS384",
+	COSEAlgRS512: "RS512",
+	COSEAlgRS1:   "RS1",
+}
+
+// COSEAlgorithmName returns the name of a COSE algorithm, or its number when
+// we don't know it
+func COSEAlgorithmName(alg int64) string {
+	name, ok := coseAlgorithmNames[alg]
+	if !ok {
+		return strconv.FormatInt(alg, 10)
+	}
+	return name
+}
+
+// COSE key types and the EC2 and OKP curves
+const (
+	COSEKeyTypeOKP int64 = 1
+	COSEKeyTypeEC2 int64 = 2
+	COSEKeyTypeRSA int64 = 3
+
+	COSECurveP256    int64 = 1
+	COSECurveP384    int64 = 2
+	COSECurveP521    int64 = 3
+	COSECurveEd25519 int64 = 6
+)
+
+// COSEKeyTypeName returns the name of a COSE key type. Keys stored before
+// the key type was recorded are EC2.
+func COSEKeyTypeName(kty int64) string {
+	switch kty {
+	case 0, COSEKeyTypeEC2:
+		return "EC2"
+	case COSEKeyTypeOKP:
+		return "OKP"
+	case COSEKeyTypeRSA:
+		return "RSA"
+	}
+	return strconv.FormatInt(kty, 10)
+}
+
+// COSESignatureAlgorithm maps a COSE algorithm identifier onto the matching
+// x509.SignatureAlgorithm so it can be used with x509.Certificate.CheckSignature
+func COSESignatureAlgorithm(alg int64) x509.SignatureAlgorithm {
+	switch alg {
+	case COSEAlgES256:
+		return x509.ECDSAWithSHA256
+	case COSEAlgES384:
+		return x509.ECDSAWithSHA384
+	case COSEAlgES512:
+		return x509.ECDSAWithSHA512
+	case COSEAlgEdDSA:
+		return x509.PureEd25519
+	case COSEAlgPS256:
+		return x509.SHA256WithRSAPSS
+	case COSEAlgPS384:
+		return x509.SHA384WithRSAPSS
+	case COSEAlgPS512:
+		return x509.SHA512WithRSAPSS
+	case COSEAlgRS256:
+		return x509.SHA256WithRSA
+	case COSEAlgRS384:
+		return x509.SHA384WithRSA
+	case COSEAlgRS512:
+		return x509.SHA512WithRSA
+	case COSEAlgRS1:
+		return x509.SHA1WithRSA
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// SupportedAlgorithm reports whether alg is one of the SupportedAlgorithms
+func SupportedAlgorithm(alg int64) bool {
+	for _, supported := range SupportedAlgorithms {
+		if alg == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// COSEAlgorithmCurve returns the EC2 curve an ECDSA algorithm is defined for,
+// or 0 for the other algorithms
+func COSEAlgorithmCurve(alg int64) int64 {
+	switch alg {
+	case COSEAlgES256:
+		return COSECurveP256
+	case COSEAlgES384:
+		return COSECurveP384
+	case COSEAlgES512:
+		return COSECurveP521
+	}
+	return 0
+}
+
+// checkCurve makes sure an EC2 key is on the curve of its ECDSA algorithm.
+// Keys stored before the curve was recorded are P-256.
+func (pk PublicKey) checkCurve(alg int64) error {
+	crv := pk.Curve
+	if crv == 0 {
+		crv = COSECurveP256
+	}
+	if COSEAlgorithmCurve(alg) != crv {
+		return fmt.Errorf("Algorithm %d can't be used with EC2 curve %d", alg, crv)
+	}
+	return nil
+}
+
+// COSEHash returns the hash function used by a COSE signature algorithm,
+// including RS1 for the TPM attestation statements that need it
+func COSEHash(alg int64) crypto.Hash {
+	switch alg {
+	case COSEAlgES256, COSEAlgPS256, COSEAlgRS256:
+		return crypto.SHA256
+	case COSEAlgES384, COSEAlgPS384, COSEAlgRS384:
+		return crypto.SHA384
+	case COSEAlgES512, COSEAlgPS512, COSEAlgRS512:
+		return crypto.SHA512
+	case COSEAlgRS1:
+		return crypto.SHA1
+	}
+	return 0
+}
+
+// coseKeyHeader holds the labels that are common to every COSE_Key. The
+// remaining labels mean different things depending on the key type, so
+// the header is decoded first to find out how to decode the rest.
+type coseKeyHeader struct {
+	_struct   bool  `codec:",int"`
+	KeyType   int64 `codec:"1"`
+	Algorithm int64 `codec:"3"`
+}
+
+// coseCurveKey is a COSE_Key with kty EC2 or OKP. OKP keys have no y.
+type coseCurveKey struct {
+	_struct   bool   `codec:",int"`
+	KeyType   int64  `codec:"1"`
+	Algorithm int64  `codec:"3"`
+	Curve     int64  `codec:"-1"`
+	XCoord    []byte `codec:"-2"`
+	YCoord    []byte `codec:"-3"`
+}
+
+// coseRSAKey is a COSE_Key with kty RSA
+type coseRSAKey struct {
+	_struct   bool   `codec:",int"`
+	KeyType   int64  `codec:"1"`
+	Algorithm int64  `codec:"3"`
+	Modulus   []byte `codec:"-1"`
+	Exponent  []byte `codec:"-2"`
+}
+
+// ParseCOSEKey decodes a CBOR encoded COSE_Key, as found in the attested
+// credential data, into a PublicKey
+func ParseCOSEKey(rawKey []byte) (PublicKey, error) {
+	var handler codec.Handle = new(codec.CborHandle)
+	var header coseKeyHeader
+	err := codec.NewDecoderBytes(rawKey, handler).Decode(&header)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	switch header.KeyType {
+	case COSEKeyTypeEC2, COSEKeyTypeOKP:
+		var key coseCurveKey
+		err = codec.NewDecoderBytes(rawKey, handler).Decode(&key)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		pk := PublicKey{
+			KeyType: key.KeyType,
+			Type:    key.Algorithm,
+			Curve:   key.Curve,
+			XCoord:  key.XCoord,
+			YCoord:  key.YCoord,
+		}
+		// ES256, ES384 and ES512 each come with their own curve
+		if pk.KeyType == COSEKeyTypeEC2 && COSEAlgorithmCurve(pk.Type) != 0 {
+			err = pk.checkCurve(pk.Type)
+			if err != nil {
+				return PublicKey{}, err
+			}
+		}
+		return pk, nil
+	case COSEKeyTypeRSA:
+		var key coseRSAKey
+		err = codec.NewDecoderBytes(rawKey, handler).Decode(&key)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		return PublicKey{
+			KeyType:  key.KeyType,
+			Type:     key.Algorithm,
+			Modulus:  key.Modulus,
+			Exponent: key.Exponent,
+		}, nil
+	}
+	return PublicKey{}, fmt.Errorf("Unsupported COSE key type %d", header.KeyType)
+}
+
+// ellipticCurve returns the curve of an EC2 key. Keys stored before the curve
+// was recorded are P-256.
+func ellipticCurve(crv int64) (elliptic.Curve, error) {
+	switch crv {
+	case 0, COSECurveP256:
+		return elliptic.P256(), nil
+	case COSECurveP384:
+		return elliptic.P384(), nil
+	case COSECurveP521:
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("Unsupported EC2 curve %d", crv)
+}
+
+// CryptoPublicKey converts the key into an *ecdsa.PublicKey, an
+// ed25519.PublicKey or an *rsa.PublicKey depending on its key type
+func (pk PublicKey) CryptoPublicKey() (crypto.PublicKey, error) {
+	switch pk.KeyType {
+	// Keys stored before the key type was recorded are EC2
+	case 0, COSEKeyTypeEC2:
+		ecKey, err := FormatPublicKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		return &ecKey, nil
+	case COSEKeyTypeOKP:
+		if pk.Curve != COSECurveEd25519 {
+			return nil, fmt.Errorf("Unsupported OKP curve %d", pk.Curve)
+		}
+		if len(pk.XCoord) != ed25519.PublicKeySize {
+			return nil, errors.New("Ed25519 public key is not 32 bytes long")
+		}
+		return ed25519.PublicKey(pk.XCoord), nil
+	case COSEKeyTypeRSA:
+		exponent := new(big.Int).SetBytes(pk.Exponent)
+		if len(pk.Modulus) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA public key is malformed")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(pk.Modulus),
+			E: int(exponent.Int64()),
+		}, nil
+	}
+	return nil, fmt.Errorf("Unsupported COSE key type %d", pk.KeyType)
+}
+
+// Algorithm returns the COSE algorithm the key signs with. Keys stored before
+// the algorithm was recorded are ES256.
+func (pk PublicKey) Algorithm() int64 {
+	if pk.Type == 0 && pk.KeyType == 0 {
+		return COSEAlgES256
+	}
+	return pk.Type
+}
+
+// Verify checks that signature is a valid signature over data, made with the
+// private half of the key using the key's algorithm. Only the
+// SupportedAlgorithms are accepted.
+func (pk PublicKey) Verify(signature, data []byte) (bool, error) {
+	alg := pk.Algorithm()
+	if !SupportedAlgorithm(alg) {
+		return false, fmt.Errorf("Unsupported credential algorithm %d", alg)
+	}
+
+	pub, err := pk.CryptoPublicKey()
+	if err != nil {
+		return false, err
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if COSEAlgorithmCurve(alg) == 0 {
+			return false, fmt.Errorf("Algorithm %d can't be used with an EC2 key", alg)
+		}
+		err = pk.checkCurve(alg)
+		if err != nil {
+			return false, err
+		}
+		h := COSEHash(alg).New()
+		h.Write(data)
+		return ecdsa.VerifyASN1(pub, h.Sum(nil), signature), nil
+	case ed25519.PublicKey:
+		if alg != COSEAlgEdDSA {
+			return false, fmt.Errorf("Algorithm %d can't be used with an OKP key", alg)
+		}
+		return ed25519.Verify(pub, data, signature), nil
+	case *rsa.PublicKey:
+		hash := COSEHash(alg)
+		if hash == 0 {
+			return false, fmt.Errorf("Algorithm %d can't be used with an RSA key", alg)
+		}
+		h := hash.New()
+		h.Write(data)
+		switch alg {
+		case COSEAlgPS256, COSEAlgPS384, COSEAlgPS512:
+			err = rsa.VerifyPSS(pub, hash, h.Sum(nil), signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			err = rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature)
+		}
+		return err == nil, nil
+	}
+	return false, errors.New("Unsupported public key")
+}
+
+// Matches reports whether pub, typically taken from a certificate, is the same key
+func (pk PublicKey) Matches(pub crypto.PublicKey) bool {
+	own, err := pk.CryptoPublicKey()
+	if err != nil {
+		return false
+	}
+	switch own := own.(type) {
+	case *ecdsa.PublicKey:
+		return own.Equal(pub)
+	case ed25519.PublicKey:
+		return own.Equal(pub)
+	case *rsa.PublicKey:
+		return own.Equal(pub)
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"log"
+)
+
+// Signature counter policies decide what happens when the signature counter of
+// a credential doesn't move forward, which is a sign the authenticator may
+// have been cloned. They are set per Relying Party.
+const (
+	// CounterPolicyReject fails the assertion and marks the credential as suspect
+	CounterPolicyReject = "reject"
+	// CounterPolicySuspect allows the assertion but marks the credential as suspect
+	CounterPolicySuspect = "suspect"
+	// CounterPolicyAllow allows the assertion and only logs the regression
+	CounterPolicyAllow = "allow"
+)
+
+// ErrCounterRegression is returned when an assertion is rejected because its
+// signature counter is not greater than the stored one
+var ErrCounterRegression = errors.New("Signature counter did not increase, the authenticator may be cloned")
+
+// ErrInvalidCounterPolicy is returned when a Relying Party has an unknown counter policy
+var ErrInvalidCounterPolicy = errors.New("Counter policy needs to be 'reject', 'suspect' or 'allow'")
+
+// ValidCounterPolicy reports whether policy is one of the counter policies.
+// An empty policy is valid and means CounterPolicyReject.
+func ValidCounterPolicy(policy string) bool {
+	switch policy {
+	case "", CounterPolicyReject, CounterPolicySuspect, CounterPolicyAllow:
+		return true
+	}
+	return false
+}
+
+// SignCountValid reports whether the signature counter from an assertion is
+// acceptable given the one stored with the credential. Authenticators that
+// don't implement a counter always send zero, so a counter that stays at
+// zero is fine, anything else has to be greater than the stored value.
+func SignCountValid(stored, received uint32) bool {
+	if stored == 0 && received == 0 {
+		return true
+	}
+	return received > stored
+}
+
+// UpdateCredentialCounter stores the signature counter from a verified
+// assertion. The stored counter is read, compared and written in a single
+// transaction so two concurrent assertions can't both move it forward. When
+// the counter regressed, policy decides the outcome and c is updated with
+// what was stored.
+func UpdateCredentialCounter(c *Credential, received uint32, policy string) error {
+	var regressed error
+	err := db.UpdateCredential(c.ID, func(stored *Credential) error {
+		regressed = nil
+		if SignCountValid(stored.Counter, received) {
+			stored.Counter = received
+		} else {
+			log.Printf("Signature counter for credential %d went from %d to %d, the authenticator may be cloned\n",
+				stored.ID, stored.Counter, received)
+			switch policy {
+			case CounterPolicyAllow:
+				// Keep the highest counter we've seen
+			case CounterPolicySuspect:
+				stored.Suspect = true
+			default:
+				stored.Suspect = true
+				regressed = ErrCounterRegression
+			}
+		}
+		c.Counter = stored.Counter
+		c.Suspect = stored.Suspect
+		return nil
+	})
+	if err != nil {
+		log.Println("UpdateCredentialCounter error:", err)
+		return err
+	}
+	return regressed
+}
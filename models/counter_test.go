@@ -0,0 +1,88 @@
+package models
+
+import "encoding/json"
+
+func (ms *ModelsSuite) TestSignCountValid() {
+	cases := []struct {
+		stored, received uint32
+		valid            bool
+	}{
+		{0, 0, true},
+		{0, 1, true},
+		{5, 6, true},
+		{5, 5, false},
+		{5, 4, false},
+		{5, 0, false},
+	}
+	for _, c := range cases {
+		if SignCountValid(c.stored, c.received) != c.valid {
+			ms.T().Fatalf("Unexpected result for stored %d, received %d. Expected: %t", c.stored, c.received, c.valid)
+		}
+	}
+}
+
+func (ms *ModelsSuite) TestUnmarshalLegacyCounter() {
+	// Credentials used to store the counter as its big-endian bytes
+	var c Credential
+	err := json.Unmarshal([]byte(`{"id":3,"sign_count":"AAABAg=="}`), &c)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error unmarshaling legacy credential: %s", err)
+	}
+	if c.ID != 3 || c.Counter != 258 {
+		ms.T().Fatalf("Unexpected credential. Got: %#v", c)
+	}
+
+	err = json.Unmarshal([]byte(`{"id":3,"sign_count":259}`), &c)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error unmarshaling credential: %s", err)
+	}
+	if c.Counter != 259 {
+		ms.T().Fatalf("Unexpected counter. Expected: %d, Got: %d", 259, c.Counter)
+	}
+}
+
+func (ms *ModelsSuite) TestUpdateCredentialCounter() {
+	u, err := GetUser(1)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error getting user by ID: %s", err)
+	}
+	rp, err := GetDefaultRelyingParty()
+	if err != nil {
+		ms.T().Fatalf("Unexpected error getting relying parties: %s", err)
+	}
+	c, err := createCredentialsForUserAndRelyingParty(u, rp)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error when creating credentials: %s", err)
+	}
+
+	// Authenticators without a counter always send zero
+	err = UpdateCredentialCounter(c, 0, CounterPolicyReject)
+	if err != nil || c.Suspect {
+		ms.T().Fatalf("Unexpected result for an unsupported counter: %s", err)
+	}
+
+	err = UpdateCredentialCounter(c, 10, CounterPolicyReject)
+	if err != nil || c.Counter != 10 {
+		ms.T().Fatalf("Unexpected result moving the counter forward: %s", err)
+	}
+
+	err = UpdateCredentialCounter(c, 10, CounterPolicyAllow)
+	if err != nil || c.Counter != 10 || c.Suspect {
+		ms.T().Fatalf("Unexpected result for an allowed regression: %s", err)
+	}
+
+	err = UpdateCredentialCounter(c, 9, CounterPolicySuspect)
+	if err != nil || c.Counter != 10 || !c.Suspect {
+		ms.T().Fatalf("Unexpected result for a suspect regression: %s", err)
+	}
+
+	err = UpdateCredentialCounter(c, 10, CounterPolicyReject)
+	if err != ErrCounterRegression {
+		ms.T().Fatalf("Unexpected error for a rejected regression. Expected: %s, Got: %s", ErrCounterRegression, err)
+	}
+
+	err = PutRelyingParty(&RelyingParty{ID: "example.com", CounterPolicy: "sometimes"})
+	if err != ErrInvalidCounterPolicy {
+		ms.T().Fatalf("Unexpected error for an invalid counter policy: %s", err)
+	}
+}
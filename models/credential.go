@@ -3,251 +3,266 @@ package models
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"time"
-
-	"github.com/asdine/storm"
-
-	"github.com/asdine/storm/q"
 )
 
 // Credential is the stored credential for Auth
 type Credential struct {
-	ID        int64 `json:"id"`
+	ID        int64 `json:"id" storm:"id,increment"`
 	CreatedAt time.Time
-	Counter   []byte `json:"sign_count"`
+	// Counter is the signature counter from the last successful assertion
+	Counter uint32 `json:"sign_count"`
+	// Suspect is set when the signature counter went backwards, which means
+	// the authenticator may have been cloned
+	Suspect bool `json:"suspect,omitempty"`
 
 	RelyingParty   RelyingParty `json:"rp" storm:"inline"`
 	RelyingPartyID string       `json:"rp_id"`
 
 	User   User  `json:"user" storm:"inline"`
-	UserID int64 `json:"user_id"`
+	UserID int64 `json:"user_id" storm:"index"`
+
+	// UserRelyingParty indexes credentials by (UserID, RelyingPartyID)
+	UserRelyingParty string `json:"user_rp" storm:"index"`
 
 	Type   string `json:"type,omitempty"`
 	Format string `json:"format,omitempty"`
 	Flags  []byte `json:"flags,omitempty"`
 
-	CredID string `json:"credential_id,omitempty"`
+	// CredID is unique across all users as the spec requires
+	CredID string `json:"credential_id,omitempty" storm:"unique"`
+
+	// AttestationTrust is the attestation trust result from registration,
+	// one of none, self, basic, attca or untrusted
+	AttestationTrust string `json:"attestation_trust,omitempty"`
 
 	PublicKey PublicKey `json:"public_key,omitempty" storm:"inline"`
 }
 
-// PublicKey is parsed from the credential creation response
+// PublicKey is parsed from the COSE_Key in the credential creation response.
+// Which fields are set depends on KeyType, see ParseCOSEKey.
 type PublicKey struct {
-	_struct      bool   `codec:",int"`
-	KeyType      int8   `codec:"1"`
-	Type         int8   `codec:"3"`
-	XCoord       []byte `codec:"-2"`
-	YCoord       []byte `codec:"-3"`
-	Curve        int8   `codec:"-1"`
-	CredentialID int64  `codec:"-,omitempty"`
+	KeyType int64
+	// Type is the COSE algorithm the key signs with
+	Type int64
+	// Curve and XCoord are set for EC2 and OKP keys, YCoord only for EC2 keys
+	Curve  int64
+	XCoord []byte
+	YCoord []byte
+	// Modulus and Exponent are set for RSA keys
+	Modulus      []byte
+	Exponent     []byte
+	CredentialID int64
 }
 
-// CreateCredential creates a new credential object
+// ErrCredentialExists is thrown when an authenticator registers a credential ID
+// that is already registered, to this or any other user.
+var ErrCredentialExists = errors.New("Credential is already registered")
+
+// ErrMissingCredentialID is thrown when creating a credential without a credential ID
+var ErrMissingCredentialID = errors.New("Credential is missing its credential ID")
+
+// CreateCredential creates a new credential object. A user can have any number
+// of credentials for a relying party, but a credential ID can only be registered once.
 func CreateCredential(c *Credential) error {
-	if c.ID == 0 {
-		log.Println("Bumping ID up to 1")
-		c.ID = 1
-	}
 	fmt.Println("Creating Credential")
-	_, err := GetCredentialForUserAndRelyingParty(&c.User, &c.RelyingParty)
-	if err == nil {
-		log.Println("CreateCredential error: credentials already exist")
-		return errors.New("Credentials already exist")
+	if c.CredID == "" {
+		return ErrMissingCredentialID
 	}
-	if err != storm.ErrNotFound {
-		log.Println("CreateCredential other error:", err)
-		return err
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
 	}
 
 	log.Println("NEW credential:", c)
 
-	creds := db.From("credentials")
-
-	err = creds.Save(c)
+	err := db.CreateCredential(c)
+	if err == ErrCredentialExists {
+		log.Println("CreateCredential error: credential ID already registered")
+		return err
+	}
 	if err != nil {
-		log.Println("CreateCredential/db.Save error:", err)
+		log.Println("CreateCredential error:", err)
 		return err
 	}
 
 	return err
 }
 
+// UnmarshalJSON also reads credentials stored while the signature counter was
+// kept as its raw big-endian bytes, which JSON encodes as a base64 string.
+func (c *Credential) UnmarshalJSON(data []byte) error {
+	type credential Credential
+	aux := struct {
+		*credential
+		Counter json.RawMessage `json:"sign_count"`
+	}{credential: (*credential)(c)}
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+	c.Counter = 0
+	if len(aux.Counter) == 0 || string(aux.Counter) == "null" {
+		return nil
+	}
+	if aux.Counter[0] != '"' {
+		return json.Unmarshal(aux.Counter, &c.Counter)
+	}
+	var legacy []byte
+	err = json.Unmarshal(aux.Counter, &legacy)
+	if err != nil {
+		return err
+	}
+	if len(legacy) > 4 {
+		return errors.New("Stored signature counter is longer than 4 bytes")
+	}
+	for _, b := range legacy {
+		c.Counter = c.Counter<<8 | uint32(b)
+	}
+	return nil
+}
+
 // UpdateCredential updates the credential with new attributes.
 func UpdateCredential(c *Credential) error {
-	if c.ID == 0 {
-		log.Println("Bumping ID up to 1")
-		c.ID = 1
-	}
 	log.Println("UPDATE credential:", c)
-	creds := db.From("credentials")
-	err = creds.Save(c)
-	return err
+	return db.UpdateCredential(c.ID, func(stored *Credential) error {
+		*stored = *c
+		return nil
+	})
+}
+
+// userRelyingPartyKey is the value of the (UserID, RelyingPartyID) index
+func userRelyingPartyKey(userID int64, rpID string) string {
+	return fmt.Sprintf("%d/%s", userID, rpID)
+}
+
+// GetCredentialByCredID retrieves the credential with a credential ID from the unique index.
+func GetCredentialByCredID(credentialID string) (Credential, error) {
+	return db.GetCredentialByCredID(credentialID)
 }
 
 // GetCredentialForUserAndRelyingParty retrieves the first credential for a provided user and relying party.
 func GetCredentialForUserAndRelyingParty(user *User, rp *RelyingParty) (Credential, error) {
-	cred := Credential{}
-	//err := db.Where("user_id = ? AND relying_party_id = ?", user.ID, rp.ID).Preload("PublicKey").First(&cred).Error
-	creds := db.From("credentials")
-	err := creds.Select(q.Eq("UserID", user.ID), q.Eq("RelyingPartyID", rp.ID)).First(&cred)
-	if err == storm.ErrNotFound {
-		return Credential{}, err
-	}
+	creds, err := db.GetCredentialsForUserAndRelyingParty(user.ID, rp.ID)
 	if err != nil {
 		log.Println("GetCredentialForUserAndRelyingParty err:", err)
 		return Credential{}, err
 	}
+	if len(creds) == 0 {
+		return Credential{}, ErrNotFound
+	}
+	cred := creds[0]
 	cred.User = *user
 	cred.RelyingParty = *rp
 
-	return cred, err
+	return cred, nil
 }
 
 // GetCredentialsForUserAndRelyingParty retrieves all credentials for a provided user for a relying party.
 func GetCredentialsForUserAndRelyingParty(user *User, rp *RelyingParty) ([]Credential, error) {
-	creds := []Credential{}
-	//err := db.Where("user_id = ? AND relying_party_id = ?", user.ID, rp.ID).Preload("PublicKey").Find(&creds).Error
-	credsDB := db.From("credentials")
-	err := credsDB.All(&creds)
-	//err := credsDB.Select(q.Eq("UserID", user.ID), q.Eq("RelyingPartyID", rp.ID)).Find(&creds)
+	creds, err := db.GetCredentialsForUserAndRelyingParty(user.ID, rp.ID)
 	if err != nil {
 		log.Println("GetCredentialsForUserAndRelyingParty err:", err)
 		return []Credential{}, err
 	}
-
-	for _, cred := range creds {
-		if user.ID == cred.User.ID && rp.ID == cred.RelyingPartyID {
-			creds = append(creds, cred)
-		}
-	}
-
 	return creds, nil
 }
 
 // GetCredentialsForUser retrieves all credentials for a provided user regardless of relying party.
 func GetCredentialsForUser(user *User) ([]Credential, error) {
-	creds := []Credential{}
-	//err := db.Where("user_id = ?", user.ID).Preload("PublicKey").Find(&creds).Error
-	credsDB := db.From("credentials")
-	err := credsDB.Find("UserID", user.ID, &creds)
-	return creds, err
+	return db.GetCredentialsForUser(user.ID)
 }
 
-// GetCredentialForUser retrieves a specific credential for a user.
+// GetCredentialForUser retrieves a specific credential for a user. A credential
+// that belongs to another user is not found.
 func GetCredentialForUser(user *User, credentialID string) (Credential, error) {
-	cred := Credential{}
-	//err := db.Where("user_id = ? AND cred_id = ?", user.ID, credentialID).Preload("PublicKey").Find(&cred).Error
-	credsDB := db.From("credentials")
-	//err := credsDB.Select(q.Eq("UserID", user.ID), q.Eq("CredID", credentialID)).First(&cred)
-	creds := []Credential{}
-	err := credsDB.Find("CredID", credentialID, &creds)
-	for _, v := range creds {
-		log.Println("CREDS:", v.PublicKey)
+	cred, err := GetCredentialByCredID(credentialID)
+	if err != nil {
+		return Credential{}, err
+	}
+	if cred.UserID != user.ID {
+		return Credential{}, ErrNotFound
 	}
-	return cred, err
+	return cred, nil
 }
 
-// DeleteCredentialByID gets a credential by its ID. In practice, this would be a bad function without
-// some other checks (like what user is logged in) because someone could hypothetically delete ANY credential.
+// DeleteCredentialByID deletes a credential by its credential ID. It doesn't check who
+// the credential belongs to, callers have to make sure the logged in user may delete it.
 func DeleteCredentialByID(credentialID string) error {
-	//return db.Where("cred_id = ?", credentialID).Delete(&Credential{}).Error
-	credsDB := db.From("credentials")
-	return credsDB.DeleteStruct(&Credential{CredID: credentialID})
+	cred, err := GetCredentialByCredID(credentialID)
+	if err != nil {
+		return err
+	}
+	return DeleteCredential(&cred)
+}
+
+// DeleteCredential deletes a credential
+func DeleteCredential(c *Credential) error {
+	return db.DeleteCredential(c.ID)
 }
 
 // GetUnformattedPublicKeyForCredential gives you the raw PublicKey model for a credential
 func GetUnformattedPublicKeyForCredential(c *Credential) (PublicKey, error) {
-	/*
-		var cred Credential
-		credsDB := db.From("credentials")
-		err := credsDB.One("CredID", &c.CredID, &cred)
-		if err != nil {
-			log.Println("Error getting pubkey:", c.ID, c.CredID, err)
-		}
-		log.Println("PubKey:", cred.PublicKey)
-	*/
-	var cred Credential
-	creds := []Credential{}
-	credsDB := db.From("credentials")
-	/*
-		err := credsDB.One("ID", &c.ID, &cred)
-		if err != nil {
-			log.Println("Error getting pubkey:", err)
-		}
-	*/
-	err := credsDB.All(&creds)
-	//err := credsDB.Select(q.Eq("UserID", user.ID), q.Eq("RelyingPartyID", rp.ID)).Find(&creds)
+	cred, err := db.GetCredential(c.ID)
 	if err != nil {
-		log.Println("credsDB.All err:", err)
+		log.Println("Error getting pubkey:", c.ID, err)
 		return PublicKey{}, err
 	}
-
-	for _, v := range creds {
-		log.Println("CRED!!!:", v)
-		if v.ID == c.ID {
-			cred = v
-		}
-	}
-	log.Println("PubKey:", cred.PublicKey)
-	return cred.PublicKey, err
+	return cred.PublicKey, nil
 }
 
 // GetPublicKeyForCredential gets the formatted `models.PublicKey` for a provided credential
 func GetPublicKeyForCredential(c *Credential) (ecdsa.PublicKey, error) {
-	var cred Credential
-	creds := []Credential{}
-	credsDB := db.From("credentials")
-	/*
-		err := credsDB.One("ID", &c.ID, &cred)
-		if err != nil {
-			log.Println("Error getting pubkey:", err)
-		}
-	*/
-	err := credsDB.All(&creds)
-	//err := credsDB.Select(q.Eq("UserID", user.ID), q.Eq("RelyingPartyID", rp.ID)).Find(&creds)
+	pk, err := GetUnformattedPublicKeyForCredential(c)
 	if err != nil {
-		log.Println("credsDB.All err:", err)
 		return ecdsa.PublicKey{}, err
 	}
-
-	for _, v := range creds {
-		log.Println("CRED!!!:", v)
-		if v.ID == cred.ID {
-			cred = v
-		}
-	}
-	log.Println("PubKey:", cred.PublicKey)
-	return FormatPublicKey(cred.PublicKey)
+	return FormatPublicKey(pk)
 }
 
-// FormatPublicKey formats a `models.PublicKey` into an `ecdsa.PublicKey`
+// FormatPublicKey formats an EC2 `models.PublicKey` into an `ecdsa.PublicKey`
 func FormatPublicKey(pk PublicKey) (ecdsa.PublicKey, error) {
+	curve, err := ellipticCurve(pk.Curve)
+	if err != nil {
+		return ecdsa.PublicKey{}, err
+	}
 	ecPoint, err := AssembleUncompressedECPoint(pk.XCoord, pk.YCoord)
 	if err != nil {
 		return ecdsa.PublicKey{}, err
 	}
-	xInt, yInt := elliptic.Unmarshal(elliptic.P256(), ecPoint)
+	if len(ecPoint) != 1+2*((curve.Params().BitSize+7)/8) {
+		err := errors.New("Coordinates are the wrong length for the curve")
+		return ecdsa.PublicKey{}, err
+	}
+	xInt, yInt := elliptic.Unmarshal(curve, ecPoint)
+	if xInt == nil {
+		err := errors.New("Coordinates are not a point on the curve")
+		return ecdsa.PublicKey{}, err
+	}
 	return ecdsa.PublicKey{
-		Curve: elliptic.P256(),
+		Curve: curve,
 		X:     xInt,
 		Y:     yInt,
 	}, err
 }
 
 // AssembleUncompressedECPoint will properly format the EC coordinates into
+// an uncompressed point. The coordinates are 32, 48 or 66 bytes long for
+// P-256, P-384 and P-521.
 func AssembleUncompressedECPoint(xCoord []byte, yCoord []byte) ([]byte, error) {
-	point := make([]byte, 65)
-	if len(xCoord) != 32 || len(yCoord) != 32 {
+	size := len(xCoord)
+	if (size != 32 && size != 48 && size != 66) || len(yCoord) != size {
 		fmt.Println("X coord byte length : ", len(xCoord))
 		fmt.Println("Y coord byte length : ", len(yCoord))
-		err := errors.New("Coordinates are not 32 bytes long")
-		return point, err
+		err := errors.New("Coordinates are not 32, 48 or 66 bytes long")
+		return make([]byte, 65), err
 	}
+	point := make([]byte, 1+2*size)
 	point[0] = 0x04
-	copy(point[1:33], xCoord)
-	copy(point[33:], yCoord)
+	copy(point[1:1+size], xCoord)
+	copy(point[1+size:], yCoord)
 	return point, nil
 }
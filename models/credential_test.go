@@ -3,8 +3,11 @@ package models
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"math/big"
+	"path/filepath"
 	"reflect"
+	"testing"
 )
 
 func generateTestCodePoint() (x, y []byte) {
@@ -15,8 +18,14 @@ func generateTestCodePoint() (x, y []byte) {
 }
 
 func createCredentialsForUserAndRelyingParty(u User, rp RelyingParty) (*Credential, error) {
+	credID, _ := CreateChallenge(16)
+	return createCredentialWithID(u, rp, hex.EncodeToString(credID))
+}
+
+func createCredentialWithID(u User, rp RelyingParty, credID string) (*Credential, error) {
 	x, y := generateTestCodePoint()
 	c := &Credential{
+		CredID:         credID,
 		User:           u,
 		UserID:         u.ID,
 		RelyingParty:   rp,
@@ -150,21 +159,34 @@ func (ms *ModelsSuite) TestCreateCredential() {
 		ms.T().Fatalf("Unexpected credential received. Got: %#v", cs)
 	}
 
-	// Now we'll check about adding double credentials
+	// A backup key is a second credential for the same user and relying party
 	_, err = createCredentialsForUserAndRelyingParty(u, rp)
 	if err != nil {
-		ms.T().Fatalf("Error when adding already existing credentials: %s", err)
+		ms.T().Fatalf("Unexpected error when adding a second credential: %s", err)
 	}
 
-	cs, err = GetCredentialsForUser(&u)
+	cs, err = GetCredentialsForUserAndRelyingParty(&u, &rp)
 	if err != nil {
-		ms.T().Fatalf("Unexpected error getting relying parties: %s", err)
+		ms.T().Fatalf("Unexpected error getting credentials: %s", err)
 	}
-	if len(cs) != 1 {
-		ms.T().Fatalf("Unexpected credentials received: Expected: %d, Got %d", 1, len(cs))
+	if len(cs) != 2 {
+		ms.T().Fatalf("Unexpected credentials received: Expected: %d, Got %d", 2, len(cs))
 	}
-	if cs[0].UserID != u.ID || cs[0].RelyingPartyID != rp.ID {
-		ms.T().Fatalf("Unexpected credential received. Got: %#v", cs)
+	for _, c := range cs {
+		if c.UserID != u.ID || c.RelyingPartyID != rp.ID {
+			ms.T().Fatalf("Unexpected credential received. Got: %#v", cs)
+		}
+	}
+
+	// The same authenticator can't be registered twice, not even by another user
+	other := User{Name: "other@example.com", DisplayName: "other"}
+	err = PutUser(&other)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating user: %s", err)
+	}
+	_, err = createCredentialWithID(other, rp, cs[0].CredID)
+	if err != ErrCredentialExists {
+		ms.T().Fatalf("Unexpected error adding an existing credential. Expected: %s, Got: %v", ErrCredentialExists, err)
 	}
 }
 
@@ -215,3 +237,96 @@ func (ms *ModelsSuite) TestAssembleUncompressedECPoint() {
 		ms.T().Fatalf("Received nil error. Expected coordinate length error")
 	}
 }
+
+// benchmarkCredentials is how many credentials the lookup benchmarks store
+const benchmarkCredentials = 100000
+
+// seedBenchmarkCredentials swaps in a fresh storm database holding n
+// credentials spread over n/10 users and returns a func that puts the old
+// one back.
+func seedBenchmarkCredentials(b *testing.B, n int) (*StormStore, func()) {
+	prev := db
+	bench, err := OpenStormStore(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatalf("Failed creating database: %v", err)
+	}
+	db = bench
+
+	// One transaction, saving them one by one takes ages
+	tx, err := bench.db.From("credentials").Begin(true)
+	if err != nil {
+		b.Fatalf("Unexpected error starting transaction: %s", err)
+	}
+	for i := 0; i < n; i++ {
+		c := Credential{
+			CredID:         fmt.Sprintf("cred-%d", i),
+			UserID:         int64(i%(n/10)) + 1,
+			RelyingPartyID: "localhost",
+		}
+		c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+		err = tx.Save(&c)
+		if err != nil {
+			b.Fatalf("Unexpected error saving credential: %s", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		b.Fatalf("Unexpected error committing credentials: %s", err)
+	}
+
+	return bench, func() {
+		bench.Close()
+		db = prev
+	}
+}
+
+func BenchmarkGetCredentialForUser(b *testing.B) {
+	_, done := seedBenchmarkCredentials(b, benchmarkCredentials)
+	defer done()
+	user := User{ID: 42}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := GetCredentialForUser(&user, "cred-41")
+		if err != nil {
+			b.Fatalf("Unexpected error getting credential: %s", err)
+		}
+	}
+}
+
+func BenchmarkGetCredentialsForUserAndRelyingParty(b *testing.B) {
+	_, done := seedBenchmarkCredentials(b, benchmarkCredentials)
+	defer done()
+	user := User{ID: 42}
+	rp := RelyingParty{ID: "localhost"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		creds, err := GetCredentialsForUserAndRelyingParty(&user, &rp)
+		if err != nil || len(creds) != 10 {
+			b.Fatalf("Unexpected credentials received: %d, %v", len(creds), err)
+		}
+	}
+}
+
+// BenchmarkScanCredentials finds a credential the way we did before the
+// indexes, by loading every credential, for comparison.
+func BenchmarkScanCredentials(b *testing.B) {
+	bench, done := seedBenchmarkCredentials(b, benchmarkCredentials)
+	defer done()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		creds := []Credential{}
+		err := bench.db.From("credentials").All(&creds)
+		if err != nil {
+			b.Fatalf("Unexpected error loading credentials: %s", err)
+		}
+		found := false
+		for _, c := range creds {
+			if c.CredID == "cred-41" {
+				found = true
+			}
+		}
+		if !found {
+			b.Fatalf("Credential not found")
+		}
+	}
+}
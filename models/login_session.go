@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// DefaultLoginSessionLifetime is how long a user stays logged in when no
+// lifetime is configured
+const DefaultLoginSessionLifetime = 24 * time.Hour
+
+// LoginSession is a logged in user. It is created after a successful
+// ceremony and the browser keeps its Token in a signed cookie.
+type LoginSession struct {
+	ID int64 `json:"id" storm:"id,increment"`
+	// Token identifies the session in the cookie, it is never shown in the API
+	Token  string `json:"token" storm:"unique"`
+	UserID int64  `json:"user_id" storm:"index"`
+
+	// The credential the user authenticated with
+	CredentialID   int64  `json:"credential_id"`
+	CredID         string `json:"cred_id"`
+	RelyingPartyID string `json:"rp_id"`
+
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the user has to log in again
+func (ls *LoginSession) Expired(now time.Time) bool {
+	return !now.Before(ls.ExpiresAt)
+}
+
+// newLoginSessionToken creates the random token of a login session
+func newLoginSessionToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// CreateLoginSession logs a user in with a credential for lifetime
+func CreateLoginSession(u *User, c *Credential, lifetime time.Duration) (LoginSession, error) {
+	token, err := newLoginSessionToken()
+	if err != nil {
+		log.Println("Error creating login session token:", err)
+		return LoginSession{}, err
+	}
+	ls := LoginSession{
+		Token:          token,
+		UserID:         u.ID,
+		CredentialID:   c.ID,
+		CredID:         c.CredID,
+		RelyingPartyID: c.RelyingPartyID,
+	}
+	ls.CreatedAt = time.Now().UTC().Round(0)
+	ls.ExpiresAt = ls.CreatedAt.Add(lifetime)
+
+	err = db.CreateLoginSession(&ls)
+	if err != nil {
+		log.Println("CreateLoginSession error:", err)
+		return LoginSession{}, err
+	}
+	return ls, nil
+}
+
+// GetLoginSessionByToken returns the login session for a cookie token. It
+// returns ErrNotFound when there is no such session or it expired.
+func GetLoginSessionByToken(token string) (LoginSession, error) {
+	ls, err := db.GetLoginSessionByToken(token)
+	if err != nil {
+		return ls, err
+	}
+	if ls.Expired(time.Now()) {
+		return LoginSession{}, ErrNotFound
+	}
+	return ls, nil
+}
+
+// GetLoginSessionsForUser returns the login sessions of a user that haven't
+// expired, oldest first
+func GetLoginSessionsForUser(u *User) ([]LoginSession, error) {
+	all, err := db.GetLoginSessionsForUser(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions := []LoginSession{}
+	for _, ls := range all {
+		if !ls.Expired(now) {
+			sessions = append(sessions, ls)
+		}
+	}
+	return sessions, nil
+}
+
+// DeleteLoginSession logs out the login session with the given ID
+func DeleteLoginSession(id int64) error {
+	return db.DeleteLoginSession(id)
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+)
+
+func (ms *ModelsSuite) TestLoginSessions() {
+	u, rp := ms.getUserAndRelyingParty()
+	c := Credential{CredID: "login", UserID: u.ID, RelyingPartyID: rp.ID}
+	err := CreateCredential(&c)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating credential: %s", err)
+	}
+
+	first, err := CreateLoginSession(u, &c, DefaultLoginSessionLifetime)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating login session: %s", err)
+	}
+	second, err := CreateLoginSession(u, &c, DefaultLoginSessionLifetime)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating login session: %s", err)
+	}
+	if first.Token == "" || first.Token == second.Token {
+		ms.T().Fatalf("Login sessions need different tokens, got %q and %q", first.Token, second.Token)
+	}
+	expired, err := CreateLoginSession(u, &c, -time.Second)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating login session: %s", err)
+	}
+
+	got, err := GetLoginSessionByToken(first.Token)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error getting login session: %s", err)
+	}
+	if got.ID != first.ID || got.UserID != u.ID || got.CredID != c.CredID || !got.CreatedAt.Equal(first.CreatedAt) {
+		ms.T().Fatalf("Unexpected login session received.\nExpected %#v\nGot %#v", first, got)
+	}
+	_, err = GetLoginSessionByToken(expired.Token)
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error getting expired login session: %v", err)
+	}
+
+	sessions, err := GetLoginSessionsForUser(u)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error listing login sessions: %s", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != first.ID || sessions[1].ID != second.ID {
+		ms.T().Fatalf("Unexpected login sessions received: %#v", sessions)
+	}
+
+	err = DeleteLoginSession(first.ID)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error deleting login session: %s", err)
+	}
+	_, err = GetLoginSessionByToken(first.Token)
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error getting deleted login session: %v", err)
+	}
+
+	deleted, err := DeleteExpiredSessions()
+	if err != nil {
+		ms.T().Fatalf("Unexpected error deleting expired sessions: %s", err)
+	}
+	if deleted != 1 {
+		ms.T().Fatalf("Unexpected number of sessions deleted. Expected 1, Got %d", deleted)
+	}
+}
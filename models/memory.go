@@ -0,0 +1,379 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in maps. Nothing is persisted, it's meant for
+// tests and trying things out.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users         map[int64]User
+	rps           map[string]RelyingParty
+	credentials   map[int64]Credential
+	credentialIDs map[string]int64
+	sessions      map[int64]SessionData
+	logins        map[int64]LoginSession
+	audit         []AuditEntry
+
+	lastUserID       int64
+	lastCredentialID int64
+	lastSessionID    int64
+	lastLoginID      int64
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[int64]User{},
+		rps:           map[string]RelyingParty{},
+		credentials:   map[int64]Credential{},
+		credentialIDs: map[string]int64{},
+		sessions:      map[int64]SessionData{},
+		logins:        map[int64]LoginSession{},
+	}
+}
+
+// GetUser gets a user by ID
+func (s *MemoryStore) GetUser(id int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+// GetUserByUsername gets a user by name
+func (s *MemoryStore) GetUserByUsername(name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+// GetUserByHandle gets a user by user handle
+func (s *MemoryStore) GetUserByHandle(handle []byte) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if string(u.Handle) == string(handle) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+// PutUser creates or updates a user
+func (s *MemoryStore) PutUser(u *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.users {
+		if other.Name == u.Name && other.ID != u.ID {
+			return ErrUsernameTaken
+		}
+	}
+	if u.ID == 0 {
+		s.lastUserID++
+		u.ID = s.lastUserID
+	} else if u.ID > s.lastUserID {
+		s.lastUserID = u.ID
+	}
+	s.users[u.ID] = *u
+	return nil
+}
+
+// GetRelyingParty gets a relying party by ID
+func (s *MemoryStore) GetRelyingParty(id string) (RelyingParty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rp, ok := s.rps[id]
+	if !ok {
+		return RelyingParty{}, ErrNotFound
+	}
+	return rp, nil
+}
+
+// PutRelyingParty creates or updates a relying party
+func (s *MemoryStore) PutRelyingParty(rp *RelyingParty) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rps[rp.ID] = *rp
+	return nil
+}
+
+// GetRelyingParties gets every relying party
+func (s *MemoryStore) GetRelyingParties() ([]RelyingParty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rps := []RelyingParty{}
+	for _, rp := range s.rps {
+		rps = append(rps, rp)
+	}
+	sort.Slice(rps, func(i, j int) bool { return rps[i].ID < rps[j].ID })
+	return rps, nil
+}
+
+// DeleteRelyingParty deletes a relying party by ID
+func (s *MemoryStore) DeleteRelyingParty(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rps[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.rps, id)
+	return nil
+}
+
+// CreateCredential stores a new credential
+func (s *MemoryStore) CreateCredential(c *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credentialIDs[c.CredID]; ok {
+		return ErrCredentialExists
+	}
+	s.lastCredentialID++
+	c.ID = s.lastCredentialID
+	c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+	s.credentials[c.ID] = *c
+	s.credentialIDs[c.CredID] = c.ID
+	return nil
+}
+
+// UpdateCredential updates a credential while holding the lock
+func (s *MemoryStore) UpdateCredential(id int64, update func(c *Credential) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.credentials[id]
+	if !ok {
+		return ErrNotFound
+	}
+	err := update(&c)
+	if err != nil {
+		return err
+	}
+	c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+	s.credentials[id] = c
+	return nil
+}
+
+// GetCredential gets a credential by ID
+func (s *MemoryStore) GetCredential(id int64) (Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.credentials[id]
+	if !ok {
+		return Credential{}, ErrNotFound
+	}
+	return c, nil
+}
+
+// GetCredentialByCredID gets a credential by credential ID
+func (s *MemoryStore) GetCredentialByCredID(credID string) (Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.credentialIDs[credID]
+	if !ok {
+		return Credential{}, ErrNotFound
+	}
+	return s.credentials[id], nil
+}
+
+// findCredentials returns the credentials matching a filter, oldest first
+func (s *MemoryStore) findCredentials(match func(c Credential) bool) []Credential {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := []Credential{}
+	for _, c := range s.credentials {
+		if match(c) {
+			creds = append(creds, c)
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].ID < creds[j].ID })
+	return creds
+}
+
+// GetCredentialsForUser gets the credentials of a user
+func (s *MemoryStore) GetCredentialsForUser(userID int64) ([]Credential, error) {
+	return s.findCredentials(func(c Credential) bool {
+		return c.UserID == userID
+	}), nil
+}
+
+// GetCredentialsForUserAndRelyingParty gets the credentials of a user for a relying party
+func (s *MemoryStore) GetCredentialsForUserAndRelyingParty(userID int64, rpID string) ([]Credential, error) {
+	return s.findCredentials(func(c Credential) bool {
+		return c.UserID == userID && c.RelyingPartyID == rpID
+	}), nil
+}
+
+// DeleteCredential deletes a credential by ID
+func (s *MemoryStore) DeleteCredential(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.credentials[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.credentialIDs, c.CredID)
+	delete(s.credentials, id)
+	return nil
+}
+
+// PutSession creates or updates a session
+func (s *MemoryStore) PutSession(sd *SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sd.ID == 0 {
+		s.lastSessionID++
+		sd.ID = s.lastSessionID
+	}
+	s.sessions[sd.ID] = *sd
+	return nil
+}
+
+// GetSession gets a session by ID
+func (s *MemoryStore) GetSession(id int64) (SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sd, ok := s.sessions[id]
+	if !ok {
+		return SessionData{}, ErrNotFound
+	}
+	return sd, nil
+}
+
+// GetLatestSession gets the last session of a user for a relying party
+func (s *MemoryStore) GetLatestSession(userID int64, rpID string) (SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := SessionData{}
+	for _, sd := range s.sessions {
+		if sd.UserID == userID && sd.RelyingPartyID == rpID && sd.ID > latest.ID {
+			latest = sd
+		}
+	}
+	if latest.ID == 0 {
+		return SessionData{}, ErrNotFound
+	}
+	return latest, nil
+}
+
+// ConsumeSession deletes a session and returns it
+func (s *MemoryStore) ConsumeSession(id int64, now time.Time) (SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sd, ok := s.sessions[id]
+	if !ok {
+		return SessionData{}, ErrNotFound
+	}
+	delete(s.sessions, id)
+	if sd.Expired(now) {
+		return sd, ErrSessionExpired
+	}
+	return sd, nil
+}
+
+// DeleteExpiredSessions deletes the sessions expired at now
+func (s *MemoryStore) DeleteExpiredSessions(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for id, sd := range s.sessions {
+		if sd.Expired(now) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// CreateLoginSession stores a new login session
+func (s *MemoryStore) CreateLoginSession(ls *LoginSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastLoginID++
+	ls.ID = s.lastLoginID
+	s.logins[ls.ID] = *ls
+	return nil
+}
+
+// GetLoginSessionByToken gets a login session by its cookie token
+func (s *MemoryStore) GetLoginSessionByToken(token string) (LoginSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ls := range s.logins {
+		if ls.Token == token {
+			return ls, nil
+		}
+	}
+	return LoginSession{}, ErrNotFound
+}
+
+// GetLoginSessionsForUser gets the login sessions of a user
+func (s *MemoryStore) GetLoginSessionsForUser(userID int64) ([]LoginSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []LoginSession{}
+	for _, ls := range s.logins {
+		if ls.UserID == userID {
+			sessions = append(sessions, ls)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions, nil
+}
+
+// DeleteLoginSession deletes a login session by ID
+func (s *MemoryStore) DeleteLoginSession(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.logins[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.logins, id)
+	return nil
+}
+
+// DeleteExpiredLoginSessions deletes the login sessions expired at now
+func (s *MemoryStore) DeleteExpiredLoginSessions(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for id, ls := range s.logins {
+		if ls.Expired(now) {
+			delete(s.logins, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// CreateAuditEntry stores a new audit entry
+func (s *MemoryStore) CreateAuditEntry(e *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = int64(len(s.audit) + 1)
+	s.audit = append(s.audit, *e)
+	return nil
+}
+
+// GetAuditEntries gets every audit entry, oldest first
+func (s *MemoryStore) GetAuditEntries() ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditEntry{}, s.audit...), nil
+}
+
+// Close does nothing, there is nothing to close
+func (s *MemoryStore) Close() error {
+	return nil
+}
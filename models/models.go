@@ -8,11 +8,11 @@ import (
 	"log"
 	"os"
 
-	"github.com/asdine/storm"
+	"git.jba.io/go/webauthn/config"
 )
 
-var db *storm.DB
-var err error
+// db is the Store opened by Setup
+var db Store
 
 // ErrUsernameTaken is thrown when a user attempts to register a username that is taken.
 var ErrUsernameTaken = errors.New("username already taken")
@@ -27,39 +27,31 @@ func generateSecureKey() string {
 	return fmt.Sprintf("%x", k)
 }
 
-// Setup initializes the Conn object
-// It also populates the Config object
+// Setup opens the Store selected by db_name in the config and creates the
+// default relying party. There's no default user, admins are granted with
+// `webauthn admin grant` once they registered an authenticator.
 func Setup() error {
-	db, err = storm.Open("webauthn.db")
-	//defer db.Close()
+	store, err := Open(config.Conf.DBName, config.Conf.DBPath, config.Conf.MigrationsPath)
 	if err != nil {
-		log.Fatalln(err)
+		log.Println("Error opening the database:", err)
+		return err
 	}
+	db = store
 
-	// Create the default user
-	initUser := User{
-		ID:          1,
-		Name:        "admin",
-		DisplayName: "Mr. Admin Face",
-	}
 	// Create the default relying party
 	initRP := RelyingParty{
-		ID:          "localhost",
+		ID:          DefaultRelyingPartyID(),
 		DisplayName: "Acme, Inc",
 		Icon:        "lol.catpics.png",
-		Users:       []User{initUser},
-	}
 
-	users := db.From("users")
-	rps := db.From("rps")
-
-	err = rps.Save(&initRP)
-	if err != nil {
-		log.Println(err)
-		return err
+		AuthenticatorAttachment: "cross-platform",
 	}
 
-	err = users.Save(&initUser)
+	// Only create it once so we don't replace its settings
+	_, err = GetRelyingPartyByHost(initRP.ID)
+	if err == ErrNotFound {
+		err = PutRelyingParty(&initRP)
+	}
 	if err != nil {
 		log.Println(err)
 		return err
@@ -67,3 +59,8 @@ func Setup() error {
 
 	return nil
 }
+
+// Close closes the Store opened by Setup
+func Close() error {
+	return db.Close()
+}
@@ -1,38 +1,54 @@
 package models
 
 import (
+	"path/filepath"
 	"testing"
 
-	"github.com/duo-labs/webauthn/config"
+	"git.jba.io/go/webauthn/config"
 	"github.com/stretchr/testify/suite"
 )
 
 type ModelsSuite struct {
 	suite.Suite
+	// backend is the db_name of the Store the suite runs against
+	backend string
 }
 
 func (ms *ModelsSuite) SetupSuite() {
-	config.Conf.DBName = "sqlite3"
-	config.Conf.DBPath = ":memory:"
 	config.Conf.HostAddress = "localhost"
+	config.Conf.MigrationsPath = "../db/db_sqlite3"
+}
+
+// SetupTest opens an empty database for every test
+func (ms *ModelsSuite) SetupTest() {
+	config.Conf.DBName = ms.backend
+	config.Conf.DBPath = ":memory:"
+	if ms.backend == "storm" {
+		config.Conf.DBPath = filepath.Join(ms.T().TempDir(), "webauthn.db")
+	}
 	err := Setup()
 	if err != nil {
 		ms.T().Fatalf("Failed creating database: %v", err)
 	}
+	// Setup doesn't create users, the tests share user 1
+	err = PutUser(&User{Name: "test", DisplayName: "Test User"})
+	if err != nil {
+		ms.T().Fatalf("Failed creating the test user: %v", err)
+	}
 }
 
 func (ms *ModelsSuite) TearDownTest() {
-	// Clear database tables between each test. If new tables are
-	// used in this test suite they will need to be cleaned up here.
-	db.Delete(Credential{})
-	db.Delete(SessionData{})
+	Close()
+}
 
-	db.Not("id", 1).Delete(User{})
-	db.Model(User{}).Update("name", "admin")
+func TestRunModelsSuite(t *testing.T) {
+	suite.Run(t, &ModelsSuite{backend: "memory"})
+}
 
-	db.Not("id", config.Conf.HostAddress).Delete(RelyingParty{})
+func TestRunStormModelsSuite(t *testing.T) {
+	suite.Run(t, &ModelsSuite{backend: "storm"})
 }
 
-func TestRunModelsSuite(t *testing.T) {
-	suite.Run(t, new(ModelsSuite))
+func TestRunSQLModelsSuite(t *testing.T) {
+	suite.Run(t, &ModelsSuite{backend: "sqlite3"})
 }
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"git.jba.io/go/webauthn/config"
+)
+
+// origin is a parsed web origin. Ports are always filled in so
+// https://example.com and https://example.com:443 compare equal.
+type origin struct {
+	Scheme string
+	Host   string
+	Port   string
+}
+
+// parseOrigin splits a serialized origin into its scheme, host and port. An
+// origin doesn't have a path, query, fragment or user info.
+func parseOrigin(raw string) (origin, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return origin{}, fmt.Errorf("%q is not an origin, it needs a scheme and a host", raw)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return origin{}, fmt.Errorf("%q is not an origin, it can only have a scheme, host and port", raw)
+	}
+	o := origin{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   strings.ToLower(u.Hostname()),
+		Port:   u.Port(),
+	}
+	if o.Port == "" {
+		switch o.Scheme {
+		case "https":
+			o.Port = "443"
+		case "http":
+			o.Port = "80"
+		}
+	}
+	return o, nil
+}
+
+// parseOriginPattern parses an allowed origin, which can start its host with
+// a *. wildcard label to allow every subdomain of the rest
+func parseOriginPattern(raw string) (origin, error) {
+	wildcard := strings.Contains(raw, "://*.")
+	o, err := parseOrigin(strings.Replace(raw, "://*.", "://", 1))
+	if err != nil {
+		return o, fmt.Errorf("Origin %q needs a scheme and a host, and nothing else", raw)
+	}
+	if strings.Contains(o.Host, "*") {
+		return o, fmt.Errorf("Origin %q can only have a wildcard as its first label", raw)
+	}
+	if wildcard {
+		o.Host = "*." + o.Host
+	}
+	return o, nil
+}
+
+// matchesHost reports whether host is the host of the pattern, or one of its
+// subdomains when the pattern is a wildcard. The wildcard doesn't match the
+// domain itself.
+func (o origin) matchesHost(host string) bool {
+	if strings.HasPrefix(o.Host, "*.") {
+		suffix := o.Host[1:]
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return o.Host == host
+}
+
+// OriginMismatchError is returned when a ceremony comes from an origin the
+// Relying Party doesn't allow. Reason says what didn't match.
+type OriginMismatchError struct {
+	Origin         string
+	RelyingPartyID string
+	Reason         string
+}
+
+func (e *OriginMismatchError) Error() string {
+	return fmt.Sprintf("Origin %s is not allowed for relying party %s: %s", e.Origin, e.RelyingPartyID, e.Reason)
+}
+
+// DefaultOrigins are the origins of a Relying Party without configured ones,
+// where we serve it from: https behind the reverse proxy, otherwise plain
+// http on the host port.
+func (rp *RelyingParty) DefaultOrigins() []string {
+	if config.Conf.HasProxy {
+		return []string{"https://" + rp.ID}
+	}
+	return []string{"http://" + rp.ID + config.Conf.HostPort}
+}
+
+// AllowedOrigins are the origins ceremonies for the Relying Party may come
+// from, its own origins followed by the related ones
+func (rp *RelyingParty) AllowedOrigins() []string {
+	origins := rp.Origins
+	if len(origins) == 0 {
+		origins = rp.DefaultOrigins()
+	}
+	return append(append([]string{}, origins...), rp.RelatedOrigins...)
+}
+
+// CheckOrigin verifies the origin from the client data is one the Relying
+// Party allows, matching the scheme, host and port. It returns an
+// *OriginMismatchError saying what was different when it isn't.
+func (rp *RelyingParty) CheckOrigin(raw string) error {
+	o, err := parseOrigin(raw)
+	if err != nil {
+		return &OriginMismatchError{Origin: raw, RelyingPartyID: rp.ID, Reason: err.Error()}
+	}
+
+	// The related origins come last in the allowed ones
+	allowedOrigins := rp.AllowedOrigins()
+	own := len(allowedOrigins) - len(rp.RelatedOrigins)
+
+	// Remember the closest pattern so the error can say what's wrong
+	var sameHost *origin
+	for x, allowed := range allowedOrigins {
+		pattern, err := parseOriginPattern(allowed)
+		if err != nil {
+			continue
+		}
+		if !pattern.matchesHost(o.Host) {
+			continue
+		}
+		if pattern.Scheme == o.Scheme && pattern.Port == o.Port {
+			// Related origins are on other domains, everything else has
+			// to be on the domain of the RP ID
+			if x >= own {
+				return nil
+			}
+			err = CheckRelyingPartyID(rp.ID, o.Host)
+			if err != nil {
+				return &OriginMismatchError{Origin: raw, RelyingPartyID: rp.ID, Reason: err.Error()}
+			}
+			return nil
+		}
+		if sameHost == nil {
+			sameHost = &pattern
+		}
+	}
+
+	mismatch := &OriginMismatchError{Origin: raw, RelyingPartyID: rp.ID}
+	switch {
+	case sameHost == nil:
+		mismatch.Reason = fmt.Sprintf("host %s is not one of %s", o.Host, strings.Join(allowedOrigins, ", "))
+	case sameHost.Scheme != o.Scheme:
+		mismatch.Reason = fmt.Sprintf("expected scheme %s, got %s", sameHost.Scheme, o.Scheme)
+	default:
+		mismatch.Reason = fmt.Sprintf("expected port %s, got %s", sameHost.Port, o.Port)
+	}
+	return mismatch
+}
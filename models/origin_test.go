@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+
+	"git.jba.io/go/webauthn/config"
+)
+
+func (ms *ModelsSuite) TestCheckOrigin() {
+	rp := RelyingParty{
+		ID:             "example.com",
+		Origins:        []string{"https://example.com", "https://*.example.com", "https://login.example.com:8443"},
+		RelatedOrigins: []string{"https://example.co.uk"},
+	}
+	allowed := []string{
+		"https://example.com",
+		"https://example.com:443",
+		"https://EXAMPLE.com",
+		"https://a.example.com",
+		"https://a.b.example.com",
+		"https://login.example.com:8443",
+		"https://example.co.uk",
+	}
+	for _, o := range allowed {
+		err := rp.CheckOrigin(o)
+		if err != nil {
+			ms.T().Fatalf("Unexpected error checking allowed origin %s: %s", o, err)
+		}
+	}
+
+	rejected := map[string]string{
+		"http://example.com":          "expected scheme https, got http",
+		"https://example.com:9999":    "expected port 443, got 9999",
+		"https://example.org":         "host example.org is not one of",
+		"https://badexample.com":      "host badexample.com is not one of",
+		"https://www.example.co.uk":   "host www.example.co.uk is not one of",
+		"https://example.com/path":    "can only have a scheme, host and port",
+		"example.com":                 "needs a scheme and a host",
+		"https://user@example.com":    "can only have a scheme, host and port",
+		"https://a.example.com:9999":  "expected port 443, got 9999",
+		"http://localhost:9999":       "host localhost is not one of",
+		"https://example.com?query=1": "can only have a scheme, host and port",
+	}
+	for o, reason := range rejected {
+		err := rp.CheckOrigin(o)
+		mismatch, ok := err.(*OriginMismatchError)
+		if !ok {
+			ms.T().Fatalf("Expected an origin mismatch for %s, got %v", o, err)
+		}
+		if !strings.Contains(mismatch.Reason, reason) {
+			ms.T().Fatalf("Unexpected reason for %s. Expected %q, Got %q", o, reason, mismatch.Reason)
+		}
+	}
+}
+
+func (ms *ModelsSuite) TestDefaultOrigins() {
+	hasProxy, hostPort := config.Conf.HasProxy, config.Conf.HostPort
+	defer func() {
+		config.Conf.HasProxy, config.Conf.HostPort = hasProxy, hostPort
+	}()
+
+	rp := RelyingParty{ID: "localhost"}
+	config.Conf.HasProxy = false
+	config.Conf.HostPort = ":9005"
+	if err := rp.CheckOrigin("http://localhost:9005"); err != nil {
+		ms.T().Fatalf("Unexpected error checking the default origin: %s", err)
+	}
+	if err := rp.CheckOrigin("http://localhost:9999"); err == nil {
+		ms.T().Fatalf("Expected an error for another port")
+	}
+
+	config.Conf.HasProxy = true
+	if err := rp.CheckOrigin("https://localhost"); err != nil {
+		ms.T().Fatalf("Unexpected error checking the default origin behind a proxy: %s", err)
+	}
+}
+
+func (ms *ModelsSuite) TestOriginValidation() {
+	invalid := []RelyingParty{
+		{ID: "example.com", Origins: []string{"https://a.*.example.com"}},
+		{ID: "example.com", Origins: []string{"https://example.com/login"}},
+		{ID: "example.com", RelatedOrigins: []string{"https://*.example.org"}},
+		{ID: "example.com", RelatedOrigins: []string{"example.org"}},
+	}
+	for _, rp := range invalid {
+		if err := rp.Validate(); err == nil {
+			ms.T().Fatalf("Expected an error validating %#v", rp)
+		}
+	}
+}
@@ -1,40 +1,153 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.jba.io/go/webauthn/config"
+)
+
 // RelyingParty is the group the User is authenticating with
 type RelyingParty struct {
 	ID          string `json:"id" storm:"id"`
 	DisplayName string `json:"display_name"`
 	Icon        string `json:"icon,omitempty"`
 	Users       []User `json:"users,omitempty" storm:"unique"`
+	// CounterPolicy is what happens when a signature counter regresses, one of
+	// reject, suspect or allow. Empty means reject.
+	CounterPolicy string `json:"counter_policy,omitempty"`
+
+	// Origins are the web origins ceremonies for this RP may come from, a
+	// host can start with *. to allow its subdomains. Empty means where we
+	// serve the RP from.
+	Origins []string `json:"origins,omitempty"`
+	// RelatedOrigins are origins on other domains that may use this RP ID,
+	// they are listed in its /.well-known/webauthn document
+	RelatedOrigins []string `json:"related_origins,omitempty"`
+	// Attestation is the attestation conveyance preference, one of none,
+	// indirect, direct or enterprise. Empty lets the client pick.
+	Attestation string `json:"attestation,omitempty"`
+	// UserVerification is one of required, preferred or discouraged. Empty
+	// means preferred. When it's required, ceremonies without the UV flag fail.
+	UserVerification string `json:"user_verification,omitempty"`
+	// Algorithms are the COSE algorithms offered for new credentials, most
+	// preferred first. Empty means the credential_algorithms from the config.
+	Algorithms []int64 `json:"algorithms,omitempty"`
+	// AuthenticatorAttachment is platform or cross-platform, empty allows both
+	AuthenticatorAttachment string `json:"authenticator_attachment,omitempty"`
+}
+
+// ErrRelyingPartyExists is returned when creating a Relying Party whose ID is taken
+var ErrRelyingPartyExists = errors.New("A relying party with this ID already exists")
+
+// ErrMissingRelyingPartyID is returned when a Relying Party doesn't have an ID
+var ErrMissingRelyingPartyID = errors.New("Relying party needs an ID")
+
+// oneOf reports whether value is empty or one of the allowed values
+func oneOf(value string, allowed ...string) bool {
+	if value == "" {
+		return true
+	}
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the settings of a Relying Party
+func (rp *RelyingParty) Validate() error {
+	err := ValidRelyingPartyID(rp.ID)
+	if err != nil {
+		return err
+	}
+	if !ValidCounterPolicy(rp.CounterPolicy) {
+		return ErrInvalidCounterPolicy
+	}
+	if !oneOf(rp.Attestation, "none", "indirect", "direct", "enterprise") {
+		return errors.New("Attestation needs to be 'none', 'indirect', 'direct' or 'enterprise'")
+	}
+	if !oneOf(rp.UserVerification, "required", "preferred", "discouraged") {
+		return errors.New("User verification needs to be 'required', 'preferred' or 'discouraged'")
+	}
+	if !oneOf(rp.AuthenticatorAttachment, "platform", "cross-platform") {
+		return errors.New("Authenticator attachment needs to be 'platform' or 'cross-platform'")
+	}
+	for _, alg := range rp.Algorithms {
+		if !SupportedAlgorithm(alg) {
+			return fmt.Errorf("Algorithm %d is not supported", alg)
+		}
+	}
+	for _, o := range rp.Origins {
+		pattern, err := parseOriginPattern(o)
+		if err != nil {
+			return err
+		}
+		err = CheckRelyingPartyID(rp.ID, strings.TrimPrefix(pattern.Host, "*."))
+		if err != nil {
+			return fmt.Errorf("Origin %q can't use this relying party: %s", o, err)
+		}
+	}
+	// Clients look for related origins exactly as they are listed
+	for _, o := range rp.RelatedOrigins {
+		_, err := parseOrigin(o)
+		if err != nil || strings.Contains(o, "*") {
+			return fmt.Errorf("Related origin %q needs to be a scheme, host and port without wildcards", o)
+		}
+	}
+	return nil
 }
 
 // GetDefaultRelyingParty gets the RP associated with the configured hostname
 func GetDefaultRelyingParty() (RelyingParty, error) {
-	rp := RelyingParty{}
-	//err := db.Where("id=?", config.Conf.HostAddress).First(&rp).Error
-	rps := db.From("rps")
-	err := rps.One("ID", "localhost", &rp)
-	if err != nil {
-		return rp, err
+	return db.GetRelyingParty(DefaultRelyingPartyID())
+}
+
+// DefaultRelyingPartyID is the ID of the RP created by Setup, the configured
+// host address
+func DefaultRelyingPartyID() string {
+	if config.Conf.HostAddress == "" {
+		return "localhost"
 	}
-	return rp, nil
+	return config.Conf.HostAddress
 }
 
 // GetRelyingPartyByHost gets the RP by hostname which in this case is the ID
 func GetRelyingPartyByHost(hostname string) (RelyingParty, error) {
-	rp := RelyingParty{}
-	//err := db.Where("id = ?", hostname).First(&rp).Error
-	rps := db.From("rps")
-	err := rps.One("ID", hostname, &rp)
-	if err != nil {
-		return rp, err
-	}
-	return rp, nil
+	return db.GetRelyingParty(hostname)
+}
+
+// GetRelyingParties lists every Relying Party
+func GetRelyingParties() ([]RelyingParty, error) {
+	return db.GetRelyingParties()
 }
 
 // PutRelyingParty creates or updates a Relying Party
 func PutRelyingParty(rp *RelyingParty) error {
-	rps := db.From("rps")
-	err := rps.Save(rp)
-	return err
+	err := rp.Validate()
+	if err != nil {
+		return err
+	}
+	return db.PutRelyingParty(rp)
+}
+
+// CreateRelyingParty creates a Relying Party, failing with ErrRelyingPartyExists
+// when the ID is taken
+func CreateRelyingParty(rp *RelyingParty) error {
+	_, err := db.GetRelyingParty(rp.ID)
+	if err == nil {
+		return ErrRelyingPartyExists
+	}
+	if err != ErrNotFound {
+		return err
+	}
+	return PutRelyingParty(rp)
+}
+
+// DeleteRelyingParty deletes a Relying Party. Credentials registered with it
+// are kept but can't be used until it is created again.
+func DeleteRelyingParty(id string) error {
+	return db.DeleteRelyingParty(id)
 }
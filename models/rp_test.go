@@ -3,8 +3,7 @@ package models
 import (
 	"reflect"
 
-	"github.com/duo-labs/webauthn/config"
-	"github.com/jinzhu/gorm"
+	"git.jba.io/go/webauthn/config"
 )
 
 func (ms *ModelsSuite) TestGetDefaultRelyingParty() {
@@ -28,7 +27,7 @@ func (ms *ModelsSuite) TestGetRelyingPartyByHost() {
 	}
 
 	_, err = GetRelyingPartyByHost("bogus_hostname")
-	if err != gorm.ErrRecordNotFound {
+	if err != ErrNotFound {
 		ms.T().Fatalf("Received unexpected error value when fetching non-existent RP: %s", err)
 	}
 }
@@ -54,3 +53,69 @@ func (ms *ModelsSuite) TestPutRelyingParty() {
 	}
 
 }
+
+func (ms *ModelsSuite) TestRelyingPartyValidation() {
+	valid := RelyingParty{
+		ID:                      "valid.example.com",
+		Origins:                 []string{"https://valid.example.com"},
+		Attestation:             "direct",
+		UserVerification:        "required",
+		Algorithms:              []int64{COSEAlgES256},
+		AuthenticatorAttachment: "platform",
+	}
+	err := valid.Validate()
+	if err != nil {
+		ms.T().Fatalf("Unexpected error validating relying party: %s", err)
+	}
+
+	invalid := []RelyingParty{
+		{},
+		{ID: "example.com", Attestation: "bogus"},
+		{ID: "example.com", UserVerification: "always"},
+		{ID: "example.com", AuthenticatorAttachment: "usb"},
+		{ID: "example.com", Algorithms: []int64{12345}},
+		{ID: "example.com", Origins: []string{"example.com"}},
+		{ID: "example.com", CounterPolicy: "ignore"},
+	}
+	for _, rp := range invalid {
+		err = PutRelyingParty(&rp)
+		if err == nil {
+			ms.T().Fatalf("Expected an error validating relying party %#v", rp)
+		}
+	}
+}
+
+func (ms *ModelsSuite) TestCreateAndDeleteRelyingParty() {
+	rp := RelyingParty{ID: "created.example.com", DisplayName: "Created"}
+	err := CreateRelyingParty(&rp)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating relying party: %s", err)
+	}
+	err = CreateRelyingParty(&rp)
+	if err != ErrRelyingPartyExists {
+		ms.T().Fatalf("Unexpected error creating a duplicate relying party. Expected %s, Got %s", ErrRelyingPartyExists, err)
+	}
+
+	rps, err := GetRelyingParties()
+	if err != nil {
+		ms.T().Fatalf("Unexpected error getting relying parties: %s", err)
+	}
+	found := false
+	for _, got := range rps {
+		if got.ID == rp.ID {
+			found = true
+		}
+	}
+	if !found {
+		ms.T().Fatalf("Created relying party %s not in %#v", rp.ID, rps)
+	}
+
+	err = DeleteRelyingParty(rp.ID)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error deleting relying party: %s", err)
+	}
+	err = DeleteRelyingParty(rp.ID)
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error deleting a missing relying party. Expected %s, Got %s", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ErrPublicSuffixRelyingPartyID is returned for an RP ID that's a public
+// suffix like com or co.uk, which would share credentials with every site
+// under it
+var ErrPublicSuffixRelyingPartyID = errors.New("Relying party ID can't be a public suffix")
+
+// ValidRelyingPartyID checks an RP ID is a domain sites can register under.
+// Public suffixes come from the Public Suffix List snapshot bundled with
+// golang.org/x/net/publicsuffix. localhost and IP addresses are allowed so
+// we can run locally.
+func ValidRelyingPartyID(id string) error {
+	if id == "" {
+		return ErrMissingRelyingPartyID
+	}
+	if id == "localhost" || net.ParseIP(id) != nil {
+		return nil
+	}
+	if id != strings.ToLower(id) || strings.HasPrefix(id, ".") || strings.HasSuffix(id, ".") || strings.ContainsAny(id, ":/*") {
+		return fmt.Errorf("Relying party ID %q needs to be a lowercase domain", id)
+	}
+	suffix, _ := publicsuffix.PublicSuffix(id)
+	if suffix == id {
+		return ErrPublicSuffixRelyingPartyID
+	}
+	return nil
+}
+
+// CheckRelyingPartyID checks the RP ID is a registrable domain suffix of, or
+// is equal to, the host of an origin. login.example.com can use the RP ID
+// example.com, but not the other way around. IP addresses only match
+// themselves.
+func CheckRelyingPartyID(id string, host string) error {
+	err := ValidRelyingPartyID(id)
+	if err != nil {
+		return err
+	}
+	host = strings.ToLower(host)
+	if host == id {
+		return nil
+	}
+	if net.ParseIP(host) == nil && net.ParseIP(id) == nil && strings.HasSuffix(host, "."+id) {
+		return nil
+	}
+	return fmt.Errorf("Relying party ID %s is not a registrable domain suffix of %s", id, host)
+}
+
+// GetRelyingPartyForHost gets the RP for a host, either the one whose ID is the
+// host or the closest one whose ID is a registrable suffix of it, so pages on
+// login.example.com use the example.com RP
+func GetRelyingPartyForHost(host string) (RelyingParty, error) {
+	host = strings.ToLower(host)
+	candidate := host
+	for {
+		rp, err := db.GetRelyingParty(candidate)
+		if err == nil {
+			return rp, nil
+		}
+		if err != ErrNotFound {
+			return rp, err
+		}
+		dot := strings.Index(candidate, ".")
+		if dot < 0 || net.ParseIP(host) != nil {
+			return RelyingParty{}, ErrNotFound
+		}
+		candidate = candidate[dot+1:]
+		if ValidRelyingPartyID(candidate) != nil {
+			return RelyingParty{}, ErrNotFound
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package models
+
+func (ms *ModelsSuite) TestValidRelyingPartyID() {
+	valid := []string{"localhost", "example.com", "login.example.com", "example.co.uk", "127.0.0.1", "::1"}
+	for _, id := range valid {
+		err := ValidRelyingPartyID(id)
+		if err != nil {
+			ms.T().Fatalf("Unexpected error validating RP ID %s: %s", id, err)
+		}
+	}
+
+	invalid := []string{"", "com", "co.uk", "github.io", "Example.com", ".example.com", "example.com.", "*.example.com", "example.com:443"}
+	for _, id := range invalid {
+		err := ValidRelyingPartyID(id)
+		if err == nil {
+			ms.T().Fatalf("Expected an error validating RP ID %q", id)
+		}
+	}
+}
+
+func (ms *ModelsSuite) TestCheckRelyingPartyID() {
+	cases := []struct {
+		id, host string
+		valid    bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "login.example.com", true},
+		{"example.com", "a.b.example.com", true},
+		{"login.example.com", "example.com", false},
+		{"example.com", "badexample.com", false},
+		{"example.com", "example.org", false},
+		{"co.uk", "example.co.uk", false},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"0.1", "127.0.0.1", false},
+	}
+	for _, c := range cases {
+		err := CheckRelyingPartyID(c.id, c.host)
+		if (err == nil) != c.valid {
+			ms.T().Fatalf("Unexpected result for RP ID %s on %s. Expected valid: %t, Got %v", c.id, c.host, c.valid, err)
+		}
+	}
+}
+
+func (ms *ModelsSuite) TestGetRelyingPartyForHost() {
+	rp := RelyingParty{ID: "shared.example.com", DisplayName: "Shared"}
+	err := PutRelyingParty(&rp)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error adding a relying party: %s", err)
+	}
+	defer DeleteRelyingParty(rp.ID)
+
+	for _, host := range []string{"shared.example.com", "login.shared.example.com", "a.b.shared.example.com"} {
+		got, err := GetRelyingPartyForHost(host)
+		if err != nil || got.ID != rp.ID {
+			ms.T().Fatalf("Unexpected relying party for %s. Expected %s, Got %s (%v)", host, rp.ID, got.ID, err)
+		}
+	}
+	_, err = GetRelyingPartyForHost("other.example.com")
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error for a host without a relying party. Expected %s, Got %v", ErrNotFound, err)
+	}
+}
+
+func (ms *ModelsSuite) TestOriginOutsideRelyingPartyID() {
+	rp := RelyingParty{ID: "login.example.com", Origins: []string{"https://example.com"}}
+	err := rp.Validate()
+	if err == nil {
+		ms.T().Fatalf("Expected an error for an origin outside of the RP ID")
+	}
+	// Stored before we validated this, the origin still can't use the RP ID
+	err = rp.CheckOrigin("https://example.com")
+	if _, ok := err.(*OriginMismatchError); !ok {
+		ms.T().Fatalf("Expected an origin mismatch, got %v", err)
+	}
+}
@@ -6,10 +6,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/asdine/storm"
-
-	"github.com/asdine/storm/q"
 	"github.com/gorilla/sessions"
 )
 
@@ -25,13 +23,49 @@ type SessionData struct {
 
 	RelyingParty   RelyingParty `json:"rp"`
 	RelyingPartyID string       `json:"rp_id"`
+
+	// UserVerification is the requirement sent to the client, required,
+	// preferred or discouraged
+	UserVerification string `json:"user_verification"`
+
+	// The challenge can only be used until ExpiresAt, which is IssuedAt
+	// plus the timeout sent to the client
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// Expired reports whether the challenge of the session can no longer be used.
+// Sessions stored before sessions expired don't have an expiry and are expired.
+func (sd *SessionData) Expired(now time.Time) bool {
+	return !now.Before(sd.ExpiresAt)
+}
+
+// UserVerificationRequired reports whether the authenticator has to verify
+// the user, because the ceremony asked for it or the relying party requires
+// it. Sessions stored before the requirement was kept only have the latter.
+func (sd *SessionData) UserVerificationRequired() bool {
+	return sd.UserVerification == "required" || sd.RelyingParty.UserVerification == "required"
+}
+
+// DefaultChallengeTimeout is how long a challenge is valid when no timeout is configured
+const DefaultChallengeTimeout = 60 * time.Second
+
+// DefaultSessionSweepInterval is how often expired sessions are deleted when
+// no interval is configured
+const DefaultSessionSweepInterval = time.Minute
+
 // ErrInvalidSessionType is thrown when an invalid session type is created
 var ErrInvalidSessionType = errors.New("SessionType needs to be 'reg' or 'att'")
 
-// CreateNewSession - Create new user/rp session
-func CreateNewSession(u *User, rp *RelyingParty, st string) (SessionData, error) {
+// ErrSessionExpired is returned when a session is used after its challenge expired
+var ErrSessionExpired = errors.New("The challenge for this session has expired")
+
+// ErrSessionTypeMismatch is returned when a session is used for the wrong ceremony
+var ErrSessionTypeMismatch = errors.New("Session was not created for this ceremony")
+
+// CreateNewSession - Create new user/rp session whose challenge is valid for
+// timeout, keeping the user verification requirement sent to the client
+func CreateNewSession(u *User, rp *RelyingParty, st string, userVerification string, timeout time.Duration) (SessionData, error) {
 	ch, err := CreateChallenge(16)
 	if err != nil {
 		fmt.Println("Error Creating Challenge")
@@ -48,7 +82,13 @@ func CreateNewSession(u *User, rp *RelyingParty, st string) (SessionData, error)
 		UserID:         u.ID,
 		RelyingPartyID: rp.ID,
 		SessionType:    st,
+
+		UserVerification: userVerification,
 	}
+	// Storm and SQLite don't keep the monotonic clock reading or the
+	// location, so we don't either
+	sd.IssuedAt = time.Now().UTC().Round(0)
+	sd.ExpiresAt = sd.IssuedAt.Add(timeout)
 
 	err = PutSession(&sd)
 	if err != nil {
@@ -61,77 +101,53 @@ func CreateNewSession(u *User, rp *RelyingParty, st string) (SessionData, error)
 
 // GetSessionsByUsernameAndRelyingParty - Get the last recorded SessionData for a user/rp
 func GetSessionsByUsernameAndRelyingParty(uid int64, rpid string) (SessionData, error) {
-	sd := SessionData{}
-
-	//err := db.Where("user_id = ? AND relying_party_id = ?", uid, rpid).Last(&sd).Error
-	sessions := db.From("sessions")
-	err := sessions.Select(q.Eq("UserID", uid), q.Eq("RelyingPartyID", rpid)).Reverse().First(&sd)
-	return sd, err
+	return db.GetLatestSession(uid, rpid)
 }
 
-// GetSessionData returns the SessionData that the given id corresponds to. If no user is found, an
-// error is thrown.
+// GetSessionData returns the SessionData that the given id corresponds to, with
+// its user and relying party. If no session is found, an error is thrown.
 func GetSessionData(id int64) (SessionData, error) {
-	sd := SessionData{}
-	//err := db.Where("id = ?", id).First(&sd).Error
-	sessions := db.From("sessions")
-	err = sessions.One("ID", id, &sd)
+	sd, err := db.GetSession(id)
 	if err != nil {
 		log.Println("GetSessionData:", err)
+		return sd, err
 	}
-	/*
-		sds := []SessionData{}
-		err := db.All(&sds)
-		if err != nil {
-			log.Println("GetSessionData:", err)
-		}
-		for _, v := range sds {
-			if v.ID == id {
-				sd = v
-			}
-		}
-	*/
-	/*
-		if err != nil {
-			return sd, err
-		}*/
 	log.Println("SESSION DATA:", &sd)
+	return loadSessionUserAndRelyingParty(sd)
+}
+
+// ConsumeSessionData deletes the session with the given id and returns it,
+// with its user and relying party, so its challenge can only be verified once.
+// It returns ErrSessionExpired when the challenge expired and
+// ErrSessionTypeMismatch when the session wasn't created for st.
+func ConsumeSessionData(id int64, st string) (SessionData, error) {
+	sd, err := db.ConsumeSession(id, time.Now())
+	if err != nil {
+		log.Println("ConsumeSessionData:", err)
+		return sd, err
+	}
+	if sd.SessionType != st {
+		return sd, ErrSessionTypeMismatch
+	}
+	return loadSessionUserAndRelyingParty(sd)
+}
 
-	/*
-		//err = db.Model(&sd).Related(&sd.User).Error
-		err = db.Select()
+func loadSessionUserAndRelyingParty(sd SessionData) (SessionData, error) {
+	var err error
+	// Sessions for usernameless logins don't have a user yet
+	if sd.UserID != 0 {
+		sd.User, err = GetUser(sd.UserID)
 		if err != nil {
 			fmt.Println("Error retrieving User data for session")
 			return sd, err
 		}
-		err = db.Model(&sd).Related(&sd.RelyingParty).Error
-		if err != nil {
-			fmt.Println("Error retrieving RP data for session")
-			return sd, err
-		}
-	*/
-	return sd, nil
-}
-
-// GetSessionByUsername returns the user that the given username corresponds to. If no user is found, an
-// error is thrown.
-func GetSessionByUsername(username string) (User, error) {
-	u := User{}
-	//err := db.Where("username = ?", username).First(&u).Error
-	sessions := db.From("sessions")
-	err := sessions.One("Username", username, &u)
-	if err == storm.ErrNotFound {
-		return u, nil
 	}
-	// No issue if we don't find a record
-	/*
-		if err == gorm.ErrRecordNotFound {
-			return u, nil
-		} else if err == nil {
-			return u, ErrUsernameTaken
-		}
-	*/
-	return u, err
+	sd.RelyingParty, err = GetRelyingPartyByHost(sd.RelyingPartyID)
+	if err != nil {
+		fmt.Println("Error retrieving RP data for session")
+		return sd, err
+	}
+	return sd, nil
 }
 
 // GetSessionForRequest gets the stored session data for a provided request.
@@ -144,9 +160,46 @@ func GetSessionForRequest(r *http.Request, store *sessions.CookieStore) (Session
 
 // PutSession - Update or Create SessionData
 func PutSession(sd *SessionData) error {
-	sessions := db.From("sessions")
-	err := sessions.Save(sd)
-	return err
+	return db.PutSession(sd)
+}
+
+// DeleteExpiredSessions deletes the sessions whose challenge has expired
+// and the expired login sessions, and returns how many were deleted
+func DeleteExpiredSessions() (int, error) {
+	now := time.Now()
+	deleted, err := db.DeleteExpiredSessions(now)
+	if err != nil {
+		return deleted, err
+	}
+	logins, err := db.DeleteExpiredLoginSessions(now)
+	return deleted + logins, err
+}
+
+// StartSessionSweeper deletes expired sessions and login sessions every
+// interval until the returned function is called
+func StartSessionSweeper(interval time.Duration) func() {
+	if interval <= 0 {
+		interval = DefaultSessionSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				deleted, err := DeleteExpiredSessions()
+				if err != nil {
+					log.Println("Error deleting expired sessions:", err)
+				} else if deleted > 0 {
+					log.Println("Deleted expired sessions:", deleted)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
 }
 
 // CreateChallenge - Create a new challenge to be sent to the authenticator
@@ -4,10 +4,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"time"
 
 	"github.com/gorilla/sessions"
 
-	"github.com/duo-labs/webauthn/config"
+	"git.jba.io/go/webauthn/config"
 )
 
 func (ms *ModelsSuite) TestCreateChallenge() {
@@ -36,7 +37,7 @@ func (ms *ModelsSuite) getUserAndRelyingParty() (*User, *RelyingParty) {
 func (ms *ModelsSuite) TestCreateNewSession() {
 	u, rp := ms.getUserAndRelyingParty()
 	st := "invalid"
-	_, err := CreateNewSession(u, rp, st)
+	_, err := CreateNewSession(u, rp, st, "", DefaultChallengeTimeout)
 	if err != ErrInvalidSessionType {
 		ms.T().Fatalf("Unexpected error received when creating invalid session: %s", err)
 	}
@@ -49,14 +50,19 @@ func (ms *ModelsSuite) TestCreateNewSession() {
 		SessionType:    st,
 	}
 
-	got, err := CreateNewSession(u, rp, expected.SessionType)
+	got, err := CreateNewSession(u, rp, expected.SessionType, "", DefaultChallengeTimeout)
 	if err != nil {
 		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
 	}
-	// We will just copy the gorm.Model value and the challenge since those are generated
-	// at the time of save.
+	// We will just copy the ID, the challenge and the timestamps since those
+	// are generated at the time of save.
 	expected.Challenge = got.Challenge
-	expected.Model = got.Model
+	expected.ID = got.ID
+	expected.IssuedAt = got.IssuedAt
+	expected.ExpiresAt = got.ExpiresAt
+	if got.ExpiresAt.Sub(got.IssuedAt) != DefaultChallengeTimeout {
+		ms.T().Fatalf("Unexpected session lifetime. Expected %s, Got %s", DefaultChallengeTimeout, got.ExpiresAt.Sub(got.IssuedAt))
+	}
 	if !reflect.DeepEqual(expected, got) {
 		ms.T().Fatalf("Unexpected session received.\nExpected %#v\nGot %#v", expected, got)
 	}
@@ -65,7 +71,7 @@ func (ms *ModelsSuite) TestCreateNewSession() {
 func (ms *ModelsSuite) TestGetSessionByUsernameAndRelyingParty() {
 	u, rp := ms.getUserAndRelyingParty()
 	st := "reg"
-	expected, err := CreateNewSession(u, rp, st)
+	expected, err := CreateNewSession(u, rp, st, "", DefaultChallengeTimeout)
 	if err != nil {
 		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
 	}
@@ -74,7 +80,6 @@ func (ms *ModelsSuite) TestGetSessionByUsernameAndRelyingParty() {
 	if err != nil {
 		ms.T().Fatalf("Unexpected error received when getting valid sessions %s", err)
 	}
-	expected.Model = got.Model
 	if !reflect.DeepEqual(expected, got) {
 		ms.T().Fatalf("Unexpected session received.\nExpected %#v\nGot %#v", expected, got)
 	}
@@ -83,7 +88,7 @@ func (ms *ModelsSuite) TestGetSessionByUsernameAndRelyingParty() {
 func (ms *ModelsSuite) TestGetSessionData() {
 	u, rp := ms.getUserAndRelyingParty()
 	st := "reg"
-	expected, err := CreateNewSession(u, rp, st)
+	expected, err := CreateNewSession(u, rp, st, "", DefaultChallengeTimeout)
 	if err != nil {
 		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
 	}
@@ -92,11 +97,8 @@ func (ms *ModelsSuite) TestGetSessionData() {
 	if err != nil {
 		ms.T().Fatalf("Unexpected error received when getting valid sessions %s", err)
 	}
-	expected.Model = got.Model
-	// The way gorm loads models means that we need to copy this over to make sure
-	// it's deeply equal. Otherwise, we just have to compare most known fields which would be
-	// a lot of boilerplate.
-	expected.User = got.User
+	// GetSessionData loads the user and relying party of the session
+	expected.User = *u
 	expected.RelyingParty = *rp
 	if !reflect.DeepEqual(expected, got) {
 		ms.T().Fatalf("Unexpected session received.\nExpected\n%#v\nGot\n%#v", expected, got)
@@ -108,7 +110,7 @@ func (ms *ModelsSuite) TestGetSessionByUsername() {}
 func (ms *ModelsSuite) TestGetSessionForRequest() {
 	u, rp := ms.getUserAndRelyingParty()
 	st := "reg"
-	sd, err := CreateNewSession(u, rp, st)
+	sd, err := CreateNewSession(u, rp, st, "", DefaultChallengeTimeout)
 	if err != nil {
 		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
 	}
@@ -135,3 +137,108 @@ func (ms *ModelsSuite) TestGetSessionForRequest() {
 		ms.T().Fatalf("Unexpected session received.\nExpected\n%#v\nGot\n%#v", expected, got)
 	}
 }
+
+func (ms *ModelsSuite) TestConsumeSessionData() {
+	u, rp := ms.getUserAndRelyingParty()
+	sd, err := CreateNewSession(u, rp, "att", "", DefaultChallengeTimeout)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
+	}
+
+	got, err := ConsumeSessionData(sd.ID, "att")
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when consuming session %s", err)
+	}
+	if got.ID != sd.ID || got.User.ID != u.ID || got.RelyingParty.ID != rp.ID {
+		ms.T().Fatalf("Unexpected session received.\nExpected %#v\nGot %#v", sd, got)
+	}
+
+	// A challenge can only be used once
+	_, err = ConsumeSessionData(sd.ID, "att")
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error received when consuming session twice: %v", err)
+	}
+	_, err = GetSessionData(sd.ID)
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error received when getting consumed session: %v", err)
+	}
+
+	sd, err = CreateNewSession(u, rp, "reg", "", DefaultChallengeTimeout)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
+	}
+	_, err = ConsumeSessionData(sd.ID, "att")
+	if err != ErrSessionTypeMismatch {
+		ms.T().Fatalf("Unexpected error received when consuming session of the wrong type: %v", err)
+	}
+}
+
+func (ms *ModelsSuite) TestConsumeExpiredSession() {
+	u, rp := ms.getUserAndRelyingParty()
+	sd, err := CreateNewSession(u, rp, "att", "", -time.Second)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
+	}
+	_, err = ConsumeSessionData(sd.ID, "att")
+	if err != ErrSessionExpired {
+		ms.T().Fatalf("Unexpected error received when consuming expired session: %v", err)
+	}
+	// Expired sessions are deleted too
+	_, err = GetSessionData(sd.ID)
+	if err != ErrNotFound {
+		ms.T().Fatalf("Unexpected error received when getting expired session: %v", err)
+	}
+}
+
+func (ms *ModelsSuite) TestDeleteExpiredSessions() {
+	u, rp := ms.getUserAndRelyingParty()
+	valid, err := CreateNewSession(u, rp, "att", "", DefaultChallengeTimeout)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when creating new session %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = CreateNewSession(u, rp, "reg", "", -time.Second)
+		if err != nil {
+			ms.T().Fatalf("Unexpected error received when creating new session %s", err)
+		}
+	}
+	// Sessions stored before challenges expired have no expiry
+	legacy := SessionData{Challenge: []byte("legacy"), UserID: u.ID, RelyingPartyID: rp.ID, SessionType: "att"}
+	err = PutSession(&legacy)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when putting session %s", err)
+	}
+
+	deleted, err := DeleteExpiredSessions()
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when deleting expired sessions %s", err)
+	}
+	if deleted != 4 {
+		ms.T().Fatalf("Unexpected number of sessions deleted. Expected 4, Got %d", deleted)
+	}
+	_, err = GetSessionData(valid.ID)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error received when getting valid session %s", err)
+	}
+}
+
+func (ms *ModelsSuite) TestSessionUserVerification() {
+	u, rp := ms.getUserAndRelyingParty()
+	sd, err := CreateNewSession(u, rp, "att", "required", DefaultChallengeTimeout)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating session: %s", err)
+	}
+	got, err := ConsumeSessionData(sd.ID, "att")
+	if err != nil {
+		ms.T().Fatalf("Unexpected error consuming session: %s", err)
+	}
+	if got.UserVerification != "required" || !got.UserVerificationRequired() {
+		ms.T().Fatalf("Expected the user verification requirement to be kept. Got: %q", got.UserVerification)
+	}
+
+	// The relying party's requirement counts even when the session's doesn't
+	got = SessionData{UserVerification: "preferred", RelyingParty: RelyingParty{UserVerification: "required"}}
+	if !got.UserVerificationRequired() {
+		ms.T().Fatalf("Expected the relying party's requirement to count")
+	}
+}
@@ -0,0 +1,647 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Pure Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// SQLStore keeps everything in a SQLite database through database/sql
+type SQLStore struct {
+	db *sql.DB
+}
+
+// sqlMigration is a file of SQL statements in the migrations directory.
+// Files are named <version>_<description>.sql and run in version order.
+type sqlMigration struct {
+	Version int
+	Path    string
+}
+
+// OpenSQLStore opens or creates the SQLite database at path and runs the
+// migrations in the migrations directory that haven't run yet.
+func OpenSQLStore(path, migrations string) (*SQLStore, error) {
+	err := checkSQLiteFile(path)
+	if err != nil {
+		log.Println("OpenSQLStore error:", err)
+		return nil, err
+	}
+	sdb, err := sql.Open("sqlite", path)
+	if err != nil {
+		log.Println("OpenSQLStore error:", err)
+		return nil, err
+	}
+	// SQLite has a single writer, and every connection to :memory: gets its
+	// own database, so we stick to one connection.
+	sdb.SetMaxOpenConns(1)
+
+	s := &SQLStore{db: sdb}
+	err = s.migrate(migrations)
+	if err != nil {
+		sdb.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqliteHeader starts every SQLite database file
+const sqliteHeader = "SQLite format 3\x00"
+
+// checkSQLiteFile makes sure an existing file at path is a SQLite database.
+// Older versions always kept a storm database at db_path, whatever db_name
+// said, and opening it as SQLite would fail halfway through the migrations.
+func checkSQLiteFile(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	n, err := io.ReadFull(f, header)
+	if n == 0 {
+		// SQLite creates the database in an empty file
+		return nil
+	}
+	if err != nil || string(header) != sqliteHeader {
+		return fmt.Errorf("%s isn't a SQLite database. If it was created by an older version it's a storm database, set db_name to \"storm\" to keep using it", path)
+	}
+	return nil
+}
+
+// loadSQLMigrations lists the migrations in dir, oldest first
+func loadSQLMigrations(dir string) ([]sqlMigration, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("No migrations found in %s", dir)
+	}
+
+	migrations := []sqlMigration{}
+	seen := map[int]string{}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("Migration %s doesn't start with a version number", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("Migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+		migrations = append(migrations, sqlMigration{Version: version, Path: path})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrate runs the migrations in dir newer than the schema version
+func (s *SQLStore) migrate(dir string) error {
+	migrations, err := loadSQLMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		log.Println("Running migration", m.Path)
+		statements, err := os.ReadFile(m.Path)
+		if err != nil {
+			return err
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(string(statements))
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.Version, time.Now())
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %s failed: %s", m.Path, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlError maps database/sql's errors to ours
+func sqlError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// nullableID lets the database pick the ID of a new row
+func nullableID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+const userColumns = `id, handle, name, display_name, icon, role`
+
+func scanUser(row rowScanner) (User, error) {
+	u := User{}
+	err := row.Scan(&u.ID, &u.Handle, &u.Name, &u.DisplayName, &u.Icon, &u.Role)
+	return u, sqlError(err)
+}
+
+// GetUser gets a user by ID
+func (s *SQLStore) GetUser(id int64) (User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByUsername gets a user by name
+func (s *SQLStore) GetUserByUsername(name string) (User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE name = ?`, name))
+}
+
+// GetUserByHandle gets a user by user handle
+func (s *SQLStore) GetUserByHandle(handle []byte) (User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE handle = ?`, handle))
+}
+
+// PutUser creates or updates a user
+func (s *SQLStore) PutUser(u *User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE name = ? AND id != ?`, u.Name, u.ID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken != 0 {
+		return ErrUsernameTaken
+	}
+
+	result, err := tx.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET handle = excluded.handle, name = excluded.name,
+			display_name = excluded.display_name, icon = excluded.icon, role = excluded.role`,
+		nullableID(u.ID), u.Handle, u.Name, u.DisplayName, u.Icon, u.Role)
+	if err != nil {
+		return err
+	}
+	id := u.ID
+	if id == 0 {
+		id, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	u.ID = id
+	return nil
+}
+
+const rpColumns = `id, display_name, icon, counter_policy, origins, attestation,
+	user_verification, algorithms, authenticator_attachment, related_origins`
+
+func scanRelyingParty(row rowScanner) (RelyingParty, error) {
+	rp := RelyingParty{}
+	var origins, algorithms, relatedOrigins []byte
+	err := row.Scan(&rp.ID, &rp.DisplayName, &rp.Icon, &rp.CounterPolicy, &origins, &rp.Attestation,
+		&rp.UserVerification, &algorithms, &rp.AuthenticatorAttachment, &relatedOrigins)
+	if err != nil {
+		return rp, sqlError(err)
+	}
+	err = json.Unmarshal(origins, &rp.Origins)
+	if err == nil {
+		err = json.Unmarshal(algorithms, &rp.Algorithms)
+	}
+	if err == nil {
+		err = json.Unmarshal(relatedOrigins, &rp.RelatedOrigins)
+	}
+	return rp, err
+}
+
+// GetRelyingParty gets a relying party by ID
+func (s *SQLStore) GetRelyingParty(id string) (RelyingParty, error) {
+	return scanRelyingParty(s.db.QueryRow(`SELECT `+rpColumns+` FROM relying_parties WHERE id = ?`, id))
+}
+
+// GetRelyingParties gets every relying party
+func (s *SQLStore) GetRelyingParties() ([]RelyingParty, error) {
+	rows, err := s.db.Query(`SELECT ` + rpColumns + ` FROM relying_parties ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rps := []RelyingParty{}
+	for rows.Next() {
+		rp, err := scanRelyingParty(rows)
+		if err != nil {
+			return nil, err
+		}
+		rps = append(rps, rp)
+	}
+	return rps, rows.Err()
+}
+
+// PutRelyingParty creates or updates a relying party
+func (s *SQLStore) PutRelyingParty(rp *RelyingParty) error {
+	// Lists are kept as JSON, with null for an empty list
+	origins, err := json.Marshal(rp.Origins)
+	if err != nil {
+		return err
+	}
+	algorithms, err := json.Marshal(rp.Algorithms)
+	if err != nil {
+		return err
+	}
+	relatedOrigins, err := json.Marshal(rp.RelatedOrigins)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO relying_parties (`+rpColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET display_name = excluded.display_name,
+			icon = excluded.icon, counter_policy = excluded.counter_policy,
+			origins = excluded.origins, attestation = excluded.attestation,
+			user_verification = excluded.user_verification, algorithms = excluded.algorithms,
+			authenticator_attachment = excluded.authenticator_attachment,
+			related_origins = excluded.related_origins`,
+		rp.ID, rp.DisplayName, rp.Icon, rp.CounterPolicy, origins, rp.Attestation,
+		rp.UserVerification, algorithms, rp.AuthenticatorAttachment, relatedOrigins)
+	return err
+}
+
+// DeleteRelyingParty deletes a relying party by ID
+func (s *SQLStore) DeleteRelyingParty(id string) error {
+	result, err := s.db.Exec(`DELETE FROM relying_parties WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+const credentialColumns = `id, cred_id, user_id, rp_id, created_at, sign_count, suspect,
+	type, format, flags, attestation_trust, public_key`
+
+func scanCredential(row rowScanner) (Credential, error) {
+	c := Credential{}
+	var publicKey []byte
+	err := row.Scan(&c.ID, &c.CredID, &c.UserID, &c.RelyingPartyID, &c.CreatedAt, &c.Counter, &c.Suspect,
+		&c.Type, &c.Format, &c.Flags, &c.AttestationTrust, &publicKey)
+	if err != nil {
+		return c, sqlError(err)
+	}
+	c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+	err = json.Unmarshal(publicKey, &c.PublicKey)
+	return c, err
+}
+
+func (s *SQLStore) queryCredentials(query string, args ...interface{}) ([]Credential, error) {
+	rows, err := s.db.Query(`SELECT `+credentialColumns+` FROM credentials `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	creds := []Credential{}
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+// CreateCredential stores a new credential
+func (s *SQLStore) CreateCredential(c *Credential) error {
+	publicKey, err := json.Marshal(c.PublicKey)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM credentials WHERE cred_id = ?`, c.CredID).Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing != 0 {
+		return ErrCredentialExists
+	}
+
+	result, err := tx.Exec(`INSERT INTO credentials (`+credentialColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(c.ID), c.CredID, c.UserID, c.RelyingPartyID, c.CreatedAt, c.Counter, c.Suspect,
+		c.Type, c.Format, c.Flags, c.AttestationTrust, publicKey)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	c.ID = id
+	c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+	return nil
+}
+
+// UpdateCredential updates a credential in a transaction
+func (s *SQLStore) UpdateCredential(id int64, update func(c *Credential) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c, err := scanCredential(tx.QueryRow(`SELECT `+credentialColumns+` FROM credentials WHERE id = ?`, id))
+	if err != nil {
+		return err
+	}
+	err = update(&c)
+	if err != nil {
+		return err
+	}
+	publicKey, err := json.Marshal(c.PublicKey)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE credentials SET cred_id = ?, user_id = ?, rp_id = ?, created_at = ?,
+		sign_count = ?, suspect = ?, type = ?, format = ?, flags = ?, attestation_trust = ?,
+		public_key = ? WHERE id = ?`,
+		c.CredID, c.UserID, c.RelyingPartyID, c.CreatedAt, c.Counter, c.Suspect,
+		c.Type, c.Format, c.Flags, c.AttestationTrust, publicKey, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCredential gets a credential by ID
+func (s *SQLStore) GetCredential(id int64) (Credential, error) {
+	return scanCredential(s.db.QueryRow(`SELECT `+credentialColumns+` FROM credentials WHERE id = ?`, id))
+}
+
+// GetCredentialByCredID gets a credential by credential ID
+func (s *SQLStore) GetCredentialByCredID(credID string) (Credential, error) {
+	return scanCredential(s.db.QueryRow(`SELECT `+credentialColumns+` FROM credentials WHERE cred_id = ?`, credID))
+}
+
+// GetCredentialsForUser gets the credentials of a user
+func (s *SQLStore) GetCredentialsForUser(userID int64) ([]Credential, error) {
+	return s.queryCredentials(`WHERE user_id = ? ORDER BY id`, userID)
+}
+
+// GetCredentialsForUserAndRelyingParty gets the credentials of a user for a relying party
+func (s *SQLStore) GetCredentialsForUserAndRelyingParty(userID int64, rpID string) ([]Credential, error) {
+	return s.queryCredentials(`WHERE user_id = ? AND rp_id = ? ORDER BY id`, userID, rpID)
+}
+
+// DeleteCredential deletes a credential by ID
+func (s *SQLStore) DeleteCredential(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM credentials WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+const sessionColumns = `id, challenge, origin, session_type, user_id, rp_id, issued_at, expires_at,
+	user_verification`
+
+func scanSession(row rowScanner) (SessionData, error) {
+	sd := SessionData{}
+	// Sessions from before migration 2 have no timestamps
+	var issuedAt, expiresAt sql.NullTime
+	err := row.Scan(&sd.ID, &sd.Challenge, &sd.Origin, &sd.SessionType, &sd.UserID, &sd.RelyingPartyID,
+		&issuedAt, &expiresAt, &sd.UserVerification)
+	sd.IssuedAt = issuedAt.Time
+	sd.ExpiresAt = expiresAt.Time
+	return sd, sqlError(err)
+}
+
+// PutSession creates or updates a session
+func (s *SQLStore) PutSession(sd *SessionData) error {
+	result, err := s.db.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET challenge = excluded.challenge, origin = excluded.origin,
+			session_type = excluded.session_type, user_id = excluded.user_id, rp_id = excluded.rp_id,
+			issued_at = excluded.issued_at, expires_at = excluded.expires_at,
+			user_verification = excluded.user_verification`,
+		nullableID(sd.ID), sd.Challenge, sd.Origin, sd.SessionType, sd.UserID, sd.RelyingPartyID,
+		sd.IssuedAt.UTC(), sd.ExpiresAt.UTC(), sd.UserVerification)
+	if err != nil {
+		return err
+	}
+	if sd.ID == 0 {
+		sd.ID, err = result.LastInsertId()
+	}
+	return err
+}
+
+// GetSession gets a session by ID
+func (s *SQLStore) GetSession(id int64) (SessionData, error) {
+	return scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+// GetLatestSession gets the last session of a user for a relying party
+func (s *SQLStore) GetLatestSession(userID int64, rpID string) (SessionData, error) {
+	return scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = ? AND rp_id = ? ORDER BY id DESC LIMIT 1`, userID, rpID))
+}
+
+// ConsumeSession deletes a session and returns it in a transaction
+func (s *SQLStore) ConsumeSession(id int64, now time.Time) (SessionData, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return SessionData{}, err
+	}
+	defer tx.Rollback()
+
+	sd, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if err != nil {
+		return sd, err
+	}
+	_, err = tx.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return sd, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return sd, err
+	}
+	if sd.Expired(now) {
+		return sd, ErrSessionExpired
+	}
+	return sd, nil
+}
+
+// DeleteExpiredSessions deletes the sessions expired at now
+func (s *SQLStore) DeleteExpiredSessions(now time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at IS NULL OR expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+const loginColumns = `id, token, user_id, credential_id, cred_id, rp_id, created_at, expires_at`
+
+func scanLoginSession(row rowScanner) (LoginSession, error) {
+	ls := LoginSession{}
+	err := row.Scan(&ls.ID, &ls.Token, &ls.UserID, &ls.CredentialID, &ls.CredID, &ls.RelyingPartyID,
+		&ls.CreatedAt, &ls.ExpiresAt)
+	return ls, sqlError(err)
+}
+
+// CreateLoginSession stores a new login session
+func (s *SQLStore) CreateLoginSession(ls *LoginSession) error {
+	result, err := s.db.Exec(`INSERT INTO login_sessions (`+loginColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(ls.ID), ls.Token, ls.UserID, ls.CredentialID, ls.CredID, ls.RelyingPartyID,
+		ls.CreatedAt.UTC(), ls.ExpiresAt.UTC())
+	if err != nil {
+		return err
+	}
+	ls.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetLoginSessionByToken gets a login session by its cookie token
+func (s *SQLStore) GetLoginSessionByToken(token string) (LoginSession, error) {
+	return scanLoginSession(s.db.QueryRow(`SELECT `+loginColumns+` FROM login_sessions WHERE token = ?`, token))
+}
+
+// GetLoginSessionsForUser gets the login sessions of a user
+func (s *SQLStore) GetLoginSessionsForUser(userID int64) ([]LoginSession, error) {
+	rows, err := s.db.Query(`SELECT `+loginColumns+` FROM login_sessions WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []LoginSession{}
+	for rows.Next() {
+		ls, err := scanLoginSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, ls)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteLoginSession deletes a login session by ID
+func (s *SQLStore) DeleteLoginSession(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM login_sessions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeleteExpiredLoginSessions deletes the login sessions expired at now
+func (s *SQLStore) DeleteExpiredLoginSessions(now time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM login_sessions WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+const auditColumns = `id, created_at, user_id, action, target, remote_addr, reason`
+
+// CreateAuditEntry stores a new audit entry
+func (s *SQLStore) CreateAuditEntry(e *AuditEntry) error {
+	result, err := s.db.Exec(`INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(e.ID), e.CreatedAt.UTC(), e.UserID, e.Action, e.Target, e.RemoteAddr, e.Reason)
+	if err != nil {
+		return err
+	}
+	e.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetAuditEntries gets every audit entry, oldest first
+func (s *SQLStore) GetAuditEntries() ([]AuditEntry, error) {
+	rows, err := s.db.Query(`SELECT ` + auditColumns + ` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		e := AuditEntry{}
+		err = rows.Scan(&e.ID, &e.CreatedAt, &e.UserID, &e.Action, &e.Target, &e.RemoteAddr, &e.Reason)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Close closes the database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeMigrations(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644)
+		if err != nil {
+			t.Fatalf("Unexpected error writing migration: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestLoadSQLMigrations(t *testing.T) {
+	dir := writeMigrations(t, "0010_later.sql", "0002_second.sql", "0001_first.sql", "README")
+	migrations, err := loadSQLMigrations(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error loading migrations: %s", err)
+	}
+	versions := []int{}
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	if len(versions) != 3 || versions[0] != 1 || versions[1] != 2 || versions[2] != 10 {
+		t.Fatalf("Unexpected migration order: %v", versions)
+	}
+
+	bad := map[string][]string{
+		"empty":     {},
+		"no number": {"first.sql"},
+		"duplicate": {"0001_first.sql", "1_again.sql"},
+	}
+	for name, files := range bad {
+		_, err := loadSQLMigrations(writeMigrations(t, files...))
+		if err == nil {
+			t.Fatalf("Expected an error for %s migrations", name)
+		}
+	}
+}
+
+func TestSQLMigrationsExist(t *testing.T) {
+	_, err := loadSQLMigrations("../db/db_sqlite3")
+	if err != nil {
+		t.Fatalf("Unexpected error loading the SQLite migrations: %s", err)
+	}
+}
+
+func TestOpenSQLStoreRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webauthn.db")
+	err := os.WriteFile(path, []byte("not a SQLite database"), 0600)
+	if err != nil {
+		t.Fatalf("Unexpected error writing file: %s", err)
+	}
+	_, err = OpenSQLStore(path, "../db/db_sqlite3")
+	if err == nil || !strings.Contains(err.Error(), "storm") {
+		t.Fatalf("Expected an error opening a file that isn't SQLite. Got: %v", err)
+	}
+}
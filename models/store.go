@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned by every Store when a record doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// Store is where users, relying parties, credentials and sessions are kept.
+// The package level functions in this package use the Store opened by Setup.
+type Store interface {
+	// GetUser, GetUserByUsername and GetUserByHandle return ErrNotFound
+	// when there is no such user
+	GetUser(id int64) (User, error)
+	GetUserByUsername(name string) (User, error)
+	GetUserByHandle(handle []byte) (User, error)
+	// PutUser creates the user when its ID is 0 and sets the ID,
+	// otherwise it creates or replaces the user with that ID. Names are
+	// unique, it returns ErrUsernameTaken when another user has the name.
+	PutUser(u *User) error
+
+	GetRelyingParty(id string) (RelyingParty, error)
+	// GetRelyingParties returns every relying party ordered by ID
+	GetRelyingParties() ([]RelyingParty, error)
+	PutRelyingParty(rp *RelyingParty) error
+	DeleteRelyingParty(id string) error
+
+	// CreateCredential stores a new credential and sets its ID. It returns
+	// ErrCredentialExists when the credential ID is already registered.
+	CreateCredential(c *Credential) error
+	// UpdateCredential reads the credential with the given ID, calls update
+	// with it and stores the result, all in one transaction. Nothing is
+	// stored when update returns an error.
+	UpdateCredential(id int64, update func(c *Credential) error) error
+	GetCredential(id int64) (Credential, error)
+	GetCredentialByCredID(credID string) (Credential, error)
+	GetCredentialsForUser(userID int64) ([]Credential, error)
+	GetCredentialsForUserAndRelyingParty(userID int64, rpID string) ([]Credential, error)
+	DeleteCredential(id int64) error
+
+	// PutSession creates the session when its ID is 0 and sets the ID
+	PutSession(sd *SessionData) error
+	GetSession(id int64) (SessionData, error)
+	// GetLatestSession returns the last session created for a user and relying party
+	GetLatestSession(userID int64, rpID string) (SessionData, error)
+	// ConsumeSession deletes the session and returns it, in one transaction
+	// so only one caller can get it. It returns ErrSessionExpired when the
+	// session expired at now, which is deleted all the same.
+	ConsumeSession(id int64, now time.Time) (SessionData, error)
+	// DeleteExpiredSessions deletes the sessions expired at now and returns
+	// how many were deleted
+	DeleteExpiredSessions(now time.Time) (int, error)
+
+	// CreateLoginSession stores a new login session and sets its ID
+	CreateLoginSession(ls *LoginSession) error
+	GetLoginSessionByToken(token string) (LoginSession, error)
+	GetLoginSessionsForUser(userID int64) ([]LoginSession, error)
+	DeleteLoginSession(id int64) error
+	// DeleteExpiredLoginSessions deletes the login sessions expired at now
+	// and returns how many were deleted
+	DeleteExpiredLoginSessions(now time.Time) (int, error)
+
+	// CreateAuditEntry stores a new audit entry and sets its ID
+	CreateAuditEntry(e *AuditEntry) error
+	GetAuditEntries() ([]AuditEntry, error)
+
+	Close() error
+}
+
+// Open opens the Store for a db_name from the config. path is the database
+// file for storm and SQLite, migrations is the directory of SQL migrations.
+func Open(name, path, migrations string) (Store, error) {
+	switch name {
+	case "", "storm", "boltdb":
+		if path == "" {
+			path = "webauthn.db"
+		}
+		return OpenStormStore(path)
+	case "memory":
+		return NewMemoryStore(), nil
+	case "sqlite3":
+		return OpenSQLStore(path, migrations)
+	}
+	return nil, fmt.Errorf("Unknown db_name %q, use storm, memory or sqlite3", name)
+}
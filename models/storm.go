@@ -0,0 +1,456 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// StormStore keeps everything in a BoltDB file through storm
+type StormStore struct {
+	db *storm.DB
+}
+
+// stormMigrations bring a storm database created by an older version up to
+// date. They run in order, the number of the last one that ran is kept in
+// the "migrations" bucket.
+var stormMigrations = []func(s *StormStore) error{
+	// 1. Names are unique now, older versions could store the same one twice
+	(*StormStore).renameDuplicateUsers,
+	// 2. Users created before user handles existed need one
+	(*StormStore).backfillUserHandles,
+	// 3. Build the user indexes and the (UserID, RelyingPartyID) credential index
+	(*StormStore).reindex,
+}
+
+// OpenStormStore opens or creates the storm database at path
+func OpenStormStore(path string) (*StormStore, error) {
+	sdb, err := storm.Open(path)
+	if err != nil {
+		log.Println("OpenStormStore error:", err)
+		return nil, err
+	}
+	s := &StormStore{db: sdb}
+	err = s.migrate()
+	if err != nil {
+		sdb.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate runs the migrations that haven't run on this database yet
+func (s *StormStore) migrate() error {
+	version := 0
+	err := s.db.Get("migrations", "version", &version)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for version < len(stormMigrations) {
+		log.Println("Running storm migration", version+1)
+		err = stormMigrations[version](s)
+		if err != nil {
+			return fmt.Errorf("Storm migration %d failed: %s", version+1, err)
+		}
+		version++
+		err = s.db.Set("migrations", "version", version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StormStore) renameDuplicateUsers() error {
+	users := []User{}
+	err := s.db.From("users").All(&users)
+	if err != nil {
+		return err
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	taken := map[string]bool{}
+	for i := range users {
+		taken[users[i].Name] = true
+	}
+	// The user with the lowest ID keeps the name, the others get their ID
+	// appended to it
+	seen := map[string]bool{}
+	for i := range users {
+		name := users[i].Name
+		if !seen[name] {
+			seen[name] = true
+			continue
+		}
+		renamed := fmt.Sprintf("%s-%d", name, users[i].ID)
+		for taken[renamed] {
+			renamed += "-"
+		}
+		taken[renamed] = true
+		log.Println("Renaming duplicate user", name, "with ID", users[i].ID, "to", renamed)
+		users[i].Name = renamed
+		err = s.db.From("users").Save(&users[i])
+		if err != nil {
+			return fmt.Errorf("Failed renaming duplicate user %s with ID %d: %s", name, users[i].ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *StormStore) backfillUserHandles() error {
+	users := []User{}
+	err := s.db.From("users").All(&users)
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		if len(users[i].Handle) != 0 {
+			continue
+		}
+		log.Println("Creating a user handle for", users[i].Name)
+		users[i].Handle, err = NewUserHandle()
+		if err != nil {
+			return err
+		}
+		err = s.PutUser(&users[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StormStore) reindex() error {
+	err := s.db.From("users").ReIndex(&User{})
+	if err != nil {
+		return err
+	}
+	credsDB := s.db.From("credentials")
+	creds := []Credential{}
+	err = credsDB.All(&creds)
+	if err != nil {
+		return err
+	}
+	for i := range creds {
+		creds[i].UserRelyingParty = userRelyingPartyKey(creds[i].UserID, creds[i].RelyingPartyID)
+		err = credsDB.Save(&creds[i])
+		if err != nil {
+			return err
+		}
+	}
+	return credsDB.ReIndex(&Credential{})
+}
+
+// stormError maps storm's errors to ours
+func stormError(err error) error {
+	if err == storm.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// GetUser gets a user by ID
+func (s *StormStore) GetUser(id int64) (User, error) {
+	u := User{}
+	err := s.db.From("users").One("ID", id, &u)
+	return u, stormError(err)
+}
+
+// GetUserByUsername gets a user by name
+func (s *StormStore) GetUserByUsername(name string) (User, error) {
+	u := User{}
+	err := s.db.From("users").One("Name", name, &u)
+	return u, stormError(err)
+}
+
+// GetUserByHandle gets a user by user handle
+func (s *StormStore) GetUserByHandle(handle []byte) (User, error) {
+	u := User{}
+	err := s.db.From("users").One("Handle", handle, &u)
+	return u, stormError(err)
+}
+
+// PutUser creates or updates a user
+func (s *StormStore) PutUser(u *User) error {
+	err := s.db.From("users").Save(u)
+	if err == storm.ErrAlreadyExists {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// GetRelyingParty gets a relying party by ID
+func (s *StormStore) GetRelyingParty(id string) (RelyingParty, error) {
+	rp := RelyingParty{}
+	err := s.db.From("rps").One("ID", id, &rp)
+	return rp, stormError(err)
+}
+
+// PutRelyingParty creates or updates a relying party
+func (s *StormStore) PutRelyingParty(rp *RelyingParty) error {
+	return s.db.From("rps").Save(rp)
+}
+
+// GetRelyingParties gets every relying party
+func (s *StormStore) GetRelyingParties() ([]RelyingParty, error) {
+	rps := []RelyingParty{}
+	err := s.db.From("rps").All(&rps)
+	return rps, err
+}
+
+// DeleteRelyingParty deletes a relying party by ID
+func (s *StormStore) DeleteRelyingParty(id string) error {
+	rp, err := s.GetRelyingParty(id)
+	if err != nil {
+		return err
+	}
+	return s.db.From("rps").DeleteStruct(&rp)
+}
+
+// CreateCredential stores a new credential
+func (s *StormStore) CreateCredential(c *Credential) error {
+	tx, err := s.db.From("credentials").Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing := Credential{}
+	err = tx.One("CredID", c.CredID, &existing)
+	if err == nil {
+		return ErrCredentialExists
+	}
+	if err != storm.ErrNotFound {
+		return err
+	}
+
+	c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+	err = tx.Save(c)
+	if err == storm.ErrAlreadyExists {
+		return ErrCredentialExists
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateCredential updates a credential in a transaction
+func (s *StormStore) UpdateCredential(id int64, update func(c *Credential) error) error {
+	tx, err := s.db.From("credentials").Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	c := Credential{}
+	err = tx.One("ID", id, &c)
+	if err != nil {
+		return stormError(err)
+	}
+	err = update(&c)
+	if err != nil {
+		return err
+	}
+	c.UserRelyingParty = userRelyingPartyKey(c.UserID, c.RelyingPartyID)
+	err = tx.Save(&c)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCredential gets a credential by ID
+func (s *StormStore) GetCredential(id int64) (Credential, error) {
+	c := Credential{}
+	err := s.db.From("credentials").One("ID", id, &c)
+	return c, stormError(err)
+}
+
+// GetCredentialByCredID gets a credential by credential ID
+func (s *StormStore) GetCredentialByCredID(credID string) (Credential, error) {
+	c := Credential{}
+	err := s.db.From("credentials").One("CredID", credID, &c)
+	return c, stormError(err)
+}
+
+// GetCredentialsForUser gets the credentials of a user
+func (s *StormStore) GetCredentialsForUser(userID int64) ([]Credential, error) {
+	creds := []Credential{}
+	err := s.db.From("credentials").Find("UserID", userID, &creds)
+	if err == storm.ErrNotFound {
+		return []Credential{}, nil
+	}
+	return creds, err
+}
+
+// GetCredentialsForUserAndRelyingParty gets the credentials of a user for a relying party
+func (s *StormStore) GetCredentialsForUserAndRelyingParty(userID int64, rpID string) ([]Credential, error) {
+	creds := []Credential{}
+	err := s.db.From("credentials").Find("UserRelyingParty", userRelyingPartyKey(userID, rpID), &creds)
+	if err == storm.ErrNotFound {
+		return []Credential{}, nil
+	}
+	return creds, err
+}
+
+// DeleteCredential deletes a credential by ID
+func (s *StormStore) DeleteCredential(id int64) error {
+	c, err := s.GetCredential(id)
+	if err != nil {
+		return err
+	}
+	return s.db.From("credentials").DeleteStruct(&c)
+}
+
+// PutSession creates or updates a session
+func (s *StormStore) PutSession(sd *SessionData) error {
+	return s.db.From("sessions").Save(sd)
+}
+
+// GetSession gets a session by ID
+func (s *StormStore) GetSession(id int64) (SessionData, error) {
+	sd := SessionData{}
+	err := s.db.From("sessions").One("ID", id, &sd)
+	return sd, stormError(err)
+}
+
+// GetLatestSession gets the last session of a user for a relying party
+func (s *StormStore) GetLatestSession(userID int64, rpID string) (SessionData, error) {
+	sd := SessionData{}
+	err := s.db.From("sessions").Select(q.Eq("UserID", userID), q.Eq("RelyingPartyID", rpID)).OrderBy("ID").Reverse().First(&sd)
+	return sd, stormError(err)
+}
+
+// ConsumeSession deletes a session and returns it in a transaction
+func (s *StormStore) ConsumeSession(id int64, now time.Time) (SessionData, error) {
+	tx, err := s.db.From("sessions").Begin(true)
+	if err != nil {
+		return SessionData{}, err
+	}
+	defer tx.Rollback()
+
+	sd := SessionData{}
+	err = tx.One("ID", id, &sd)
+	if err != nil {
+		return sd, stormError(err)
+	}
+	err = tx.DeleteStruct(&sd)
+	if err != nil {
+		return sd, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return sd, err
+	}
+	if sd.Expired(now) {
+		return sd, ErrSessionExpired
+	}
+	return sd, nil
+}
+
+// DeleteExpiredSessions deletes the sessions expired at now
+func (s *StormStore) DeleteExpiredSessions(now time.Time) (int, error) {
+	tx, err := s.db.From("sessions").Begin(true)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	expired := []SessionData{}
+	err = tx.All(&expired)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range expired {
+		if !expired[i].Expired(now) {
+			continue
+		}
+		err = tx.DeleteStruct(&expired[i])
+		if err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+	return deleted, tx.Commit()
+}
+
+// CreateLoginSession stores a new login session
+func (s *StormStore) CreateLoginSession(ls *LoginSession) error {
+	return s.db.From("logins").Save(ls)
+}
+
+// GetLoginSessionByToken gets a login session by its cookie token
+func (s *StormStore) GetLoginSessionByToken(token string) (LoginSession, error) {
+	ls := LoginSession{}
+	err := s.db.From("logins").One("Token", token, &ls)
+	return ls, stormError(err)
+}
+
+// GetLoginSessionsForUser gets the login sessions of a user
+func (s *StormStore) GetLoginSessionsForUser(userID int64) ([]LoginSession, error) {
+	sessions := []LoginSession{}
+	err := s.db.From("logins").Find("UserID", userID, &sessions)
+	if err == storm.ErrNotFound {
+		return []LoginSession{}, nil
+	}
+	return sessions, err
+}
+
+// DeleteLoginSession deletes a login session by ID
+func (s *StormStore) DeleteLoginSession(id int64) error {
+	ls := LoginSession{}
+	err := s.db.From("logins").One("ID", id, &ls)
+	if err != nil {
+		return stormError(err)
+	}
+	return s.db.From("logins").DeleteStruct(&ls)
+}
+
+// DeleteExpiredLoginSessions deletes the login sessions expired at now
+func (s *StormStore) DeleteExpiredLoginSessions(now time.Time) (int, error) {
+	tx, err := s.db.From("logins").Begin(true)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	sessions := []LoginSession{}
+	err = tx.All(&sessions)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range sessions {
+		if !sessions[i].Expired(now) {
+			continue
+		}
+		err = tx.DeleteStruct(&sessions[i])
+		if err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+	return deleted, tx.Commit()
+}
+
+// CreateAuditEntry stores a new audit entry
+func (s *StormStore) CreateAuditEntry(e *AuditEntry) error {
+	return s.db.From("audit").Save(e)
+}
+
+// GetAuditEntries gets every audit entry, oldest first
+func (s *StormStore) GetAuditEntries() ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := s.db.From("audit").All(&entries)
+	return entries, err
+}
+
+// Close closes the database file
+func (s *StormStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/asdine/storm"
+)
+
+func TestStormMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webauthn.db")
+
+	// Store a user and a credential the way older versions did
+	old, err := storm.Open(path)
+	if err != nil {
+		t.Fatalf("Failed creating database: %v", err)
+	}
+	err = old.From("users").Save(&User{ID: 7, Name: "old@example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error saving user: %s", err)
+	}
+	err = old.From("credentials").Save(&Credential{ID: 3, CredID: "old", UserID: 7, RelyingPartyID: "localhost"})
+	if err != nil {
+		t.Fatalf("Unexpected error saving credential: %s", err)
+	}
+	old.Close()
+
+	s, err := OpenStormStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error running migrations: %s", err)
+	}
+	u, err := s.GetUserByUsername("old@example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error getting user by name: %s", err)
+	}
+	if len(u.Handle) != UserHandleLength {
+		t.Fatalf("Unexpected user handle length. Expected: %d, Got: %d", UserHandleLength, len(u.Handle))
+	}
+	creds, err := s.GetCredentialsForUserAndRelyingParty(7, "localhost")
+	if err != nil || len(creds) != 1 {
+		t.Fatalf("Unexpected credentials received: %d, %v", len(creds), err)
+	}
+	s.Close()
+
+	// Migrations only run once, so the handle stays the same
+	s, err = OpenStormStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening database again: %s", err)
+	}
+	defer s.Close()
+	again, err := s.GetUser(7)
+	if err != nil {
+		t.Fatalf("Unexpected error getting user by ID: %s", err)
+	}
+	if string(again.Handle) != string(u.Handle) {
+		t.Fatalf("User handle changed when opening the database again")
+	}
+}
+
+func TestStormMigrationsDuplicateNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webauthn.db")
+
+	// Older versions could store two users with the same name
+	old, err := storm.Open(path)
+	if err != nil {
+		t.Fatalf("Failed creating database: %v", err)
+	}
+	for _, id := range []int64{4, 2} {
+		err = old.From("users").Save(&User{ID: id, Name: "twice@example.com"})
+		if err != nil {
+			t.Fatalf("Unexpected error saving user: %s", err)
+		}
+	}
+	old.Close()
+
+	s, err := OpenStormStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error running migrations: %s", err)
+	}
+	defer s.Close()
+	u, err := s.GetUserByUsername("twice@example.com")
+	if err != nil || u.ID != 2 {
+		t.Fatalf("Expected the first user to keep the name. Got: %#v, %v", u, err)
+	}
+	u, err = s.GetUserByUsername("twice@example.com-4")
+	if err != nil || u.ID != 4 {
+		t.Fatalf("Expected the second user to be renamed. Got: %#v, %v", u, err)
+	}
+}
@@ -1,73 +1,80 @@
 package models
 
 import (
+	"crypto/rand"
 	"log"
 )
 
+// UserHandleLength is the size of a user handle, the most the spec allows
+const UserHandleLength = 64
+
+// RoleAdmin lets a user manage other users and their credentials
+const RoleAdmin = "admin"
+
 // User represents the user model.
 type User struct {
-	ID             int64        `json:"id" storm:"id,increment"`
-	Name           string       `json:"name"`
+	ID int64 `json:"id" storm:"id,increment"`
+	// Handle is the opaque WebAuthn user handle (user.id) for this user.
+	// Authenticators store it with discoverable credentials and return it
+	// as the userHandle in assertions.
+	Handle         []byte       `json:"handle,omitempty" storm:"unique"`
+	Name           string       `json:"name" storm:"unique"`
 	DisplayName    string       `json:"display_name"`
 	Icon           string       `json:"icon,omitempty"`
+	Role           string       `json:"role,omitempty"`
 	Credentials    []Credential `json:"credentials,omitempty"`
 	RelyingParties []RelyingParty
 }
 
+// IsAdmin reports whether the user has the admin role
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
 // GetUser returns the user that the given id corresponds to. If no user is found, an
 // error is thrown.
 func GetUser(id int64) (User, error) {
-	u := User{}
-	//err := db.Where("id=?", id).Preload("Credential").Find(&u).Error
-	userDB := db.From("users")
-	err := userDB.One("ID", id, &u)
-	/*
-		users := []User{}
-		err := db.All(&users)
-		if err == nil {
-			for _, v := range users {
-				if v.ID == id {
-					u = v
-				}
-			}
-			return u, err
-		}
-	*/
-	if err != nil {
-		return u, err
-	}
-	return u, nil
+	return db.GetUser(id)
 }
 
 // GetUserByUsername returns the user that the given username corresponds to. If no user is found, an
 // error is thrown.
 func GetUserByUsername(username string) (User, error) {
-	u := User{}
-	//err := db.Where("name = ?", username).Preload("Credentials").Find(&u).Error
-	userDB := db.From("users")
-	err := userDB.One("Name", username, &u)
-	/*
-		users := []User{}
-		err := db.All(&users)
-		if err == nil {
-			for _, v := range users {
-				if v.Name == username {
-					u = v
-				}
-			}
-			return u, err
-		}
-	*/
+	u, err := db.GetUserByUsername(username)
 	log.Println("User:", u)
-
 	return u, err
 }
 
-// PutUser updates the given user
+// GetUserByHandle returns the user that the given user handle corresponds to. If no user
+// is found, an error is thrown.
+func GetUserByHandle(handle []byte) (User, error) {
+	return db.GetUserByHandle(handle)
+}
+
+// NewUserHandle creates a random user handle. It carries no information about
+// the user so it can be stored on authenticators and sent to clients.
+func NewUserHandle() ([]byte, error) {
+	handle := make([]byte, UserHandleLength)
+	_, err := rand.Read(handle)
+	if err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// PutUser updates the given user. New users get a random user handle. It
+// returns ErrUsernameTaken when another user has the name.
 func PutUser(u *User) error {
+	if len(u.Handle) == 0 {
+		handle, err := NewUserHandle()
+		if err != nil {
+			log.Println("PutUser/NewUserHandle error:", err)
+			return err
+		}
+		u.Handle = handle
+	}
 	log.Println(u)
-	userDB := db.From("users")
-	err := userDB.Save(u)
+	err := db.PutUser(u)
 	if err != nil {
 		log.Println("PutUser error:", err)
 	}
@@ -12,3 +12,47 @@ func (ms *ModelsSuite) TestCreateUser() {
 func (ms *ModelsSuite) TestPutUser() {
 
 }
+
+func (ms *ModelsSuite) TestGetUserByHandle() {
+	handle, err := NewUserHandle()
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating user handle: %s", err)
+	}
+	if len(handle) != UserHandleLength {
+		ms.T().Fatalf("Unexpected user handle length. Expected: %d, Got: %d", UserHandleLength, len(handle))
+	}
+	u := User{
+		Name:        "handle@example.com",
+		DisplayName: "handle",
+		Handle:      handle,
+	}
+	err = PutUser(&u)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating user: %s", err)
+	}
+	got, err := GetUserByHandle(handle)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error getting user by handle: %s", err)
+	}
+	if got.ID != u.ID || got.Name != u.Name {
+		ms.T().Fatalf("Unexpected user received. Expected: %#v, Got: %#v", u, got)
+	}
+}
+
+func (ms *ModelsSuite) TestUniqueUsernames() {
+	u := User{Name: "unique@example.com"}
+	err := PutUser(&u)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error creating user: %s", err)
+	}
+	// Saving the same user again is fine
+	u.DisplayName = "Unique"
+	err = PutUser(&u)
+	if err != nil {
+		ms.T().Fatalf("Unexpected error updating user: %s", err)
+	}
+	err = PutUser(&User{Name: "unique@example.com"})
+	if err != ErrUsernameTaken {
+		ms.T().Fatalf("Unexpected error creating a user with a taken name. Expected: %s, Got: %v", ErrUsernameTaken, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"git.jba.io/go/webauthn/models"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// RelyingPartySettings are the settings of a relying party the admin API and
+// CLI accept
+type RelyingPartySettings struct {
+	ID                      string   `json:"id"`
+	DisplayName             string   `json:"display_name"`
+	Icon                    string   `json:"icon,omitempty"`
+	Origins                 []string `json:"origins"`
+	RelatedOrigins          []string `json:"related_origins"`
+	Attestation             string   `json:"attestation"`
+	UserVerification        string   `json:"user_verification"`
+	Algorithms              []int64  `json:"algorithms"`
+	AuthenticatorAttachment string   `json:"authenticator_attachment"`
+	CounterPolicy           string   `json:"counter_policy"`
+}
+
+// Apply - Replace the settings of rp, everything else about it is kept
+func (s *RelyingPartySettings) Apply(rp *models.RelyingParty) {
+	rp.DisplayName = s.DisplayName
+	rp.Icon = s.Icon
+	rp.Origins = s.Origins
+	rp.RelatedOrigins = s.RelatedOrigins
+	rp.Attestation = s.Attestation
+	rp.UserVerification = s.UserVerification
+	rp.Algorithms = s.Algorithms
+	rp.AuthenticatorAttachment = s.AuthenticatorAttachment
+	rp.CounterPolicy = s.CounterPolicy
+}
+
+// decodeRelyingPartySettings - Read the settings from a JSON request body
+func decodeRelyingPartySettings(w http.ResponseWriter, r *http.Request) (RelyingPartySettings, bool) {
+	settings := RelyingPartySettings{}
+	err := json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil {
+		JSONResponse(w, "Error decoding relying party settings", http.StatusBadRequest)
+		return settings, false
+	}
+	return settings, true
+}
+
+// ListRelyingParties - List every relying party, only for admins
+func ListRelyingParties(w http.ResponseWriter, r *http.Request) {
+	if !RequireAdmin(w, r, "rp.list", "") {
+		return
+	}
+	rps, err := models.GetRelyingParties()
+	if err != nil {
+		fmt.Println("Error getting relying parties:", err)
+		JSONResponse(w, "Error getting relying parties", http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, res.FormatRelyingParties(rps), http.StatusOK)
+}
+
+// GetRelyingParty - Get a relying party, only for admins
+func GetRelyingParty(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !RequireAdmin(w, r, "rp.get", id) {
+		return
+	}
+	rp, err := models.GetRelyingPartyByHost(id)
+	if err != nil {
+		JSONResponse(w, "Relying party not found", http.StatusNotFound)
+		return
+	}
+	JSONResponse(w, res.FormatRelyingParty(rp), http.StatusOK)
+}
+
+// CreateRelyingParty - Create a relying party, only for admins
+func CreateRelyingParty(w http.ResponseWriter, r *http.Request) {
+	if !RequireAdmin(w, r, "rp.create", "") {
+		return
+	}
+	settings, ok := decodeRelyingPartySettings(w, r)
+	if !ok {
+		return
+	}
+	rp := models.RelyingParty{ID: settings.ID}
+	settings.Apply(&rp)
+	err := models.CreateRelyingParty(&rp)
+	if err == models.ErrRelyingPartyExists {
+		JSONResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		JSONResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, res.FormatRelyingParty(rp), http.StatusCreated)
+}
+
+// UpdateRelyingParty - Replace the settings of a relying party, only for admins
+func UpdateRelyingParty(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !RequireAdmin(w, r, "rp.update", id) {
+		return
+	}
+	settings, ok := decodeRelyingPartySettings(w, r)
+	if !ok {
+		return
+	}
+	if settings.ID != "" && settings.ID != id {
+		JSONResponse(w, "The ID of a relying party can't be changed", http.StatusBadRequest)
+		return
+	}
+	rp, err := models.GetRelyingPartyByHost(id)
+	if err != nil {
+		JSONResponse(w, "Relying party not found", http.StatusNotFound)
+		return
+	}
+	settings.Apply(&rp)
+	err = models.PutRelyingParty(&rp)
+	if err != nil {
+		JSONResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, res.FormatRelyingParty(rp), http.StatusOK)
+}
+
+// DeleteRelyingParty - Delete a relying party, only for admins
+func DeleteRelyingParty(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !RequireAdmin(w, r, "rp.delete", id) {
+		return
+	}
+	err := models.DeleteRelyingParty(id)
+	if err == models.ErrNotFound {
+		JSONResponse(w, "Relying party not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error deleting relying party:", err)
+		JSONResponse(w, "Error deleting relying party", http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, "Success", http.StatusOK)
+}
+
+// RelatedOrigins - The /.well-known/webauthn document of the relying party
+// the request is for, listing the origins on other domains that may use its
+// RP ID. Browsers fetch it when a page asks for an RP ID that isn't its own
+// domain.
+func RelatedOrigins(w http.ResponseWriter, r *http.Request) {
+	rp, err := models.GetRelyingPartyByHost(RequestHost(r))
+	if err != nil || len(rp.RelatedOrigins) == 0 {
+		JSONResponse(w, "No related origins", http.StatusNotFound)
+		return
+	}
+	type WellKnownWebAuthn struct {
+		Origins []string `json:"origins"`
+	}
+	JSONResponse(w, WellKnownWebAuthn{Origins: rp.RelatedOrigins}, http.StatusOK)
+}
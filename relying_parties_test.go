@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"git.jba.io/go/webauthn/models"
+	res "git.jba.io/go/webauthn/response"
+)
+
+// doJSON sends v as the JSON body of a request
+func (hs *HandlersSuite) doJSON(method, path string, cookie *http.Cookie, v interface{}) *http.Response {
+	body, _ := json.Marshal(v)
+	req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error requesting %s %s: %s", method, path, err)
+	}
+	return resp
+}
+
+func (hs *HandlersSuite) TestRelyingPartyAPI() {
+	admin := hs.loginAdmin()
+	user := hs.login("rp-user@example.com")
+
+	settings := RelyingPartySettings{
+		ID:               "api.example.com",
+		DisplayName:      "API",
+		Origins:          []string{"https://api.example.com"},
+		UserVerification: "required",
+		Algorithms:       []int64{models.COSEAlgES256},
+	}
+	resp := hs.doJSON("POST", "/rp", user, settings)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		hs.T().Fatalf("Unexpected status creating an RP as a user. Expected %d, Got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	resp = hs.doJSON("POST", "/rp", admin, settings)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		hs.T().Fatalf("Unexpected status creating an RP. Expected %d, Got %d", http.StatusCreated, resp.StatusCode)
+	}
+	resp = hs.doJSON("POST", "/rp", admin, settings)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		hs.T().Fatalf("Unexpected status creating a duplicate RP. Expected %d, Got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	settings.Attestation = "bogus"
+	resp = hs.doJSON("PUT", "/rp/api.example.com", admin, settings)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		hs.T().Fatalf("Unexpected status with invalid settings. Expected %d, Got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	settings.Attestation = "direct"
+	resp = hs.doJSON("PUT", "/rp/api.example.com", admin, settings)
+	got := res.RelyingPartyResponse{}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || got.Attestation != "direct" || got.UserVerification != "required" {
+		hs.T().Fatalf("Unexpected update response %d %#v", resp.StatusCode, got)
+	}
+
+	hs.expectStatus("GET", "/rp", user, http.StatusForbidden)
+	hs.expectStatus("GET", "/rp", admin, http.StatusOK)
+	hs.expectStatus("GET", "/rp/api.example.com", admin, http.StatusOK)
+	hs.expectStatus("DELETE", "/rp/api.example.com", user, http.StatusForbidden)
+	hs.expectStatus("DELETE", "/rp/api.example.com", admin, http.StatusOK)
+	hs.expectStatus("GET", "/rp/api.example.com", admin, http.StatusNotFound)
+}
+
+func (hs *HandlersSuite) TestRelyingPartyCommand() {
+	out := &bytes.Buffer{}
+	err := RunCommand([]string{"rp", "add", "-id", "cli.example.com", "-origin", "https://cli.example.com", "-alg", "-7", "-alg", "-257"}, out)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error adding an RP: %s", err)
+	}
+	err = RunCommand([]string{"rp", "update", "-id", "cli.example.com", "-uv", "required"}, out)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error updating an RP: %s", err)
+	}
+	rp, err := models.GetRelyingPartyByHost("cli.example.com")
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting the RP: %s", err)
+	}
+	// Settings that weren't given to update are kept
+	if rp.UserVerification != "required" || len(rp.Origins) != 1 || len(rp.Algorithms) != 2 {
+		hs.T().Fatalf("Unexpected RP after update: %#v", rp)
+	}
+	err = RunCommand([]string{"rp", "delete", "-id", "cli.example.com"}, out)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error deleting an RP: %s", err)
+	}
+	err = RunCommand([]string{"rp", "delete", "-id", "cli.example.com"}, out)
+	if err == nil {
+		hs.T().Fatalf("Expected an error deleting a missing RP")
+	}
+}
+
+func (hs *HandlersSuite) TestRelatedOrigins() {
+	rp, err := models.GetDefaultRelyingParty()
+	if err != nil {
+		hs.T().Fatalf("Unexpected error getting the default RP: %s", err)
+	}
+	// Browsers fetch the document from the RP ID's domain
+	wellKnown := func() *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/.well-known/webauthn", nil)
+		req.Host = rp.ID
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			hs.T().Fatalf("Unexpected error getting the related origins: %s", err)
+		}
+		return resp
+	}
+	resp := wellKnown()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		hs.T().Fatalf("Unexpected status without related origins. Expected %d, Got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	rp.RelatedOrigins = []string{"https://example.org", "https://example.net"}
+	err = models.PutRelyingParty(&rp)
+	if err != nil {
+		hs.T().Fatalf("Unexpected error saving the RP: %s", err)
+	}
+	defer func() {
+		rp.RelatedOrigins = nil
+		models.PutRelyingParty(&rp)
+	}()
+
+	resp = wellKnown()
+	defer resp.Body.Close()
+	doc := struct {
+		Origins []string `json:"origins"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&doc)
+	if resp.StatusCode != http.StatusOK || len(doc.Origins) != 2 || doc.Origins[0] != "https://example.org" {
+		hs.T().Fatalf("Unexpected related origins document %d %#v", resp.StatusCode, doc)
+	}
+}
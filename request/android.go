@@ -0,0 +1,110 @@
+package request
+
+import "encoding/asn1"
+
+// AndroidSecurityLevel is where an Android key lives
+type AndroidSecurityLevel int
+
+// The Android Keystore security levels
+const (
+	AndroidSecuritySoftware           AndroidSecurityLevel = 0
+	AndroidSecurityTrustedEnvironment AndroidSecurityLevel = 1
+	AndroidSecurityStrongBox          AndroidSecurityLevel = 2
+)
+
+// String returns the name Android uses for the security level
+func (l AndroidSecurityLevel) String() string {
+	switch l {
+	case AndroidSecuritySoftware:
+		return "Software"
+	case AndroidSecurityTrustedEnvironment:
+		return "TrustedEnvironment"
+	case AndroidSecurityStrongBox:
+		return "StrongBox"
+	}
+	return "Unknown"
+}
+
+// AndroidKeyDescription is the Android key attestation extension
+// (OID 1.3.6.1.4.1.11129.2.1.17) found in an "android-key" attestation
+// certificate. It describes the key the certificate was issued for.
+type AndroidKeyDescription struct {
+	AttestationVersion       int
+	AttestationSecurityLevel asn1.Enumerated
+	KeymasterVersion         int
+	KeymasterSecurityLevel   asn1.Enumerated
+	AttestationChallenge     []byte
+	UniqueID                 []byte
+	SoftwareEnforced         AndroidAuthorizationList
+	TeeEnforced              AndroidAuthorizationList
+}
+
+// SecurityLevel is where the attested key is stored, used by policies
+// that only accept keys from a TEE or StrongBox
+func (kd *AndroidKeyDescription) SecurityLevel() AndroidSecurityLevel {
+	return AndroidSecurityLevel(kd.AttestationSecurityLevel)
+}
+
+// AndroidAuthorizationList are the properties of an Android key. Every tag
+// Keymaster and KeyMint define is listed so the ones we care about can be
+// found in the SEQUENCE regardless of what comes before them.
+type AndroidAuthorizationList struct {
+	Purpose                     []int              `asn1:"tag:1,explicit,set,optional"`
+	Algorithm                   int                `asn1:"tag:2,explicit,optional"`
+	KeySize                     int                `asn1:"tag:3,explicit,optional"`
+	BlockMode                   []int              `asn1:"tag:4,explicit,set,optional"`
+	Digest                      []int              `asn1:"tag:5,explicit,set,optional"`
+	Padding                     []int              `asn1:"tag:6,explicit,set,optional"`
+	CallerNonce                 asn1.RawValue      `asn1:"tag:7,explicit,optional"`
+	MinMacLength                int                `asn1:"tag:8,explicit,optional"`
+	EcCurve                     int                `asn1:"tag:10,explicit,optional"`
+	RsaPublicExponent           int                `asn1:"tag:200,explicit,optional"`
+	MgfDigest                   []int              `asn1:"tag:203,explicit,set,optional"`
+	RollbackResistance          asn1.RawValue      `asn1:"tag:303,explicit,optional"`
+	EarlyBootOnly               asn1.RawValue      `asn1:"tag:305,explicit,optional"`
+	ActiveDateTime              int64              `asn1:"tag:400,explicit,optional"`
+	OriginationExpireDateTime   int64              `asn1:"tag:401,explicit,optional"`
+	UsageExpireDateTime         int64              `asn1:"tag:402,explicit,optional"`
+	UsageCountLimit             int                `asn1:"tag:405,explicit,optional"`
+	NoAuthRequired              asn1.RawValue      `asn1:"tag:503,explicit,optional"`
+	UserAuthType                int                `asn1:"tag:504,explicit,optional"`
+	AuthTimeout                 int                `asn1:"tag:505,explicit,optional"`
+	AllowWhileOnBody            asn1.RawValue      `asn1:"tag:506,explicit,optional"`
+	TrustedUserPresenceRequired asn1.RawValue      `asn1:"tag:507,explicit,optional"`
+	TrustedConfirmationRequired asn1.RawValue      `asn1:"tag:508,explicit,optional"`
+	UnlockedDeviceRequired      asn1.RawValue      `asn1:"tag:509,explicit,optional"`
+	AllApplications             asn1.RawValue      `asn1:"tag:600,explicit,optional"`
+	ApplicationID               []byte             `asn1:"tag:601,explicit,optional"`
+	CreationDateTime            int64              `asn1:"tag:701,explicit,optional"`
+	Origin                      int                `asn1:"tag:702,explicit,optional,default:-1"`
+	RollbackResistant           asn1.RawValue      `asn1:"tag:703,explicit,optional"`
+	RootOfTrust                 AndroidRootOfTrust `asn1:"tag:704,explicit,optional"`
+	OsVersion                   int                `asn1:"tag:705,explicit,optional"`
+	OsPatchLevel                int                `asn1:"tag:706,explicit,optional"`
+	AttestationApplicationID    []byte             `asn1:"tag:709,explicit,optional"`
+	AttestationIDBrand          []byte             `asn1:"tag:710,explicit,optional"`
+	AttestationIDDevice         []byte             `asn1:"tag:711,explicit,optional"`
+	AttestationIDProduct        []byte             `asn1:"tag:712,explicit,optional"`
+	AttestationIDSerial         []byte             `asn1:"tag:713,explicit,optional"`
+	AttestationIDImei           []byte             `asn1:"tag:714,explicit,optional"`
+	AttestationIDMeid           []byte             `asn1:"tag:715,explicit,optional"`
+	AttestationIDManufacturer   []byte             `asn1:"tag:716,explicit,optional"`
+	AttestationIDModel          []byte             `asn1:"tag:717,explicit,optional"`
+	VendorPatchLevel            int                `asn1:"tag:718,explicit,optional"`
+	BootPatchLevel              int                `asn1:"tag:719,explicit,optional"`
+	DeviceUniqueAttestation     asn1.RawValue      `asn1:"tag:720,explicit,optional"`
+}
+
+// AndroidRootOfTrust describes the verified boot state of the device
+type AndroidRootOfTrust struct {
+	VerifiedBootKey   []byte
+	DeviceLocked      bool
+	VerifiedBootState asn1.Enumerated
+	VerifiedBootHash  []byte `asn1:"optional"`
+}
+
+// HasAllApplications reports whether the key may be used by every application
+// on the device, in which case it isn't bound to the RP.
+func (al AndroidAuthorizationList) HasAllApplications() bool {
+	return len(al.AllApplications.FullBytes) != 0
+}
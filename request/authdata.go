@@ -0,0 +1,80 @@
+package request
+
+import "fmt"
+
+// AuthenticatorFlags is the flags byte of authenticator data
+type AuthenticatorFlags byte
+
+// The authenticator data flag bits
+const (
+	// FlagUserPresent (UP) - the user was present
+	FlagUserPresent AuthenticatorFlags = 1 << 0
+	// FlagUserVerified (UV) - the user was verified, with a PIN or biometric
+	FlagUserVerified AuthenticatorFlags = 1 << 2
+	// FlagBackupEligible (BE) - the credential can be backed up, like a synced passkey
+	FlagBackupEligible AuthenticatorFlags = 1 << 3
+	// FlagBackupState (BS) - the credential is currently backed up
+	FlagBackupState AuthenticatorFlags = 1 << 4
+	// FlagAttestedCredentialData (AT) - attested credential data follows the counter
+	FlagAttestedCredentialData AuthenticatorFlags = 1 << 6
+	// FlagExtensionData (ED) - an extensions map ends the authenticator data
+	FlagExtensionData AuthenticatorFlags = 1 << 7
+)
+
+// UserPresent reports whether the UP flag is set
+func (f AuthenticatorFlags) UserPresent() bool {
+	return f&FlagUserPresent != 0
+}
+
+// UserVerified reports whether the UV flag is set
+func (f AuthenticatorFlags) UserVerified() bool {
+	return f&FlagUserVerified != 0
+}
+
+// BackupEligible reports whether the BE flag is set
+func (f AuthenticatorFlags) BackupEligible() bool {
+	return f&FlagBackupEligible != 0
+}
+
+// BackupState reports whether the BS flag is set
+func (f AuthenticatorFlags) BackupState() bool {
+	return f&FlagBackupState != 0
+}
+
+// HasAttestedCredentialData reports whether the AT flag is set
+func (f AuthenticatorFlags) HasAttestedCredentialData() bool {
+	return f&FlagAttestedCredentialData != 0
+}
+
+// HasExtensions reports whether the ED flag is set
+func (f AuthenticatorFlags) HasExtensions() bool {
+	return f&FlagExtensionData != 0
+}
+
+// String formats the flags as bits, the way credentials store them
+func (f AuthenticatorFlags) String() string {
+	return fmt.Sprintf("%08b", byte(f))
+}
+
+// AuthenticatorData is the authenticator data both ceremonies sign over:
+//
+//	rpIdHash (32) | flags (1) | signCount (4) | attestedCredentialData | extensions
+type AuthenticatorData struct {
+	Raw      []byte
+	RPIDHash []byte
+	Flags    AuthenticatorFlags
+	// Counter is the signature counter, 0 if the authenticator doesn't have one
+	Counter uint32
+	// AttestedCredentialData is only set when the AT flag is
+	AttestedCredentialData *AttestedCredentialData
+	// Extensions are the authenticator extension outputs, only set when the ED flag is
+	Extensions map[string]interface{}
+}
+
+// AttestedCredentialData is the credential created during registration
+type AttestedCredentialData struct {
+	AAGUID       []byte
+	CredentialID []byte
+	// CredentialPublicKey is the CBOR encoded COSE_Key
+	CredentialPublicKey []byte
+}
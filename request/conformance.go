@@ -0,0 +1,31 @@
+package request
+
+// These are the request bodies of the FIDO2 server API the FIDO Alliance
+// conformance tools test against. The result endpoints take a
+// RegistrationResponseJSON or AuthenticationResponseJSON, the tools send
+// the extension results as getClientExtensionResults which we don't use.
+
+// ServerPublicKeyCredentialCreationOptionsRequest starts a registration
+type ServerPublicKeyCredentialCreationOptionsRequest struct {
+	Username               string                               `json:"username"`
+	DisplayName            string                               `json:"displayName"`
+	AuthenticatorSelection ServerAuthenticatorSelectionCriteria `json:"authenticatorSelection"`
+	Attestation            string                               `json:"attestation"`
+	Extensions             map[string]interface{}               `json:"extensions,omitempty"`
+}
+
+// ServerAuthenticatorSelectionCriteria are the authenticators a registration asks for
+type ServerAuthenticatorSelectionCriteria struct {
+	AuthenticatorAttachment string `json:"authenticatorAttachment"`
+	ResidentKey             string `json:"residentKey"`
+	RequireResidentKey      bool   `json:"requireResidentKey"`
+	UserVerification        string `json:"userVerification"`
+}
+
+// ServerPublicKeyCredentialGetOptionsRequest starts a login, a login without
+// a username uses a discoverable credential
+type ServerPublicKeyCredentialGetOptionsRequest struct {
+	Username         string                 `json:"username"`
+	UserVerification string                 `json:"userVerification"`
+	Extensions       map[string]interface{} `json:"extensions,omitempty"`
+}
@@ -4,14 +4,26 @@ import (
 	"crypto/x509"
 
 	"git.jba.io/go/webauthn/models"
+	"git.jba.io/go/webauthn/trust"
 )
 
-//EncodedAttestationStatement is the authenticator's attestation certificate
+// EncodedAttestationStatement is the authenticator's attestation certificate
 type EncodedAttestationStatement struct {
-	// The attesation certificate in byte form. Returned to us as an array
-	// of byte form certs since there may be more than one.
+	// The COSE algorithm identifier used to create the attestation signature
+	Algorithm int64 `codec:"alg"`
+	// The attesation certificate chain in byte form. The attestation
+	// certificate comes first, followed by any CA certificates.
 	X509Cert  [][]byte `codec:"x5c"`
 	Signature []byte   `codec:"sig"`
+	// The version of the TPM specification followed by a "tpm" statement,
+	// or the version of Google Play Services for "android-safetynet"
+	Version string `codec:"ver"`
+	// The SafetyNet JWS of an "android-safetynet" statement
+	Response []byte `codec:"response"`
+	// The TPMS_ATTEST structure signed over by a "tpm" statement
+	CertInfo []byte `codec:"certInfo"`
+	// The TPMT_PUBLIC structure describing the credential public key
+	PubArea []byte `codec:"pubArea"`
 }
 
 // EncodedAuthData is a CBOR encoded data structure returned to us by the
@@ -24,10 +36,11 @@ type EncodedAuthData struct {
 
 // DecodedAssertionData is the decoded assertion object's data
 type DecodedAssertionData struct {
-	Flags            byte
-	Counter          []byte
+	Flags            AuthenticatorFlags
+	Counter          uint32
 	RawAssertionData []byte
 	RPIDHash         string
+	Extensions       map[string]interface{}
 	Signature        []byte
 }
 
@@ -48,20 +61,37 @@ type DecodedClientData struct {
 // DecodedAttestationStatement - The AttStmt returned by the authenticator's
 // credential response.
 type DecodedAttestationStatement struct {
+	// The COSE algorithm identifier of the attestation signature
+	Algorithm int64
 	// The attestation certificate. This helps us identify the authenticator
 	Certificate *x509.Certificate
-	Signature   []byte
+	// The full x5c chain, starting with the attestation certificate
+	CertificateChain []*x509.Certificate
+	Signature        []byte
+	// The Android key description of an "android-key" attestation certificate
+	AndroidKeyDescription *AndroidKeyDescription
+	// The decoded JWS of an "android-safetynet" attestation statement
+	SafetyNet *SafetyNetResponse
+	// TPM specific fields, still in their TPM wire format
+	Version  string
+	CertInfo []byte
+	PubArea  []byte
 }
 
 // DecodedAuthData - The AuthData returned by the authenticator's
 // credential response.
 type DecodedAuthData struct {
-	Flags        []byte
-	Counter      []byte
+	RawAuthData  []byte
+	Flags        AuthenticatorFlags
+	Counter      uint32
 	RPIDHash     string
 	AAGUID       []byte
 	CredID       []byte
 	PubKey       models.PublicKey
+	RawPubKey    []byte
+	Extensions   map[string]interface{}
 	Format       string
 	AttStatement DecodedAttestationStatement
+	// AttestationTrust is filled in once the attestation statement is verified
+	AttestationTrust trust.Result
 }
@@ -0,0 +1,101 @@
+package request
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// These are the WebAuthn Level 3 JSON encodings of a PublicKeyCredential, what
+// PublicKeyCredential.toJSON() returns in the browser. Every binary value is
+// base64url encoded without padding.
+
+// RegistrationResponseJSON is the credential returned by navigator.credentials.create()
+type RegistrationResponseJSON struct {
+	ID                      string                               `json:"id"`
+	RawID                   string                               `json:"rawId"`
+	Type                    string                               `json:"type"`
+	AuthenticatorAttachment string                               `json:"authenticatorAttachment,omitempty"`
+	Response                AuthenticatorAttestationResponseJSON `json:"response"`
+	ClientExtensionResults  map[string]interface{}               `json:"clientExtensionResults"`
+}
+
+// AuthenticatorAttestationResponseJSON is the response of a RegistrationResponseJSON
+type AuthenticatorAttestationResponseJSON struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+	// The authenticator data and public key are also in the attestation
+	// object, browsers add them for RPs that don't decode CBOR
+	AuthenticatorData  string   `json:"authenticatorData,omitempty"`
+	Transports         []string `json:"transports,omitempty"`
+	PublicKey          string   `json:"publicKey,omitempty"`
+	PublicKeyAlgorithm int64    `json:"publicKeyAlgorithm,omitempty"`
+}
+
+// AuthenticationResponseJSON is the credential returned by navigator.credentials.get()
+type AuthenticationResponseJSON struct {
+	ID                      string                             `json:"id"`
+	RawID                   string                             `json:"rawId"`
+	Type                    string                             `json:"type"`
+	AuthenticatorAttachment string                             `json:"authenticatorAttachment,omitempty"`
+	Response                AuthenticatorAssertionResponseJSON `json:"response"`
+	ClientExtensionResults  map[string]interface{}             `json:"clientExtensionResults"`
+}
+
+// AuthenticatorAssertionResponseJSON is the response of an AuthenticationResponseJSON
+type AuthenticatorAssertionResponseJSON struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	// UserHandle is only set for discoverable credentials
+	UserHandle string `json:"userHandle,omitempty"`
+}
+
+// ErrCredentialIDMismatch is returned when the id and rawId of a credential differ
+var ErrCredentialIDMismatch = errors.New("Credential id and rawId do not match")
+
+// DecodeBase64URL decodes a base64url value. The padding is optional, some
+// older clients still send it.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// checkCredential verifies a credential is a public key and that its id is
+// the encoding of its rawId
+func checkCredential(id, rawID, credType string) error {
+	if credType != "public-key" {
+		return errors.New("Credential type needs to be public-key")
+	}
+	raw, err := DecodeBase64URL(rawID)
+	if err != nil || len(raw) == 0 {
+		return errors.New("Error decoding the credential rawId")
+	}
+	if base64.RawURLEncoding.EncodeToString(raw) != strings.TrimRight(id, "=") {
+		return ErrCredentialIDMismatch
+	}
+	return nil
+}
+
+// Check verifies the credential and its response have what we need
+func (c *RegistrationResponseJSON) Check() error {
+	err := checkCredential(c.ID, c.RawID, c.Type)
+	if err != nil {
+		return err
+	}
+	if c.Response.ClientDataJSON == "" || c.Response.AttestationObject == "" {
+		return errors.New("Missing clientDataJSON or attestationObject")
+	}
+	return nil
+}
+
+// Check verifies the credential and its response have what we need
+func (c *AuthenticationResponseJSON) Check() error {
+	err := checkCredential(c.ID, c.RawID, c.Type)
+	if err != nil {
+		return err
+	}
+	if c.Response.ClientDataJSON == "" || c.Response.AuthenticatorData == "" || c.Response.Signature == "" {
+		return errors.New("Missing clientDataJSON, authenticatorData or signature")
+	}
+	return nil
+}
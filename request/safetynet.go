@@ -0,0 +1,35 @@
+package request
+
+import "crypto/x509"
+
+// SafetyNetResponse is the JWS returned by the SafetyNet Attestation API, as
+// found in the response field of an "android-safetynet" attestation statement
+type SafetyNetResponse struct {
+	Header  SafetyNetHeader
+	Payload SafetyNetPayload
+	// The certificates from the x5c header, starting with the signing certificate
+	Certificates []*x509.Certificate
+	// The base64url encoded header and payload the signature is over
+	SignedData []byte
+	Signature  []byte
+}
+
+// SafetyNetHeader is the JOSE header of a SafetyNet JWS
+type SafetyNetHeader struct {
+	Algorithm string   `json:"alg"`
+	X5C       []string `json:"x5c"`
+}
+
+// SafetyNetPayload is the attestation result signed by Google
+type SafetyNetPayload struct {
+	Nonce                      string   `json:"nonce"`
+	TimestampMs                int64    `json:"timestampMs"`
+	ApkPackageName             string   `json:"apkPackageName"`
+	ApkDigestSha256            string   `json:"apkDigestSha256"`
+	ApkCertificateDigestSha256 []string `json:"apkCertificateDigestSha256"`
+	CtsProfileMatch            bool     `json:"ctsProfileMatch"`
+	BasicIntegrity             bool     `json:"basicIntegrity"`
+	EvaluationType             string   `json:"evaluationType,omitempty"`
+	Advice                     string   `json:"advice,omitempty"`
+	Error                      string   `json:"error,omitempty"`
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"git.jba.io/go/webauthn/config"
+	"git.jba.io/go/webauthn/models"
+)
+
+// Codes of the errors returned when a request's relying party can't be worked out
+const (
+	// ErrCodeUnknownRelyingParty is for an rpId or host without a relying party
+	ErrCodeUnknownRelyingParty = "unknown_relying_party"
+	// ErrCodeInvalidRelyingPartyID is for an rpId that isn't a valid RP ID
+	ErrCodeInvalidRelyingPartyID = "invalid_rp_id"
+	// ErrCodeMissingHost is for a request we can't find a host in
+	ErrCodeMissingHost = "missing_host"
+)
+
+// ResolveError is returned when the relying party of a request can't be
+// worked out. Code is for programs, Message for people.
+type ResolveError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResolveError) Error() string {
+	return e.Message
+}
+
+// RequestHost - The host a request was made to, without a port. The
+// X-Forwarded-Host header is only trusted behind our reverse proxy.
+func RequestHost(r *http.Request) string {
+	host := r.Host
+	if config.Conf.HasProxy {
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			// Every proxy along the way adds its own, the first is the client's
+			host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// ResolveRelyingParty - Work out the relying party a ceremony is for, from
+// the first of:
+//   - the rpId route prefix, /rp/{rpId}/...
+//   - the rpId query or form parameter
+//   - the host of the request, or one of its parent domains
+func ResolveRelyingParty(r *http.Request) (models.RelyingParty, error) {
+	id := mux.Vars(r)["rpId"]
+	if id == "" {
+		id = r.FormValue("rpId")
+	}
+	if id != "" {
+		err := models.ValidRelyingPartyID(id)
+		if err != nil {
+			return models.RelyingParty{}, &ResolveError{Code: ErrCodeInvalidRelyingPartyID, Message: err.Error()}
+		}
+		rp, err := models.GetRelyingPartyByHost(id)
+		if err == models.ErrNotFound {
+			return rp, &ResolveError{Code: ErrCodeUnknownRelyingParty, Message: fmt.Sprintf("No relying party %s", id)}
+		}
+		return rp, err
+	}
+
+	host := RequestHost(r)
+	if host == "" {
+		return models.RelyingParty{}, &ResolveError{Code: ErrCodeMissingHost, Message: "The request has no host to find the relying party with"}
+	}
+	rp, err := models.GetRelyingPartyForHost(host)
+	if err == models.ErrNotFound {
+		return rp, &ResolveError{Code: ErrCodeUnknownRelyingParty, Message: fmt.Sprintf("No relying party for host %s", host)}
+	}
+	return rp, err
+}
+
+// RelyingPartyForRequest - Resolve the relying party of a request, answering
+// with a 400 and the error code when there isn't one. It returns whether the
+// request can go on.
+func RelyingPartyForRequest(w http.ResponseWriter, r *http.Request) (models.RelyingParty, bool) {
+	rp, err := ResolveRelyingParty(r)
+	if resolveErr, ok := err.(*ResolveError); ok {
+		JSONResponse(w, resolveErr, http.StatusBadRequest)
+		return rp, false
+	}
+	if err != nil {
+		fmt.Println("Error resolving the relying party:", err)
+		JSONResponse(w, "Error getting the relying party", http.StatusInternalServerError)
+		return rp, false
+	}
+	return rp, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.jba.io/go/webauthn/config"
+)
+
+func TestRequestHost(t *testing.T) {
+	defer func(hasProxy bool) { config.Conf.HasProxy = hasProxy }(config.Conf.HasProxy)
+
+	r := httptest.NewRequest("GET", "/assertion", nil)
+	r.Host = "Login.Example.com:9005"
+	r.Header.Set("X-Forwarded-Host", "evil.example.org, proxy.example.com")
+
+	config.Conf.HasProxy = false
+	if host := RequestHost(r); host != "login.example.com" {
+		t.Fatalf("Unexpected host without a proxy. Expected login.example.com, Got %s", host)
+	}
+	config.Conf.HasProxy = true
+	if host := RequestHost(r); host != "evil.example.org" {
+		t.Fatalf("Unexpected host behind a proxy. Expected evil.example.org, Got %s", host)
+	}
+}
+
+func (hs *HandlersSuite) TestResolveRelyingParty() {
+	// The test server is reached on 127.0.0.1, the relying party is localhost
+	get := func(path string, host string) (int, ResolveError) {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			hs.T().Fatalf("Unexpected error requesting %s: %s", path, err)
+		}
+		defer resp.Body.Close()
+		resolveErr := ResolveError{}
+		if resp.StatusCode == http.StatusBadRequest {
+			json.NewDecoder(resp.Body).Decode(&resolveErr)
+		}
+		return resp.StatusCode, resolveErr
+	}
+
+	cases := []struct {
+		path, host string
+		status     int
+		code       string
+	}{
+		{"/assertion", "localhost", http.StatusOK, ""},
+		{"/assertion?rpId=localhost", "", http.StatusOK, ""},
+		{"/rp/localhost/assertion", "", http.StatusOK, ""},
+		{"/assertion", "", http.StatusBadRequest, ErrCodeUnknownRelyingParty},
+		{"/assertion?rpId=unknown.example.com", "", http.StatusBadRequest, ErrCodeUnknownRelyingParty},
+		{"/assertion?rpId=co.uk", "", http.StatusBadRequest, ErrCodeInvalidRelyingPartyID},
+		{"/rp/unknown.example.com/assertion", "localhost", http.StatusBadRequest, ErrCodeUnknownRelyingParty},
+	}
+	for _, c := range cases {
+		status, resolveErr := get(c.path, c.host)
+		if status != c.status || resolveErr.Code != c.code {
+			hs.T().Fatalf("Unexpected response for %s on %q. Expected %d %q, Got %d %q", c.path, c.host, c.status, c.code, status, resolveErr.Code)
+		}
+	}
+}
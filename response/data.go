@@ -1,13 +1,64 @@
 package response
 
-import "git.jba.io/go/webauthn/models"
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"time"
 
-import "encoding/base64"
+	"git.jba.io/go/webauthn/models"
+)
 
 // CredentialActionResponse shows the minted credential and success result
 type CredentialActionResponse struct {
-	Success    bool              `json:"success"`
-	Credential models.Credential `json:"credential, omitempty"`
+	Success    bool               `json:"success"`
+	Credential CredentialResponse `json:"credential, omitempty"`
+}
+
+// UserResponse is a user as the API shows it. Users are identified by their
+// user handle, the database ID is never sent.
+type UserResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Icon        string `json:"icon,omitempty"`
+}
+
+// CredentialResponse is a credential as the API shows it
+type CredentialResponse struct {
+	CredID           string       `json:"credential_id,omitempty"`
+	RelyingPartyID   string       `json:"rp_id,omitempty"`
+	User             UserResponse `json:"user"`
+	Type             string       `json:"type,omitempty"`
+	Format           string       `json:"format,omitempty"`
+	SignCount        uint32       `json:"sign_count"`
+	Suspect          bool         `json:"suspect,omitempty"`
+	AttestationTrust string       `json:"attestation_trust,omitempty"`
+}
+
+// RelyingPartyResponse is a relying party with its ceremony settings as the admin API shows it
+type RelyingPartyResponse struct {
+	ID                      string   `json:"id"`
+	DisplayName             string   `json:"display_name"`
+	Icon                    string   `json:"icon,omitempty"`
+	Origins                 []string `json:"origins"`
+	RelatedOrigins          []string `json:"related_origins"`
+	Attestation             string   `json:"attestation,omitempty"`
+	UserVerification        string   `json:"user_verification,omitempty"`
+	Algorithms              []int64  `json:"algorithms"`
+	AuthenticatorAttachment string   `json:"authenticator_attachment,omitempty"`
+	CounterPolicy           string   `json:"counter_policy,omitempty"`
+}
+
+// LoginSessionResponse is a login session as the API shows it, with the
+// credential the user authenticated with
+type LoginSessionResponse struct {
+	ID             int64     `json:"id"`
+	CredID         string    `json:"credential_id"`
+	RelyingPartyID string    `json:"rp_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	// Current is set for the session the request was made with
+	Current bool `json:"current"`
 }
 
 // FormattedCredential struct for user viewing
@@ -15,9 +66,12 @@ type FormattedCredential struct {
 	CreateDate string `json:"create_date"`
 	CredID     string `json:"id"`
 	CredType   string `json:"type"`
+	// PubKeyType is the COSE key type (EC2, OKP or RSA) and PubKeyAlg the
+	// COSE algorithm the key signs with
 	PubKeyType string `json:"pk_type"`
-	PubKeyX    string `json:"pk_x"`
-	PubKeyY    string `json:"pk_y"`
+	PubKeyAlg  string `json:"pk_alg"`
+	// PubKey is the base64 DER SubjectPublicKeyInfo, whatever the key type
+	PubKey string `json:"pk"`
 }
 
 // MakeOptionRelyingParty is the relying party requesting the credential
@@ -28,49 +82,213 @@ type MakeOptionRelyingParty struct {
 
 // MakeOptionUser is the user requesting the credential
 type MakeOptionUser struct {
-	Name        string `json:"name,omitempty"`
-	DisplayName string `json:"displayName,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
 	Icon        string `json:"icon,omitempty"`
-	ID          int64  `json:"id,omitempty"`
+	// ID is the base64url user handle, not the database ID of the user
+	ID string `json:"id"`
+}
+
+// CredentialDescriptor identifies a credential in allowCredentials and excludeCredentials
+type CredentialDescriptor struct {
+	CredID     string   `json:"id"`
+	Type       string   `json:"type"`
+	Transports []string `json:"transports,omitempty"`
 }
 
 // CredentialParameter is the credential type and alg being requested
 type CredentialParameter struct {
 	Type      string `json:"type,omitempty"`
-	Algorithm string `json:"alg,omitempty"`
+	Algorithm int64  `json:"alg,omitempty"`
 }
 
 // AuthenticatorSelection denotes specific requests of the authenticator
 type AuthenticatorSelection struct {
-	AuthenticatorAttachment string `json:"authenticatorAttachment"`
-	RequireResidentKey      bool   `json:"requireResidentKey"`
-	UserVerification        string `json:"userVerification"`
+	// AuthenticatorAttachment is platform or cross-platform, empty allows both
+	AuthenticatorAttachment string `json:"authenticatorAttachment,omitempty"`
+	// ResidentKey is discouraged, preferred or required. RequireResidentKey
+	// is still sent for Level 1 clients and is true when it is required.
+	ResidentKey        string `json:"residentKey,omitempty"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
 }
 
-// The Boolean value true to indicate that an extension is requested by the Relying Party
-// This is currently in development
-type Extensions struct {
-	Extensions bool `json:"exts"`
-}
-
-// MakeCredentialResponse The response payload provided on the request of a new credential
+// MakeCredentialResponse The response payload provided on the request of a new
+// credential. It's a PublicKeyCredentialCreationOptionsJSON, so browsers can
+// turn it into options with PublicKeyCredential.parseCreationOptionsFromJSON().
 type MakeCredentialResponse struct {
-	Challenge              []byte                 `json:"challenge"`
+	// Challenge is base64url encoded like every other binary value
+	Challenge              string                 `json:"challenge"`
 	RP                     MakeOptionRelyingParty `json:"rp"`
 	User                   MakeOptionUser         `json:"user"`
 	Parameters             []CredentialParameter  `json:"pubKeyCredParams,omitempty"`
 	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection,omitempty"`
 	Timeout                int                    `json:"timeout,omitempty"`
-	ExcludeList            []string               `json:"excludeCredentials,omitempty"`
-	Extensions             Extensions             `json:"extenstions,omitempty"`
-	AttestationType        string                 `json:"attestation,omitempty"`
+	ExcludeList            []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	// Extensions are the client extension inputs, we don't ask for any
+	Extensions      map[string]interface{} `json:"extensions,omitempty"`
+	AttestationType string                 `json:"attestation,omitempty"`
+}
+
+// AssertionOptionsResponse are the options of a login. It's a
+// PublicKeyCredentialRequestOptionsJSON, so browsers can turn it into options
+// with PublicKeyCredential.parseRequestOptionsFromJSON().
+type AssertionOptionsResponse struct {
+	// Challenge is base64url encoded like every other binary value
+	Challenge string `json:"challenge"`
+	Timeout   int    `json:"timeout,omitempty"`
+	RPID      string `json:"rpId,omitempty"`
+	// An empty list lets the authenticator choose a discoverable credential
+	AllowList        []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// ServerResponse is the envelope of every FIDO2 conformance API response,
+// Status is ok or failed and ErrorMessage says why it failed
+type ServerResponse struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// ServerOK is the envelope of a successful conformance API response
+var ServerOK = ServerResponse{Status: "ok", ErrorMessage: ""}
+
+// ServerFailed is the envelope of a failed conformance API response
+func ServerFailed(message string) ServerResponse {
+	return ServerResponse{Status: "failed", ErrorMessage: message}
+}
+
+// ServerPublicKeyCredentialCreationOptionsResponse are the registration
+// options of the conformance API, the extensions are the ones the client
+// asked for
+type ServerPublicKeyCredentialCreationOptionsResponse struct {
+	ServerResponse
+	MakeCredentialResponse
+}
+
+// ServerPublicKeyCredentialGetOptionsResponse are the login options of the
+// conformance API
+type ServerPublicKeyCredentialGetOptionsResponse struct {
+	ServerResponse
+	AssertionOptionsResponse
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// EncodeBase64URL encodes a binary value the way the WebAuthn JSON types do,
+// base64url without padding
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// FormatUser creates the user for the API
+func FormatUser(u models.User) UserResponse {
+	return UserResponse{
+		ID:          EncodeBase64URL(u.Handle),
+		Name:        u.Name,
+		DisplayName: u.DisplayName,
+		Icon:        u.Icon,
+	}
+}
+
+// FormatCredential creates the credential for the API
+func FormatCredential(c models.Credential) CredentialResponse {
+	return CredentialResponse{
+		CredID:           c.CredID,
+		RelyingPartyID:   c.RelyingPartyID,
+		User:             FormatUser(c.User),
+		Type:             c.Type,
+		Format:           c.Format,
+		SignCount:        c.Counter,
+		Suspect:          c.Suspect,
+		AttestationTrust: c.AttestationTrust,
+	}
+}
+
+// FormatCredentialList creates the credentials for the API
+func FormatCredentialList(creds []models.Credential) []CredentialResponse {
+	crs := make([]CredentialResponse, len(creds))
+	for x, cred := range creds {
+		crs[x] = FormatCredential(cred)
+	}
+	return crs
+}
+
+// FormatRelyingParty creates the relying party for the admin API
+func FormatRelyingParty(rp models.RelyingParty) RelyingPartyResponse {
+	rpr := RelyingPartyResponse{
+		ID:                      rp.ID,
+		DisplayName:             rp.DisplayName,
+		Icon:                    rp.Icon,
+		Origins:                 rp.Origins,
+		RelatedOrigins:          rp.RelatedOrigins,
+		Attestation:             rp.Attestation,
+		UserVerification:        rp.UserVerification,
+		Algorithms:              rp.Algorithms,
+		AuthenticatorAttachment: rp.AuthenticatorAttachment,
+		CounterPolicy:           rp.CounterPolicy,
+	}
+	if rpr.Origins == nil {
+		rpr.Origins = []string{}
+	}
+	if rpr.RelatedOrigins == nil {
+		rpr.RelatedOrigins = []string{}
+	}
+	if rpr.Algorithms == nil {
+		rpr.Algorithms = []int64{}
+	}
+	return rpr
+}
+
+// FormatRelyingParties creates the relying parties for the admin API
+func FormatRelyingParties(rps []models.RelyingParty) []RelyingPartyResponse {
+	rprs := make([]RelyingPartyResponse, len(rps))
+	for x, rp := range rps {
+		rprs[x] = FormatRelyingParty(rp)
+	}
+	return rprs
+}
+
+// FormatLoginSessions creates the login sessions for the API, current is the
+// ID of the session the request was made with
+func FormatLoginSessions(sessions []models.LoginSession, current int64) []LoginSessionResponse {
+	lsrs := make([]LoginSessionResponse, len(sessions))
+	for x, ls := range sessions {
+		lsrs[x] = LoginSessionResponse{
+			ID:             ls.ID,
+			CredID:         ls.CredID,
+			RelyingPartyID: ls.RelyingPartyID,
+			CreatedAt:      ls.CreatedAt,
+			ExpiresAt:      ls.ExpiresAt,
+			Current:        ls.ID == current,
+		}
+	}
+	return lsrs
+}
+
+// FormatCredentialDescriptors lists credentials for allowCredentials or excludeCredentials
+func FormatCredentialDescriptors(creds []models.Credential) []CredentialDescriptor {
+	cds := []CredentialDescriptor{}
+	for _, cred := range creds {
+		cds = append(cds, CredentialDescriptor{
+			CredID:     cred.CredID,
+			Type:       "public-key", // This should always be type 'public-key' for now
+			Transports: []string{"usb", "nfc", "ble"},
+		})
+	}
+	return cds
 }
 
 // FormatCredentials creates the formatted credential for viewing
 func FormatCredentials(creds []models.Credential) ([]FormattedCredential, error) {
 	fcs := make([]FormattedCredential, len(creds))
 	for x, cred := range creds {
-		pk, err := models.GetUnformattedPublicKeyForCredential(&cred)
+		// The public key is stored with the credential
+		pk := cred.PublicKey
+		pub, err := pk.CryptoPublicKey()
+		if err != nil {
+			return nil, err
+		}
+		spki, err := x509.MarshalPKIXPublicKey(pub)
 		if err != nil {
 			return nil, err
 		}
@@ -78,9 +296,9 @@ func FormatCredentials(creds []models.Credential) ([]FormattedCredential, error)
 			CreateDate: cred.CreatedAt.Format("Mon, 3:04PM MST"),
 			CredID:     cred.CredID,
 			CredType:   cred.Format,
-			PubKeyType: string(pk.Type),
-			PubKeyX:    base64.URLEncoding.EncodeToString(pk.XCoord),
-			PubKeyY:    base64.URLEncoding.EncodeToString(pk.YCoord),
+			PubKeyType: models.COSEKeyTypeName(pk.KeyType),
+			PubKeyAlg:  models.COSEAlgorithmName(pk.Algorithm()),
+			PubKey:     base64.StdEncoding.EncodeToString(spki),
 		}
 	}
 	return fcs, nil
@@ -1,27 +1,84 @@
-function hexEncode(buf) {
-    return Array.from(buf)
-        .map(function (x) {
-            return ("0" + x.toString(16)).substr(-2)
-        })
-        .join("");
-}
-
-function hexDecode(str) {
-    return new Uint8Array(str.match(/../g).map(function (x) { return parseInt(x, 16) }));
-}
-
+// Binary values are base64url encoded without padding, like the WebAuthn JSON types
 function b64enc(buf) {
-    return base64js.fromByteArray(buf)
+    return base64js.fromByteArray(new Uint8Array(buf))
         .replace(/\+/g, "-")
         .replace(/\//g, "_")
         .replace(/=/g, "");
 }
 
-// Don't drop any blanks
-function b64RawEnc(buf) {
-    return base64js.fromByteArray(buf)
-        .replace(/\+/g, "-")
-        .replace(/\//g, "_");
+function b64dec(str) {
+    str = str.replace(/-/g, "+").replace(/_/g, "/");
+    while (str.length % 4) {
+        str += "=";
+    }
+    return base64js.toByteArray(str);
+}
+
+// The server sends PublicKeyCredentialCreationOptionsJSON, browsers that
+// don't have parseCreationOptionsFromJSON yet get the binary values decoded here
+function creationOptionsFromJSON(options) {
+    if (window.PublicKeyCredential && PublicKeyCredential.parseCreationOptionsFromJSON) {
+        return PublicKeyCredential.parseCreationOptionsFromJSON(options);
+    }
+    options.challenge = b64dec(options.challenge);
+    options.user.id = b64dec(options.user.id);
+    (options.excludeCredentials || []).forEach(function (listItem) {
+        listItem.id = b64dec(listItem.id);
+    });
+    return options;
+}
+
+// Same for PublicKeyCredentialRequestOptionsJSON
+function requestOptionsFromJSON(options) {
+    if (window.PublicKeyCredential && PublicKeyCredential.parseRequestOptionsFromJSON) {
+        return PublicKeyCredential.parseRequestOptionsFromJSON(options);
+    }
+    options.challenge = b64dec(options.challenge);
+    (options.allowCredentials || []).forEach(function (listItem) {
+        listItem.id = b64dec(listItem.id);
+    });
+    return options;
+}
+
+// The RegistrationResponseJSON or AuthenticationResponseJSON of a credential
+function credentialToJSON(credential) {
+    if (credential.toJSON) {
+        return credential.toJSON();
+    }
+    var response = {
+        clientDataJSON: b64enc(credential.response.clientDataJSON),
+    };
+    if (credential.response.attestationObject) {
+        response.attestationObject = b64enc(credential.response.attestationObject);
+        if (credential.response.getTransports) {
+            response.transports = credential.response.getTransports();
+        }
+    } else {
+        response.authenticatorData = b64enc(credential.response.authenticatorData);
+        response.signature = b64enc(credential.response.signature);
+        if (credential.response.userHandle) {
+            response.userHandle = b64enc(credential.response.userHandle);
+        }
+    }
+    return {
+        id: credential.id,
+        rawId: b64enc(credential.rawId),
+        type: credential.type,
+        authenticatorAttachment: credential.authenticatorAttachment || undefined,
+        response: response,
+        clientExtensionResults: credential.getClientExtensionResults(),
+    };
+}
+
+// Credentials are posted as JSON
+function postCredential(url, credential) {
+    return $.ajax({
+        url: url,
+        type: 'POST',
+        contentType: 'application/json',
+        dataType: 'json',
+        data: JSON.stringify(credentialToJSON(credential)),
+    });
 }
 
 function string2buffer(str) {
@@ -98,23 +155,21 @@ function makeCredential() {
     });
 
     var attestation_type = $('#select-attestation').find(':selected').val();    
+    var resident_key = $('#select-resident-key').find(':selected').val();
 
-    $.get('/makeCredential/' + state.user.name, {attType: attestation_type}, null, 'json')
+    $.get('/makeCredential/' + state.user.name, {attType: attestation_type, residentKey: resident_key}, null, 'json')
         .done(function (makeCredentialOptions) {
             console.log("Credential Options Object");
             console.log(makeCredentialOptions);
 
-            // Turn the challenge back into the accepted format
-            makeCredentialOptions.challenge = Uint8Array.from(atob(makeCredentialOptions.challenge), c => c.charCodeAt(0));
-            // Turn ID into a UInt8Array Buffer for some reason
-            makeCredentialOptions.user.id = Uint8Array.from(makeCredentialOptions.challenge)
-
-            console.log("Credential Options Formatted");
-            console.log(makeCredentialOptions);
+            // The user handle is returned as the userHandle when logging in
+            // without a username, excludeCredentials keeps the same
+            // authenticator from being registered twice
+            var publicKey = creationOptionsFromJSON(makeCredentialOptions);
 
             console.log("Creating PublicKeyCredential");
             navigator.credentials.create({
-                publicKey: makeCredentialOptions
+                publicKey: publicKey
             }).then(function (newCredential) {
                     console.log("PublicKeyCredential Created");
                     console.log(newCredential);
@@ -123,6 +178,9 @@ function makeCredential() {
                     swal.clickConfirm()
             }).catch(function (err) {
                 console.log(err);
+                if (err.name === "InvalidStateError") {
+                    showErrorAlert("This authenticator is already registered");
+                }
                 swal.closeModal();
             });
         });
@@ -130,24 +188,15 @@ function makeCredential() {
 
 // This should be used to verify the auth data with the server
 function registerNewCredential(newCredential) {
-    // Move data into Arrays incase it is super long
-    let attestationObject = new Uint8Array(newCredential.response.attestationObject);
-    let clientDataJSON = new Uint8Array(newCredential.response.clientDataJSON);
-    let rawId = new Uint8Array(newCredential.rawId);
-
-    $.post('/makeCredential', {
-        id: newCredential.id,
-        rawId: b64enc(rawId),
-        type: newCredential.type,
-        attObj: b64RawEnc(attestationObject),
-        clientData: b64RawEnc(clientDataJSON),
-     }).done(function(response){
+    postCredential('/makeCredential', newCredential).done(function(response){
         if (response.success) {
-            window.location.href = "/dashboard/" + state.user.displayName;
+            window.location.href = "/dashboard";
         } else {
             console.log("Error creating credential");
             console.log(response);
         }
+    }).fail(function(error) {
+        showErrorAlert(error.responseJSON || error.responseText);
     });
 }
 
@@ -167,42 +216,61 @@ function getAssertion() {
         return;
     }
     setUser();
-    $.get('/user/' + state.user.name, {}, null, 'json').done(function (response) {
-        console.log(response);
-    }).then(function() {        
+    // The user details are only available after logging in, so we go
+    // straight to the assertion which fails for unknown users
+    swal({
+        title: 'Logging In...',
+        text: 'Tap your security key to login.',
+        imageUrl: "/images/securitykey.min.svg",
+        showCancelButton: true,
+        showConfirmButton: false,
+        focusConfirm: false,
+        focusCancel: false,        
+    }).then(function () {
         swal({
-            title: 'Logging In...',
-            text: 'Tap your security key to login.',
-            imageUrl: "/images/securitykey.min.svg",
-            showCancelButton: true,
-            showConfirmButton: false,
-            focusConfirm: false,
-            focusCancel: false,        
-        }).then(function () {
-            swal({
-                title: 'Logged In!',
-                text: 'You\'re logged in successfully.',
-                type: 'success',
-                timer: 2000
-            })
-        }).catch(function(error) {
-            console.log("Modal Error: " + error);
-        });
-    }).catch(function(error) {    
-        showErrorAlert(error.responseText);        
-        return;
+            title: 'Logged In!',
+            text: 'You\'re logged in successfully.',
+            type: 'success',
+            timer: 2000
+        })
+    }).catch(function(error) {
+        console.log("Modal Error: " + error);
     });
 
-    $.get('/assertion/' + state.user.name, {
+    requestAssertion('/assertion/' + state.user.name);
+}
+
+// Login with a discoverable credential, the authenticator tells us who the user is
+function getUsernamelessAssertion() {
+    hideErrorAlert();
+    swal({
+        title: 'Logging In...',
+        text: 'Tap your security key to login.',
+        imageUrl: "/images/securitykey.min.svg",
+        showCancelButton: true,
+        showConfirmButton: false,
+        focusConfirm: false,
+        focusCancel: false,
+    }).then(function () {
+        swal({
+            title: 'Logged In!',
+            text: 'You\'re logged in successfully.',
+            type: 'success',
+            timer: 2000
+        })
+    }).catch(function(error) {
+        console.log("Modal Error: " + error);
+    });
+
+    requestAssertion('/assertion');
+}
+
+function requestAssertion(url) {
+    $.get(url, {
     }, null, 'json')
         .done(function (makeAssertionOptions) {
-            makeAssertionOptions.challenge = Uint8Array.from(atob(makeAssertionOptions.challenge), c => c.charCodeAt(0));
-            makeAssertionOptions.allowCredentials.forEach(function (listItem) {
-                var fixedId = listItem.id.replace(/\_/g, "/").replace(/\-/g, "+")
-                listItem.id = Uint8Array.from(atob(fixedId), c => c.charCodeAt(0));
-            });
             console.log(makeAssertionOptions);
-            navigator.credentials.get({ publicKey: makeAssertionOptions })
+            navigator.credentials.get({ publicKey: requestOptionsFromJSON(makeAssertionOptions) })
                 .then(function (credential) {
                     console.log(credential);
                     verifyAssertion(credential);
@@ -216,22 +284,10 @@ function getAssertion() {
 }
 
 function verifyAssertion(assertedCredential) {
-    // Move data into Arrays incase it is super long
-    let authData = new Uint8Array(assertedCredential.response.authenticatorData);
-    let clientDataJSON = new Uint8Array(assertedCredential.response.clientDataJSON);
-    let rawId = new Uint8Array(assertedCredential.rawId);
-    let sig = new Uint8Array(assertedCredential.response.signature);
-    $.post('/assertion', {
-        id: assertedCredential.id,
-        rawId: b64enc(rawId),
-        type: assertedCredential.type,
-        authData: b64RawEnc(authData),
-        clientData: b64RawEnc(clientDataJSON),
-        signature: hexEncode(sig),
-     }).done(function(response){
+    postCredential('/assertion', assertedCredential).done(function(response){
         console.log(response)
         if (response.success) {
-            window.location.href = "/dashboard/" + state.user.displayName;
+            window.location.href = "/dashboard";
         } else {
             showErrorAlert("Error Doing Assertion");
             swal.closeModal();
@@ -239,6 +295,39 @@ function verifyAssertion(assertedCredential) {
     });
 }
 
+// Deleting the last credential locks the user out, so the server asks us to confirm
+function deleteCredential(credID, confirmed) {
+    $.ajax({
+        url: '/credential/' + encodeURIComponent(credID) + (confirmed ? '?confirm=true' : ''),
+        type: 'DELETE',
+    }).done(function () {
+        window.location.reload();
+    }).fail(function (error) {
+        if (error.status === 409 && confirm("This is your last authenticator, you won't be able to log in without it. Delete it anyway?")) {
+            deleteCredential(credID, true);
+            return;
+        }
+        console.log(error.responseJSON || error.responseText);
+    });
+}
+
+function logout() {
+    $.post('/logout').always(function () {
+        window.location.href = "/";
+    });
+}
+
+function revokeSession(id) {
+    $.ajax({
+        url: '/sessions/' + id,
+        type: 'DELETE',
+    }).done(function () {
+        window.location.reload();
+    }).fail(function (error) {
+        console.log(error.responseJSON || error.responseText);
+    });
+}
+
 function setCurrentUser(userResponse) {
     state.user.name = userResponse.name;
     state.user.displayName = userResponse.display_name;
@@ -0,0 +1,230 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is how far an attestation statement could be trusted, recorded
+// with the credential at registration
+type Result string
+
+// The attestation trust results
+const (
+	// ResultNone - the authenticator didn't provide an attestation statement
+	ResultNone Result = "none"
+	// ResultSelf - the statement was signed by the credential key itself
+	ResultSelf Result = "self"
+	// ResultBasic - the attestation certificate chains to a trust anchor
+	ResultBasic Result = "basic"
+	// ResultAttCA - the attestation certificate was issued by an attestation
+	// CA and chains to a trust anchor
+	ResultAttCA Result = "attca"
+	// ResultUntrusted - the statement is valid but no trust anchor vouches for it
+	ResultUntrusted Result = "untrusted"
+)
+
+// ErrNoTrustAnchors is returned when nothing is known about an authenticator
+var ErrNoTrustAnchors = errors.New("No trust anchors for this authenticator")
+
+var (
+	aaguidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+	keyIDPattern  = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+)
+
+// Source provides trust anchors from outside the store, like the FIDO
+// Metadata Service
+type Source interface {
+	Anchors(aaguid, keyID []byte) []*x509.Certificate
+}
+
+// Store holds the trust anchors for attestation certificates. Anchors are
+// looked up by the AAGUID of the authenticator, or for authenticators without
+// one (like fido-u2f) by the key identifier of the attestation certificate.
+type Store struct {
+	mu      sync.RWMutex
+	aaguids map[string][]*x509.Certificate
+	keyIDs  map[string][]*x509.Certificate
+	sources []Source
+}
+
+// NewStore creates an empty trust anchor store
+func NewStore() *Store {
+	return &Store{
+		aaguids: make(map[string][]*x509.Certificate),
+		keyIDs:  make(map[string][]*x509.Certificate),
+	}
+}
+
+// AddAAGUID trusts certs for authenticators with the given AAGUID
+func (s *Store) AddAAGUID(aaguid []byte, certs ...*x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := hex.EncodeToString(aaguid)
+	s.aaguids[key] = append(s.aaguids[key], certs...)
+}
+
+// AddKeyID trusts certs for attestation certificates with the given key identifier
+func (s *Store) AddKeyID(keyID []byte, certs ...*x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := hex.EncodeToString(keyID)
+	s.keyIDs[key] = append(s.keyIDs[key], certs...)
+}
+
+// AddSource looks up trust anchors in src as well as in the store
+func (s *Store) AddSource(src Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = append(s.sources, src)
+}
+
+// Add trusts certs for identifier, which is either an AAGUID in UUID form or
+// a hex encoded attestation certificate key identifier
+func (s *Store) Add(identifier string, certs ...*x509.Certificate) error {
+	switch {
+	case keyIDPattern.MatchString(identifier):
+		keyID, _ := hex.DecodeString(identifier)
+		s.AddKeyID(keyID, certs...)
+	case aaguidPattern.MatchString(identifier):
+		aaguid, _ := hex.DecodeString(strings.Replace(identifier, "-", "", -1))
+		s.AddAAGUID(aaguid, certs...)
+	default:
+		return fmt.Errorf("%s is not an AAGUID or attestation key identifier", identifier)
+	}
+	return nil
+}
+
+// LoadFile adds the PEM encoded certificates in path as trust anchors for identifier
+func (s *Store) LoadFile(identifier, path string) error {
+	pemCerts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	certs, err := ParsePEMCertificates(pemCerts)
+	if err != nil {
+		return fmt.Errorf("Error loading %s: %s", path, err)
+	}
+	return s.Add(identifier, certs...)
+}
+
+// LoadDir adds every <identifier>.pem file in dir as trust anchors for that
+// identifier, so a directory can look like:
+//
+//	cb69481e-8ff7-4039-93ec-0a2729a154a8.pem
+//	e2d3d5d9bbce7f5ae1a8e4d1a6e1ec41a9e5c4b9.pem
+func (s *Store) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		identifier := strings.TrimSuffix(filepath.Base(path), ".pem")
+		err = s.LoadFile(identifier, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Anchors returns the trust anchors for an authenticator with the given
+// AAGUID and attestation certificate
+func (s *Store) Anchors(aaguid []byte, attCert *x509.Certificate) []*x509.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var anchors []*x509.Certificate
+	if len(aaguid) != 0 && !bytes.Equal(aaguid, make([]byte, len(aaguid))) {
+		anchors = append(anchors, s.aaguids[hex.EncodeToString(aaguid)]...)
+	}
+	var keyID []byte
+	if attCert != nil {
+		keyID, _ = KeyIdentifier(attCert)
+		if keyID != nil {
+			anchors = append(anchors, s.keyIDs[hex.EncodeToString(keyID)]...)
+		}
+	}
+	for _, src := range s.sources {
+		anchors = append(anchors, src.Anchors(aaguid, keyID)...)
+	}
+	return anchors
+}
+
+// Verify validates the x5c chain of an attestation statement, leaf first,
+// against the trust anchors for the authenticator at the given time
+func (s *Store) Verify(aaguid []byte, chain []*x509.Certificate, now time.Time) error {
+	if len(chain) == 0 {
+		return errors.New("Attestation statement has no certificates")
+	}
+	anchors := s.Anchors(aaguid, chain[0])
+	if len(anchors) == 0 {
+		return ErrNoTrustAnchors
+	}
+	roots := x509.NewCertPool()
+	for _, anchor := range anchors {
+		roots.AddCert(anchor)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// KeyIdentifier is the attestationCertificateKeyIdentifier of cert, the SHA-1
+// of the public key bit string
+func KeyIdentifier(cert *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, err
+	}
+	keyID := sha1.Sum(spki.PublicKey.Bytes)
+	return keyID[:], nil
+}
+
+// ParsePEMCertificates parses every certificate in a PEM bundle
+func ParsePEMCertificates(pemCerts []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemCerts = pem.Decode(pemCerts)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificates found")
+	}
+	return certs, nil
+}
@@ -0,0 +1,152 @@
+package trust
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TrustSuite struct {
+	suite.Suite
+	root         *x509.Certificate
+	leaf         *x509.Certificate
+	intermediate *x509.Certificate
+}
+
+var testAAGUID, _ = hex.DecodeString("cb69481e8ff7403993ec0a2729a154a8")
+
+func makeTestCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		panic(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func (ts *TrustSuite) SetupTest() {
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	root, rootKey := makeTestCertificate(ca, nil, nil)
+	intermediate, intermediateKey := makeTestCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root, rootKey)
+	leaf, _ := makeTestCertificate(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "Test Attestation"},
+	}, intermediate, intermediateKey)
+	ts.root = root
+	ts.intermediate = intermediate
+	ts.leaf = leaf
+}
+
+func (ts *TrustSuite) TestVerifyByAAGUID() {
+	s := NewStore()
+	chain := []*x509.Certificate{ts.leaf, ts.intermediate}
+	err := s.Verify(testAAGUID, chain, time.Now())
+	if err != ErrNoTrustAnchors {
+		ts.T().Fatalf("Expected ErrNoTrustAnchors for an empty store. Got: %v", err)
+	}
+
+	s.AddAAGUID(testAAGUID, ts.root)
+	err = s.Verify(testAAGUID, chain, time.Now())
+	if err != nil {
+		ts.T().Fatalf("Unexpected error verifying chain: %s", err)
+	}
+
+	// The intermediate is needed to get to the root
+	err = s.Verify(testAAGUID, chain[:1], time.Now())
+	if err == nil {
+		ts.T().Fatalf("Expected an error for an incomplete chain")
+	}
+
+	err = s.Verify(testAAGUID, chain, time.Now().Add(24*time.Hour))
+	if err == nil {
+		ts.T().Fatalf("Expected an error for an expired chain")
+	}
+}
+
+func (ts *TrustSuite) TestVerifyByKeyIdentifier() {
+	s := NewStore()
+	keyID, err := KeyIdentifier(ts.leaf)
+	if err != nil {
+		ts.T().Fatalf("Unexpected error getting key identifier: %s", err)
+	}
+	// fido-u2f authenticators have an all zero AAGUID
+	zeroAAGUID := make([]byte, 16)
+	s.AddAAGUID(zeroAAGUID, ts.root)
+	err = s.Verify(zeroAAGUID, []*x509.Certificate{ts.leaf, ts.intermediate}, time.Now())
+	if err != ErrNoTrustAnchors {
+		ts.T().Fatalf("Expected the zero AAGUID to be ignored. Got: %v", err)
+	}
+
+	err = s.Add(hex.EncodeToString(keyID), ts.root)
+	if err != nil {
+		ts.T().Fatalf("Unexpected error adding key identifier: %s", err)
+	}
+	err = s.Verify(zeroAAGUID, []*x509.Certificate{ts.leaf, ts.intermediate}, time.Now())
+	if err != nil {
+		ts.T().Fatalf("Unexpected error verifying chain: %s", err)
+	}
+}
+
+func (ts *TrustSuite) TestLoadDir() {
+	dir, err := ioutil.TempDir("", "trust")
+	if err != nil {
+		ts.T().Fatalf("Unexpected error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pemRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.root.Raw})
+	err = ioutil.WriteFile(filepath.Join(dir, "cb69481e-8ff7-4039-93ec-0a2729a154a8.pem"), pemRoot, 0600)
+	if err != nil {
+		ts.T().Fatalf("Unexpected error writing anchor: %s", err)
+	}
+
+	s := NewStore()
+	err = s.LoadDir(dir)
+	if err != nil {
+		ts.T().Fatalf("Unexpected error loading directory: %s", err)
+	}
+	if len(s.Anchors(testAAGUID, nil)) != 1 {
+		ts.T().Fatalf("Expected one anchor for the AAGUID")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "not-an-identifier.pem"), pemRoot, 0600)
+	if err != nil {
+		ts.T().Fatalf("Unexpected error writing anchor: %s", err)
+	}
+	err = NewStore().LoadDir(dir)
+	if err == nil {
+		ts.T().Fatalf("Expected an error for a file not named after an identifier")
+	}
+}
+
+func TestRunTrustSuite(t *testing.T) {
+	suite.Run(t, new(TrustSuite))
+}